@@ -0,0 +1,353 @@
+#include <gtk/gtk.h>
+#include <stdio.h>
+#include <string.h>
+
+/*
+ * The input method definitions are read from stdin and the edited ones are
+ * written to stdout when the user saves them, one section per input method:
+ *
+ *   [Telex]
+ *   a	A_Â
+ *   s	DauSac
+ *
+ * The engine binary given as the first argument validates the definition being
+ * edited and types the test keys with it (ibus-engine-bamboo -validate-im).
+ */
+
+typedef struct {
+  gchar *name;
+  gchar *body;
+} InputMethod;
+
+GPtrArray *input_methods;
+int current = -1;
+char *engine_path = NULL;
+
+GtkWidget *im_combo;
+GtkWidget *name_entry;
+GtkWidget *test_entry;
+GtkWidget *output_label;
+GtkWidget *issues_label;
+GtkTextBuffer *text_buff;
+
+/*
+ * Destroy
+ *
+ * Close down the application
+ */
+gint close_window_cb(GtkWidget *widget, gpointer *data) {
+  gtk_main_quit();
+  return FALSE;
+}
+
+InputMethod *input_method_new(const gchar *name, const gchar *body) {
+  InputMethod *im = g_new0(InputMethod, 1);
+  im->name = g_strdup(name);
+  im->body = g_strdup(body);
+  return im;
+}
+
+void input_method_free(gpointer data) {
+  InputMethod *im = data;
+  g_free(im->name);
+  g_free(im->body);
+  g_free(im);
+}
+
+InputMethod *get_input_method(int i) {
+  return g_ptr_array_index(input_methods, i);
+}
+
+int find_input_method(const gchar *name) {
+  for (guint i = 0; i < input_methods->len; i++) {
+    if (g_strcmp0(get_input_method(i)->name, name) == 0) {
+      return i;
+    }
+  }
+  return -1;
+}
+
+/*
+ * read_input_methods
+ *
+ * Split the text read from stdin into sections
+ */
+void read_input_methods(const gchar *text) {
+  gchar **lines = g_strsplit(text, "\n", -1);
+  GString *body = NULL;
+  gchar *name = NULL;
+  for (int i = 0; lines[i] != NULL; i++) {
+    gchar *line = g_strstrip(lines[i]);
+    int len = strlen(line);
+    if (len > 2 && line[0] == '[' && line[len - 1] == ']') {
+      if (name != NULL) {
+        g_ptr_array_add(input_methods, input_method_new(name, body->str));
+        g_free(name);
+        g_string_free(body, TRUE);
+      }
+      name = g_strndup(line + 1, len - 2);
+      body = g_string_new("");
+    } else if (name != NULL && len > 0) {
+      g_string_append_printf(body, "%s\n", line);
+    }
+  }
+  if (name != NULL) {
+    g_ptr_array_add(input_methods, input_method_new(name, body->str));
+    g_free(name);
+    g_string_free(body, TRUE);
+  }
+  g_strfreev(lines);
+}
+
+gchar *get_text_buffer_content() {
+  GtkTextIter start, end;
+  gtk_text_buffer_get_bounds(text_buff, &start, &end);
+  return gtk_text_buffer_get_text(text_buff, &start, &end, FALSE);
+}
+
+/*
+ * store_current_input_method
+ *
+ * Keep the text of the editor before switching to another input method
+ */
+void store_current_input_method() {
+  if (current < 0) {
+    return;
+  }
+  InputMethod *im = get_input_method(current);
+  g_free(im->body);
+  im->body = get_text_buffer_content();
+}
+
+/*
+ * validate_cb
+ *
+ * Run the engine on the definition being edited, the first line of its output
+ * is the text typed with the test keys, the next ones are the problems found.
+ */
+void validate_cb(GtkWidget *widget, gpointer data) {
+  if (current < 0 || engine_path == NULL) {
+    return;
+  }
+  gchar *body = get_text_buffer_content();
+  gchar *input = g_strdup_printf("[%s]\n%s", get_input_method(current)->name, body);
+  const gchar *keys = gtk_entry_get_text(GTK_ENTRY(test_entry));
+  const gchar *argv[] = {engine_path, "-validate-im", "--", keys, NULL};
+  GError *error = NULL;
+  gchar *out = NULL;
+  GSubprocess *proc = g_subprocess_newv(argv, G_SUBPROCESS_FLAGS_STDIN_PIPE | G_SUBPROCESS_FLAGS_STDOUT_PIPE, &error);
+  if (proc != NULL) {
+    g_subprocess_communicate_utf8(proc, input, NULL, &out, NULL, &error);
+    g_object_unref(proc);
+  }
+  if (error != NULL) {
+    gtk_label_set_text(GTK_LABEL(issues_label), error->message);
+    g_error_free(error);
+  } else if (out != NULL) {
+    GString *issues = g_string_new("");
+    gchar **lines = g_strsplit(out, "\n", -1);
+    gtk_label_set_text(GTK_LABEL(output_label), "");
+    for (int i = 0; lines[i] != NULL; i++) {
+      if (g_str_has_prefix(lines[i], "OUTPUT\t")) {
+        gtk_label_set_text(GTK_LABEL(output_label), lines[i] + strlen("OUTPUT\t"));
+      } else if (g_str_has_prefix(lines[i], "ERROR\t")) {
+        g_string_append_printf(issues, "Lỗi: %s\n", lines[i] + strlen("ERROR\t"));
+      } else if (g_str_has_prefix(lines[i], "CONFLICT\t")) {
+        g_string_append_printf(issues, "Trùng: %s\n", lines[i] + strlen("CONFLICT\t"));
+      }
+    }
+    gtk_label_set_text(GTK_LABEL(issues_label), issues->len > 0 ? issues->str : "Không có lỗi");
+    g_strfreev(lines);
+    g_string_free(issues, TRUE);
+  }
+  g_free(out);
+  g_free(input);
+  g_free(body);
+}
+
+void select_input_method(int i) {
+  store_current_input_method();
+  current = i;
+  if (current < 0) {
+    gtk_text_buffer_set_text(text_buff, "", -1);
+    return;
+  }
+  gtk_text_buffer_set_text(text_buff, get_input_method(current)->body, -1);
+  validate_cb(NULL, NULL);
+}
+
+void reload_combo(int active) {
+  gtk_combo_box_text_remove_all(GTK_COMBO_BOX_TEXT(im_combo));
+  for (guint i = 0; i < input_methods->len; i++) {
+    gtk_combo_box_text_append_text(GTK_COMBO_BOX_TEXT(im_combo), get_input_method(i)->name);
+  }
+  gtk_combo_box_set_active(GTK_COMBO_BOX(im_combo), active);
+}
+
+void combo_changed_cb(GtkWidget *widget, gpointer data) {
+  int active = gtk_combo_box_get_active(GTK_COMBO_BOX(im_combo));
+  if (active != current) {
+    select_input_method(active);
+  }
+}
+
+/*
+ * add_input_method
+ *
+ * Create a new input method, empty or a copy of the current one, named after
+ * the name entry
+ */
+void add_input_method(const gchar *body) {
+  const gchar *name = gtk_entry_get_text(GTK_ENTRY(name_entry));
+  if (strlen(name) == 0 || strchr(name, '[') || strchr(name, ']')) {
+    gtk_label_set_text(GTK_LABEL(issues_label), "Tên kiểu gõ không hợp lệ");
+    return;
+  }
+  if (find_input_method(name) >= 0) {
+    gtk_label_set_text(GTK_LABEL(issues_label), "Kiểu gõ đã tồn tại");
+    return;
+  }
+  store_current_input_method();
+  g_ptr_array_add(input_methods, input_method_new(name, body));
+  current = -1;
+  reload_combo(input_methods->len - 1);
+}
+
+void btn_new_cb(GtkWidget *widget, gpointer data) {
+  add_input_method("");
+}
+
+void btn_clone_cb(GtkWidget *widget, gpointer data) {
+  gchar *body = get_text_buffer_content();
+  add_input_method(body);
+  g_free(body);
+}
+
+void btn_delete_cb(GtkWidget *widget, gpointer data) {
+  if (current < 0 || input_methods->len <= 1) {
+    return;
+  }
+  g_ptr_array_remove_index(input_methods, current);
+  current = -1;
+  reload_combo(0);
+}
+
+/*
+ * btn_save_cb
+ *
+ * Print all the input methods, the engine reads them from our stdout
+ */
+void btn_save_cb(GtkWidget *widget, gpointer data) {
+  store_current_input_method();
+  for (guint i = 0; i < input_methods->len; i++) {
+    InputMethod *im = get_input_method(i);
+    printf("[%s]\n%s", im->name, im->body);
+    if (strlen(im->body) > 0 && im->body[strlen(im->body) - 1] != '\n') {
+      printf("\n");
+    }
+  }
+  fflush(stdout);
+  close_window_cb(widget, data);
+}
+
+GtkWidget *add_button(GtkWidget *hbox, char *label, GCallback cb) {
+  GtkWidget *button = gtk_button_new_with_label(label);
+  gtk_box_pack_start(GTK_BOX(hbox), button, FALSE, FALSE, 5);
+  g_signal_connect(button, "clicked", cb, NULL);
+  return button;
+}
+
+GtkWidget *add_labeled_widget(GtkWidget *vbox, char *text, GtkWidget *widget) {
+  GtkWidget *hbox = gtk_box_new(GTK_ORIENTATION_HORIZONTAL, 0);
+  GtkWidget *label = gtk_label_new(text);
+  gtk_label_set_xalign(GTK_LABEL(label), 0);
+  gtk_widget_set_size_request(label, 100, -1);
+  gtk_box_pack_start(GTK_BOX(hbox), label, FALSE, FALSE, 5);
+  gtk_box_pack_start(GTK_BOX(hbox), widget, TRUE, TRUE, 5);
+  gtk_box_pack_start(GTK_BOX(vbox), hbox, FALSE, FALSE, 0);
+  return hbox;
+}
+
+/*
+ * Main - program begins here
+ */
+int main(int argc, char *argv[]) {
+  GtkWidget *window;
+  GtkWidget *vbox, *hbox, *scrolled, *text_view;
+  gchar *input = NULL;
+  gsize input_len = 0;
+  int pad = 10;
+
+  /* --- GTK initialization --- */
+  gtk_init(&argc, &argv);
+  if (argc > 1) {
+    engine_path = argv[1];
+  }
+  input_methods = g_ptr_array_new_with_free_func(input_method_free);
+  GIOChannel *channel = g_io_channel_unix_new(fileno(stdin));
+  if (g_io_channel_read_to_end(channel, &input, &input_len, NULL) == G_IO_STATUS_NORMAL) {
+    read_input_methods(input);
+    g_free(input);
+  }
+  g_io_channel_unref(channel);
+
+  /* --- Create the top level window --- */
+  window = gtk_window_new(GTK_WINDOW_TOPLEVEL);
+  gtk_window_set_title(GTK_WINDOW(window), "Input Methods - IBus Bamboo");
+  gtk_widget_set_size_request(window, 700, 560);
+  gtk_container_set_border_width(GTK_CONTAINER(window), pad);
+  g_signal_connect(window, "delete_event", G_CALLBACK(close_window_cb), NULL);
+
+  vbox = gtk_box_new(GTK_ORIENTATION_VERTICAL, pad);
+
+  /* --- The input method list and the buttons to manage it --- */
+  im_combo = gtk_combo_box_text_new();
+  add_labeled_widget(vbox, "Kiểu gõ", im_combo);
+  name_entry = gtk_entry_new();
+  gtk_entry_set_placeholder_text(GTK_ENTRY(name_entry), "Tên kiểu gõ mới");
+  hbox = add_labeled_widget(vbox, "Tên", name_entry);
+  add_button(hbox, "Mới", G_CALLBACK(btn_new_cb));
+  add_button(hbox, "Nhân bản", G_CALLBACK(btn_clone_cb));
+  add_button(hbox, "Xoá", G_CALLBACK(btn_delete_cb));
+
+  /* --- The rules, one key and its rule per line --- */
+  text_buff = gtk_text_buffer_new(NULL);
+  text_view = gtk_text_view_new_with_buffer(text_buff);
+  gtk_text_view_set_monospace(GTK_TEXT_VIEW(text_view), TRUE);
+  gtk_text_view_set_left_margin(GTK_TEXT_VIEW(text_view), 8);
+  scrolled = gtk_scrolled_window_new(NULL, NULL);
+  gtk_scrolled_window_set_shadow_type(GTK_SCROLLED_WINDOW(scrolled), GTK_SHADOW_IN);
+  gtk_container_add(GTK_CONTAINER(scrolled), text_view);
+  gtk_box_pack_start(GTK_BOX(vbox), scrolled, TRUE, TRUE, 0);
+
+  /* --- Live test --- */
+  test_entry = gtk_entry_new();
+  gtk_entry_set_placeholder_text(GTK_ENTRY(test_entry), "vieetj nam");
+  add_labeled_widget(vbox, "Gõ thử", test_entry);
+  output_label = gtk_label_new("");
+  gtk_label_set_xalign(GTK_LABEL(output_label), 0);
+  gtk_label_set_selectable(GTK_LABEL(output_label), TRUE);
+  add_labeled_widget(vbox, "Kết quả", output_label);
+  issues_label = gtk_label_new("");
+  gtk_label_set_xalign(GTK_LABEL(issues_label), 0);
+  gtk_label_set_line_wrap(GTK_LABEL(issues_label), TRUE);
+  add_labeled_widget(vbox, "Kiểm tra", issues_label);
+
+  /* --- Cancel and Save buttons --- */
+  hbox = gtk_box_new(GTK_ORIENTATION_HORIZONTAL, 0);
+  gtk_widget_set_halign(hbox, GTK_ALIGN_END);
+  add_button(hbox, "Cancel", G_CALLBACK(close_window_cb));
+  add_button(hbox, "Save", G_CALLBACK(btn_save_cb));
+  gtk_box_pack_start(GTK_BOX(vbox), hbox, FALSE, FALSE, 0);
+
+  g_signal_connect(im_combo, "changed", G_CALLBACK(combo_changed_cb), NULL);
+  g_signal_connect(text_buff, "changed", G_CALLBACK(validate_cb), NULL);
+  g_signal_connect(test_entry, "changed", G_CALLBACK(validate_cb), NULL);
+  reload_combo(input_methods->len > 0 ? 0 : -1);
+
+  gtk_container_add(GTK_CONTAINER(window), vbox);
+  gtk_widget_show_all(window);
+
+  gtk_main();
+  exit(0);
+}
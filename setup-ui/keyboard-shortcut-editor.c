@@ -1,7 +1,7 @@
 #include <ctype.h>
 #include <gtk/gtk.h>
 
-#define TOTAL_ROWS 5
+#define TOTAL_ROWS 6
 #define TOTAL_MASKS_PER_ROW 4
 int row = 0;
 int col = 0;
@@ -14,7 +14,8 @@ int masks[TOTAL_MASKS_PER_ROW] = {GDK_CONTROL_MASK, GDK_MOD1_MASK, GDK_SHIFT_MAS
 int keyvals[TOTAL_MASKS_PER_ROW] = {GDK_KEY_Control_L, GDK_KEY_Alt_L, GDK_KEY_Shift_L,
                            GDK_KEY_Super_L};
 char *text_arr[TOTAL_ROWS] = {"Chuyển chế độ gõ", "Khôi phục phím",
-                                "Tạm tắt bộ gõ", "Emoji", "Hexadecimal"};
+                                "Tạm tắt bộ gõ", "Emoji", "Nhập Unicode",
+                                "Thêm dấu"};
 GtkWidget *maskWidgets[TOTAL_MASKS_PER_ROW * TOTAL_ROWS];
 GtkWidget *keyWidgets[TOTAL_ROWS];
 int usIM = 0;
@@ -263,8 +264,10 @@ int main(int argc, char *argv[]) {
    */
   vbox = gtk_box_new(GTK_ORIENTATION_VERTICAL, pad);
 
+  /* --- The rows before Emoji only apply to the Vietnamese input methods --- */
   int i = usIM ? 3 : 0;
-  for (; i < TOTAL_ROWS; i++) {
+  int last_row = sizeof(text_arr) / sizeof(text_arr[0]);
+  for (; i < last_row; i++) {
     add_shortcut_box(vbox, text_arr[i], i);
   }
 
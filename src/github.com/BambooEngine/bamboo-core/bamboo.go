@@ -41,6 +41,7 @@ type Transformation struct {
 
 type IEngine interface {
 	SetFlag(uint)
+	SetSpellingProfile(*SpellingProfile)
 	GetInputMethod() InputMethod
 	ProcessKey(rune, Mode)
 	ProcessString(string, Mode)
@@ -50,18 +51,39 @@ type IEngine interface {
 	RemoveLastChar(bool)
 	RestoreLastWord(bool)
 	Reset()
+
+	Snapshot() *Snapshot
+	RestoreSnapshot(*Snapshot) error
 }
 
 type BambooEngine struct {
-	composition []*Transformation
-	inputMethod InputMethod
-	flags       uint
+	composition     []*Transformation
+	inputMethod     InputMethod
+	flags           uint
+	spellChecker    SpellChecker
+	spellingProfile *SpellingProfile
+	canvas          incrementalCanvas
+	// escaping is the escape key typed in the Vietnamese mode, the next key
+	// is escaped while it is the last transformation
+	escaping *Transformation
 }
 
 func NewEngine(inputMethod InputMethod, flag uint) IEngine {
+	return NewEngineWithSpellChecker(inputMethod, flag, nil)
+}
+
+// NewEngineWithSpellChecker creates an engine which uses the given checker for IsValid,
+// a nil checker means the rules-based checker with the engine's spelling profile
+func NewEngineWithSpellChecker(inputMethod InputMethod, flag uint, spellChecker SpellChecker) IEngine {
+	if inputMethod.keyRules == nil {
+		// the input method was not built by the parser
+		inputMethod.compile()
+	}
 	engine := BambooEngine{
-		inputMethod: inputMethod,
-		flags:       flag,
+		inputMethod:     inputMethod,
+		flags:           flag,
+		spellChecker:    spellChecker,
+		spellingProfile: StrictSpellingProfile,
 	}
 	return &engine
 }
@@ -74,6 +96,14 @@ func (e *BambooEngine) SetFlag(flag uint) {
 	e.flags = flag
 }
 
+// SetSpellingProfile sets the syllable tables used to transform the key strokes
+func (e *BambooEngine) SetSpellingProfile(profile *SpellingProfile) {
+	if profile == nil {
+		profile = StrictSpellingProfile
+	}
+	e.spellingProfile = profile
+}
+
 func (e *BambooEngine) GetFlag(flag uint) uint {
 	return e.flags
 }
@@ -92,13 +122,21 @@ func (e *BambooEngine) isEffectiveKey(key rune) bool {
 
 func (e *BambooEngine) IsValid(inputIsFullComplete bool) bool {
 	var _, last = extractLastWord(e.composition, e.GetInputMethod().Keys)
-	return isValid(last, inputIsFullComplete)
+	return e.isValid(last, inputIsFullComplete)
+}
+
+func (e *BambooEngine) isValid(composition []*Transformation, inputIsFullComplete bool) bool {
+	if e.spellChecker == nil {
+		return e.spellingProfile.isValid(composition, inputIsFullComplete)
+	}
+	return e.spellChecker.IsValid(composition, inputIsFullComplete)
 }
 
 func (e *BambooEngine) GetProcessedString(mode Mode) string {
 	var tmp []*Transformation
 	if mode&FullText != 0 {
-		tmp = e.composition
+		var previous, _ = extractLastWord(e.composition, e.inputMethod.Keys)
+		return string(e.canvas.render(e.composition, len(previous), mode))
 	} else if mode&PunctuationMode != 0 {
 		_, tmp = extractLastWordWithPunctuationMarks(e.composition, e.inputMethod.Keys)
 		return Flatten(tmp, VietnameseMode)
@@ -109,17 +147,17 @@ func (e *BambooEngine) GetProcessedString(mode Mode) string {
 }
 
 func (e *BambooEngine) getApplicableRules(key rune) []Rule {
-	var applicableRules []Rule
-	for _, inputRule := range e.inputMethod.Rules {
-		if inputRule.Key == unicode.ToLower(key) {
-			applicableRules = append(applicableRules, inputRule)
-		}
-	}
-	return applicableRules
+	return e.inputMethod.keyRules[unicode.ToLower(key)]
+}
+
+// getSequenceRules returns the rules of the multi-key triggers ending with the
+// key, grouped by their prefix, the longest prefixes first
+func (e *BambooEngine) getSequenceRules(key rune) [][]Rule {
+	return e.inputMethod.sequenceRules[unicode.ToLower(key)]
 }
 
 func (e *BambooEngine) findTargetByKey(composition []*Transformation, key rune) (*Transformation, Rule) {
-	return findTarget(composition, e.getApplicableRules(key), e.flags)
+	return findTarget(composition, e.getApplicableRules(key), e.flags, e.spellingProfile)
 }
 
 func (e *BambooEngine) CanProcessKey(key rune) bool {
@@ -127,7 +165,7 @@ func (e *BambooEngine) CanProcessKey(key rune) bool {
 }
 
 func (e *BambooEngine) generateTransformations(composition []*Transformation, lowerKey rune, isUpperCase bool) []*Transformation {
-	var transformations = generateTransformations(composition, e.getApplicableRules(lowerKey), e.flags, lowerKey, isUpperCase)
+	var transformations = generateTransformations(composition, e.getApplicableRules(lowerKey), e.flags, e.spellingProfile, lowerKey, isUpperCase)
 	if transformations == nil {
 		// If none of the applicable_rules can actually be applied then this new
 		// transformation fall-backs to an APPENDING one.
@@ -151,9 +189,12 @@ func (e *BambooEngine) generateTransformations(composition []*Transformation, lo
 	return transformations
 }
 
-func (e *BambooEngine) newComposition(composition []*Transformation, key rune, isUpperCase bool) ([]*Transformation) {
+func (e *BambooEngine) newComposition(composition []*Transformation, key rune, isUpperCase bool) []*Transformation {
+	if newComposition, ok := e.applyKeySequence(composition, key, isUpperCase); ok {
+		return newComposition
+	}
 	// Just process the key stroke on the last syllable
-	var previousTransformations, lastSyllable = extractLastSyllable(composition)
+	var previousTransformations, lastSyllable = extractLastSyllable(composition, e.spellingProfile)
 
 	// Find all possible transformations this keypress can generate
 	lastSyllable = append(lastSyllable, e.generateTransformations(lastSyllable, key, isUpperCase)...)
@@ -162,6 +203,49 @@ func (e *BambooEngine) newComposition(composition []*Transformation, key rune, i
 	return append(previousTransformations, lastSyllable...)
 }
 
+// applyKeySequence applies the rule of a multi-key trigger, e.g. "[dd]": "__đ". The
+// previous keys of the sequence must have been appended as they are, their
+// transformations are replaced by the one of the rule which keeps them in its
+// prefix.
+func (e *BambooEngine) applyKeySequence(composition []*Transformation, key rune, isUpperCase bool) ([]*Transformation, bool) {
+	for _, rules := range e.getSequenceRules(key) {
+		var prefix = rules[0].Prefix
+		if len(prefix) > len(composition) {
+			continue
+		}
+		var previous, tail = composition[:len(composition)-len(prefix)], composition[len(composition)-len(prefix):]
+		if !matchKeySequence(tail, prefix) {
+			continue
+		}
+		var previousTransformations, lastSyllable = extractLastSyllable(previous, e.spellingProfile)
+		if target, applicableRule := findTarget(lastSyllable, rules, e.flags, e.spellingProfile); target != nil {
+			lastSyllable = append(lastSyllable, &Transformation{
+				Rule:        applicableRule,
+				Target:      target,
+				IsUpperCase: isUpperCase,
+			})
+			lastSyllable = append(lastSyllable, e.refreshLastToneTarget(lastSyllable)...)
+			return append(previousTransformations, lastSyllable...), true
+		}
+		var transformations = generateFallbackTransformations(nil, rules, key, isUpperCase || tail[0].IsUpperCase)
+		if len(transformations[0].Rule.Prefix) > 0 {
+			return append(previous, transformations...), true
+		}
+	}
+	return nil, false
+}
+
+func (e *BambooEngine) isEscapeKey(key rune) bool {
+	return inKeyList(e.GetInputMethod().EscapeKeys, key)
+}
+
+// isEscaping tells whether the last key is an escape key typed in the
+// Vietnamese mode which has not been followed by another key yet, the escape
+// key typed in the English mode is a key like the others
+func (e *BambooEngine) isEscaping() bool {
+	return e.escaping != nil && len(e.composition) > 0 && e.composition[len(e.composition)-1] == e.escaping
+}
+
 func (e *BambooEngine) applyUowShortcut(syllable []*Transformation) *Transformation {
 	str := Flatten(syllable, ToneLess|LowerCase)
 	if len(e.inputMethod.SuperKeys) > 0 && regUOhTail.MatchString(str) {
@@ -178,7 +262,7 @@ func (e *BambooEngine) applyUowShortcut(syllable []*Transformation) *Transformat
 }
 
 func (e *BambooEngine) refreshLastToneTarget(syllable []*Transformation) []*Transformation {
-	if e.flags&EfreeToneMarking != 0 && isValid(syllable, false) {
+	if e.flags&EfreeToneMarking != 0 && e.spellingProfile.isValid(syllable, false) {
 		return refreshLastToneTarget(syllable, e.flags&EstdToneStyle != 0)
 	}
 	return nil
@@ -195,6 +279,14 @@ func (e *BambooEngine) ProcessString(str string, mode Mode) {
 func (e *BambooEngine) ProcessKey(key rune, mode Mode) {
 	var lowerKey = unicode.ToLower(key)
 	var isUpperCase = unicode.IsUpper(key)
+	if mode&EnglishMode == 0 && e.isEscaping() {
+		// the escaped key is appended as it is in place of the escape key
+		var escapeTrans = e.composition[len(e.composition)-1]
+		var trans = newAppendingTrans(lowerKey, isUpperCase)
+		trans.Rule.Prefix = []rune{escapeTrans.Rule.Key}
+		e.composition = append(e.composition[:len(e.composition)-1], trans)
+		return
+	}
 	if mode&EnglishMode != 0 || !e.CanProcessKey(lowerKey) {
 		if mode&InReverseOrder != 0 {
 			e.composition = append([]*Transformation{newAppendingTrans(lowerKey, isUpperCase)}, e.composition...)
@@ -204,6 +296,9 @@ func (e *BambooEngine) ProcessKey(key rune, mode Mode) {
 		return
 	}
 	e.composition = e.newComposition(e.composition, lowerKey, isUpperCase)
+	if e.isEscapeKey(lowerKey) && len(e.composition) > 0 {
+		e.escaping = e.composition[len(e.composition)-1]
+	}
 }
 
 func (e *BambooEngine) RestoreLastWord(toVietnamese bool) {
@@ -215,7 +310,7 @@ func (e *BambooEngine) RestoreLastWord(toVietnamese bool) {
 		e.composition = append(previous, breakComposition(lastComb)...)
 	} else {
 		var newComp []*Transformation
-		for _, tnx := range lastComb {
+		for _, tnx := range breakComposition(lastComb) {
 			newComp = e.newComposition(newComp, tnx.Rule.Key, tnx.IsUpperCase)
 		}
 		e.composition = append(previous, newComp...)
@@ -224,6 +319,7 @@ func (e *BambooEngine) RestoreLastWord(toVietnamese bool) {
 
 func (e *BambooEngine) Reset() {
 	e.composition = nil
+	e.canvas.reset()
 }
 
 // Find the last APPENDING transformation and all
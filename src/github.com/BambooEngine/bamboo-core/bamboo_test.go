@@ -9,7 +9,9 @@
 package bamboo
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -660,3 +662,121 @@ func BenchmarkRemoveLastChar(b *testing.B) {
 		}
 	}
 }
+
+func newSequenceEngine() IEngine {
+	var imDef = InputMethodDefinition{}
+	for key, line := range InputMethodDefinitions["Telex"] {
+		imDef[key] = line
+	}
+	delete(imDef, "d")
+	imDef["[dd]"] = "__đ"
+	imDef["[^^]"] = "AEO_ÂÊÔ"
+	imDef["\\"] = "PhimThoat"
+	var im = ParseInputMethod(map[string]InputMethodDefinition{"Sequence": imDef}, "Sequence")
+	return NewEngine(im, EstdFlags)
+}
+
+func TestProcessKeySequence(t *testing.T) {
+	var tests = []struct {
+		keys, vietnamese, english string
+	}{
+		{"ddi", "đi", "ddi"},
+		{"Ddis", "Đí", "DDis"},
+		{"di", "di", "di"},
+		{"ca^^", "câ", "ca^^"},
+		{"ca^", "ca^", "ca^"},
+		{"tie^^ng", "tiêng", "tie^^ng"},
+	}
+	for _, test := range tests {
+		var ng = newSequenceEngine()
+		ng.ProcessString(test.keys, VietnameseMode)
+		if ng.GetProcessedString(VietnameseMode) != test.vietnamese {
+			t.Errorf("Process [%s], got [%s] expected [%s]", test.keys, ng.GetProcessedString(VietnameseMode), test.vietnamese)
+		}
+		if ng.GetProcessedString(EnglishMode) != test.english {
+			t.Errorf("Process-ENG [%s], got [%s] expected [%s]", test.keys, ng.GetProcessedString(EnglishMode), test.english)
+		}
+	}
+	var ng = newSequenceEngine()
+	ng.ProcessString("ddis", VietnameseMode)
+	ng.RestoreLastWord(false)
+	if ng.GetProcessedString(VietnameseMode) != "ddis" {
+		t.Errorf("Restore [ddis], got [%s] expected [ddis]", ng.GetProcessedString(VietnameseMode))
+	}
+	ng.RestoreLastWord(true)
+	if ng.GetProcessedString(VietnameseMode) != "đí" {
+		t.Errorf("Restore-VIE [ddis], got [%s] expected [đí]", ng.GetProcessedString(VietnameseMode))
+	}
+}
+
+func TestProcessEscapeKey(t *testing.T) {
+	var tests = []struct {
+		keys, vietnamese, english string
+	}{
+		{`a\s`, "as", `a\s`},
+		{`to\o`, "too", `to\o`},
+		{`\\`, `\`, `\\`},
+		{`\`, `\`, `\`},
+		{`tieengs\`, `tiếng\`, `tieengs\`},
+	}
+	for _, test := range tests {
+		var ng = newSequenceEngine()
+		ng.ProcessString(test.keys, VietnameseMode)
+		if ng.GetProcessedString(VietnameseMode|FullText) != test.vietnamese {
+			t.Errorf("Process [%s], got [%s] expected [%s]", test.keys, ng.GetProcessedString(VietnameseMode|FullText), test.vietnamese)
+		}
+		if ng.GetProcessedString(EnglishMode|FullText) != test.english {
+			t.Errorf("Process-ENG [%s], got [%s] expected [%s]", test.keys, ng.GetProcessedString(EnglishMode|FullText), test.english)
+		}
+	}
+	// the escape key typed in the English mode does not escape the next key
+	var ng = newSequenceEngine()
+	ng.ProcessString("a", VietnameseMode)
+	ng.ProcessKey('\\', EnglishMode)
+	ng.ProcessKey('s', VietnameseMode)
+	if ng.GetProcessedString(VietnameseMode|FullText) != `a\s` {
+		t.Errorf("Process [a\\s] with [\\] in English, got [%s] expected [a\\s]", ng.GetProcessedString(VietnameseMode|FullText))
+	}
+}
+
+// newLongComposition returns a Telex engine which holds the given number of words
+func newLongComposition(words int) *BambooEngine {
+	var engine = newStdEngine().(*BambooEngine)
+	engine.ProcessString(strings.Repeat("tieengs vieetj ", words/2), VietnameseMode)
+	return engine
+}
+
+// typeWord types a word after the composition the way the input method engine
+// does, then drops it so that every run sees a composition of the same length
+func typeWord(engine *BambooEngine, word string) {
+	var length = len(engine.composition)
+	for _, key := range word {
+		engine.ProcessKey(key, VietnameseMode)
+		engine.GetProcessedString(VietnameseMode)
+		engine.GetProcessedString(EnglishMode | FullText)
+		engine.IsValid(false)
+	}
+	engine.composition = engine.composition[:length]
+}
+
+func BenchmarkProcessKey(b *testing.B) {
+	for _, words := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%dwords", words), func(b *testing.B) {
+			var engine = newLongComposition(words)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				typeWord(engine, "nghieengx")
+			}
+		})
+	}
+}
+
+func TestProcessKeyAllocations(t *testing.T) {
+	var short, long = newLongComposition(10), newLongComposition(1000)
+	var shortAllocs = testing.AllocsPerRun(20, func() { typeWord(short, "nghieengx") })
+	var longAllocs = testing.AllocsPerRun(20, func() { typeWord(long, "nghieengx") })
+	if longAllocs > shortAllocs {
+		t.Errorf("Process [nghieengx], got [%.0f] allocations after 1000 words, expected [%.0f]", longAllocs, shortAllocs)
+	}
+}
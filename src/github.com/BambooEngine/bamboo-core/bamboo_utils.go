@@ -35,6 +35,20 @@ func newAppendingTrans(key rune, isUpperCase bool) *Transformation {
 	}
 }
 
+// matchKeySequence tells whether the transformations are the keys of a prefix
+// appended as they are
+func matchKeySequence(composition []*Transformation, keys []rune) bool {
+	if len(composition) != len(keys) {
+		return false
+	}
+	for i, trans := range composition {
+		if trans.Rule.EffectType != Appending || trans.Rule.Key != keys[i] || len(trans.Rule.Prefix) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func generateAppendingTrans(rules []Rule, lowerKey rune, isUpperCase bool) *Transformation {
 	for _, rule := range rules {
 		if rule.Key == lowerKey && rule.EffectType == Appending {
@@ -68,7 +82,7 @@ func findRootTarget(target *Transformation) *Transformation {
 	}
 }
 
-func isValid(composition []*Transformation, inputIsFullComplete bool) bool {
+func (p *SpellingProfile) isValid(composition []*Transformation, inputIsFullComplete bool) bool {
 	if len(composition) <= 1 {
 		return true
 	}
@@ -85,7 +99,7 @@ func isValid(composition []*Transformation, inputIsFullComplete bool) bool {
 	// spell checking
 	var fc, vo, lc = extractCvcTrans(composition)
 	var flattenMode = VietnameseMode | LowerCase | ToneLess
-	return isValidCVC(Flatten(fc, flattenMode), Flatten(vo, flattenMode), Flatten(lc, flattenMode), inputIsFullComplete)
+	return p.isValidCVC(Flatten(fc, flattenMode), Flatten(vo, flattenMode), Flatten(lc, flattenMode), inputIsFullComplete)
 }
 
 func getRightMostVowels(composition []*Transformation) []*Transformation {
@@ -243,11 +257,10 @@ func extractCvcTrans(composition []*Transformation) ([]*Transformation, []*Trans
 
 func extractLastWordWithPunctuationMarks(composition []*Transformation, effectKeys []rune) ([]*Transformation, []*Transformation) {
 	for i := len(composition) - 1; i >= 0; i-- {
-		var canvas = getCanvas(composition[i:], EnglishMode)
-		if len(canvas) == 0 {
+		var c, ok = getFirstLetter(composition, i, EnglishMode)
+		if !ok {
 			continue
 		}
-		var c = canvas[0]
 		if IsSpace(c) {
 			if i == len(composition)-1 {
 				return composition, nil
@@ -260,11 +273,10 @@ func extractLastWordWithPunctuationMarks(composition []*Transformation, effectKe
 
 func extractLastWord(composition []*Transformation, effectKeys []rune) ([]*Transformation, []*Transformation) {
 	for i := len(composition) - 1; i >= 0; i-- {
-		var canvas = getCanvas(composition[i:], VietnameseMode|LowerCase|ToneLess|MarkLess)
-		if len(canvas) == 0 {
+		var c, ok = getFirstLetter(composition, i, VietnameseMode|LowerCase|ToneLess|MarkLess)
+		if !ok {
 			continue
 		}
-		var c = canvas[0]
 		if !IsAlpha(c) && !inKeyList(effectKeys, c) {
 			if i == len(composition)-1 {
 				return composition, nil
@@ -275,11 +287,12 @@ func extractLastWord(composition []*Transformation, effectKeys []rune) ([]*Trans
 	return nil, composition
 }
 
-func extractLastSyllable(composition []*Transformation) ([]*Transformation, []*Transformation) {
+func extractLastSyllable(composition []*Transformation, profile *SpellingProfile) ([]*Transformation, []*Transformation) {
 	var previous, last = extractLastWord(composition, nil)
 	var anchor = 0
 	for i := range last {
-		if !isValid(last[anchor:i+1], false) {
+		// the spelling tables do not know the letters declared by the input methods
+		if !profile.isValid(last[anchor:i+1], false) && !hasDeclaredLetter(last[anchor:i+1]) {
 			anchor = i
 		}
 	}
@@ -289,7 +302,7 @@ func extractLastSyllable(composition []*Transformation) ([]*Transformation, []*T
 	return previous, last[anchor:]
 }
 
-func findMarkTarget(composition []*Transformation, rules []Rule) (*Transformation, Rule) {
+func findMarkTarget(composition []*Transformation, rules []Rule, profile *SpellingProfile) (*Transformation, Rule) {
 	var str = Flatten(composition, VietnameseMode)
 	for i := len(composition) - 1; i >= 0; i-- {
 		var trans = composition[i]
@@ -303,7 +316,8 @@ func findMarkTarget(composition []*Transformation, rules []Rule) (*Transformatio
 					continue
 				}
 				var tmp = append(composition, &Transformation{Rule: rule, Target: target})
-				if isValid(tmp, false) {
+				// the spelling tables only know the Vietnamese letters
+				if rule.letters.isDeclared(rule.Result) || profile.isValid(tmp, false) {
 					return target, rule
 				}
 			}
@@ -312,7 +326,7 @@ func findMarkTarget(composition []*Transformation, rules []Rule) (*Transformatio
 	return nil, Rule{}
 }
 
-func findTarget(composition []*Transformation, applicableRules []Rule, flags uint) (*Transformation, Rule) {
+func findTarget(composition []*Transformation, applicableRules []Rule, flags uint, profile *SpellingProfile) (*Transformation, Rule) {
 	var str = Flatten(composition, VietnameseMode)
 	// find tone target
 	for _, applicableRule := range applicableRules {
@@ -336,7 +350,7 @@ func findTarget(composition []*Transformation, applicableRules []Rule, flags uin
 		}
 		return target, applicableRule
 	}
-	return findMarkTarget(composition, applicableRules)
+	return findMarkTarget(composition, applicableRules, profile)
 }
 
 func generateUndoTransformations(composition []*Transformation, rules []Rule, flags uint) []*Transformation {
@@ -399,7 +413,7 @@ var regUhO = regexp.MustCompile(`(ưo|ươ)`)
 * 7 | (u)wo + w  ->  undo + append       -> uow
 * ...
 **/
-func generateTransformations(composition []*Transformation, applicableRules []Rule, flags uint, lowerKey rune, isUpperCase bool) []*Transformation {
+func generateTransformations(composition []*Transformation, applicableRules []Rule, flags uint, profile *SpellingProfile, lowerKey rune, isUpperCase bool) []*Transformation {
 	var transformations []*Transformation
 	// Double typing an effect key undoes it and its effects, e.g. w + w -> w (Telex 2)
 	if len(composition) > 0 {
@@ -417,7 +431,7 @@ func generateTransformations(composition []*Transformation, applicableRules []Ru
 		}
 	}
 	// A target may be applied by many different transformations, e.g. o + o + w -> ơ
-	if target, applicableRule := findTarget(composition, applicableRules, flags); target != nil {
+	if target, applicableRule := findTarget(composition, applicableRules, flags, profile); target != nil {
 		transformations = append(transformations, &Transformation{
 			Rule:        applicableRule,
 			Target:      target,
@@ -427,12 +441,12 @@ func generateTransformations(composition []*Transformation, applicableRules []Ru
 			return transformations
 		}
 		var newComp = append(composition, transformations...)
-		if isValid(newComp, true) {
+		if profile.isValid(newComp, true) {
 			return transformations
 		}
 		// Implement the uow typing shortcut by creating a virtual
 		// Mark_HORN rule that targets 'u' or 'o'.
-		if target, virtualRule := findTarget(newComp, applicableRules, flags); target != nil {
+		if target, virtualRule := findTarget(newComp, applicableRules, flags, profile); target != nil {
 			virtualRule.Key = 0
 			return append(transformations, &Transformation{virtualRule, target, false})
 		}
@@ -448,7 +462,7 @@ func generateTransformations(composition []*Transformation, applicableRules []Ru
 					Effect:     uint8(MarkNone),
 				},
 			}
-			if target, applicableRule := findTarget(append(composition, trans), applicableRules, flags); target != nil && target != vowels[0] {
+			if target, applicableRule := findTarget(append(composition, trans), applicableRules, flags, profile); target != nil && target != vowels[0] {
 				transformations = append(transformations, trans)
 				transformations = append(transformations, &Transformation{
 					Rule:        applicableRule,
@@ -490,6 +504,9 @@ func breakComposition(composition []*Transformation) []*Transformation {
 		if trans.Rule.Key == 0 {
 			continue
 		}
+		for _, key := range trans.Rule.Prefix {
+			result = append(result, newAppendingTrans(key, trans.IsUpperCase))
+		}
 		result = append(result, newAppendingTrans(trans.Rule.Key, trans.IsUpperCase))
 	}
 	return result
@@ -8,7 +8,70 @@
 
 package bamboo
 
+import (
+	"sort"
+	"unicode"
+)
+
 const UNICODE = "Unicode"
+const UNICODE_NFD = "Unicode NFD"
+
+// the combining marks of the canonical decomposition (NFD), the horn and the dot
+// below come before the other marks because of their combining classes
+var nfdMarks = map[Mark]rune{
+	MarkHat:   '\u0302',
+	MarkBreve: '\u0306',
+	MarkHorn:  '\u031B',
+}
+
+var nfdTones = map[Tone]rune{
+	ToneGrave: '\u0300',
+	ToneAcute: '\u0301',
+	ToneHook:  '\u0309',
+	ToneTilde: '\u0303',
+	ToneDot:   '\u0323',
+}
+
+func init() {
+	charsetDefinitions[UNICODE_NFD] = buildNFDCharset(charsetDefinitions["Unicode tổ hợp"])
+}
+
+func buildNFDCharset(composedCharset charsetDefinition) charsetDefinition {
+	var charset = make(charsetDefinition, len(composedCharset))
+	for chr := range composedCharset {
+		charset[chr] = decomposeChar(chr)
+	}
+	return charset
+}
+
+func decomposeChar(chr rune) string {
+	var lowerChr = unicode.ToLower(chr)
+	var tone = FindToneFromChar(lowerChr)
+	var toneless = AddToneToChar(lowerChr, 0)
+	var mark, _ = FindMarkFromChar(toneless)
+	if mark == MarkDash {
+		// đ has no decomposition
+		return string(chr)
+	}
+	var base = AddMarkToChar(toneless, 0)
+	if unicode.IsUpper(chr) {
+		base = unicode.ToUpper(base)
+	}
+	var marks = []rune{base}
+	if mark == MarkHorn {
+		marks = append(marks, nfdMarks[mark])
+	}
+	if tone == ToneDot {
+		marks = append(marks, nfdTones[tone])
+	}
+	if mark == MarkHat || mark == MarkBreve {
+		marks = append(marks, nfdMarks[mark])
+	}
+	if tone != ToneNone && tone != ToneDot {
+		marks = append(marks, nfdTones[tone])
+	}
+	return string(marks)
+}
 
 func Encode(charsetName string, input string) string {
 	if charsetName == UNICODE {
@@ -31,9 +94,173 @@ func Encode(charsetName string, input string) string {
 
 func GetCharsetNames() []string {
 	var names []string
-	names = append(names, UNICODE)
 	for cs := range charsetDefinitions {
 		names = append(names, cs)
 	}
-	return names
+	sort.Strings(names)
+	return append([]string{UNICODE}, names...)
+}
+
+type charsetDecoder struct {
+	codes  map[string]rune
+	maxLen int
+	// isASCII is set if all the codes are written in ASCII, like the NCR
+	isASCII bool
+}
+
+// newCharsetDecoder reverses a charset table. Some 8-bit charsets (e.g. TCVN3)
+// share a code between the lower and the upper case form of a letter, the lower
+// case one is preferred since the upper case glyphs come from the font.
+func newCharsetDecoder(charset charsetDefinition) *charsetDecoder {
+	var decoder = &charsetDecoder{codes: make(map[string]rune, len(charset)), isASCII: true}
+	for chr, code := range charset {
+		for _, c := range code {
+			if c > unicode.MaxASCII {
+				decoder.isASCII = false
+			}
+		}
+		if old, found := decoder.codes[code]; found && !preferDecodedRune(chr, old) {
+			continue
+		}
+		decoder.codes[code] = chr
+		if n := len([]rune(code)); n > decoder.maxLen {
+			decoder.maxLen = n
+		}
+	}
+	return decoder
+}
+
+func preferDecodedRune(chr, old rune) bool {
+	if unicode.IsLower(chr) != unicode.IsLower(old) {
+		return unicode.IsLower(chr)
+	}
+	return chr < old
+}
+
+// decode replaces the longest code found at each position, the other characters
+// are kept as they are. It returns the decoded text and the number of input
+// characters which were part of a code.
+func (d *charsetDecoder) decode(input []rune) ([]rune, int) {
+	var output = make([]rune, 0, len(input))
+	var nDecoded = 0
+	for i := 0; i < len(input); {
+		var n = d.maxLen
+		if n > len(input)-i {
+			n = len(input) - i
+		}
+		for ; n > 0; n-- {
+			if chr, found := d.codes[string(input[i:i+n])]; found {
+				output = append(output, chr)
+				nDecoded += n
+				break
+			}
+		}
+		if n == 0 {
+			output = append(output, input[i])
+			n = 1
+		}
+		i += n
+	}
+	return output, nDecoded
+}
+
+// Decode converts a text in the given charset back to Unicode, it is the
+// reverse of Encode
+func Decode(charsetName string, input string) string {
+	if charsetName == UNICODE {
+		return input
+	}
+	if charset, found := charsetDefinitions[charsetName]; found {
+		var output, _ = newCharsetDecoder(charset).decode([]rune(input))
+		return string(output)
+	}
+	return input
+}
+
+// charsetDetectionMargin is the score a legacy charset must gain over the text
+// as it is, a few symbols which a table maps to letters do not decide it
+const charsetDetectionMargin = 3
+
+// DetectCharset guesses the charset of a text by decoding it with every charset
+// and scoring the Vietnamese words of the result: a word counts for its length
+// if it is a valid syllable and against it otherwise. A legacy charset must
+// beat the text as it is by a clear margin. A text whose Vietnamese words are
+// all valid syllables is only decoded by the charsets written in ASCII, the
+// symbols of the 8-bit ones would be taken for letters.
+func DetectCharset(input string) string {
+	var runes = []rune(input)
+	var unicodeScore, isVietnamese = scoreDecodedText(runes)
+	var bestName = UNICODE
+	var bestScore = unicodeScore + charsetDetectionMargin - 1
+	if bestScore < 0 {
+		bestScore = 0
+	}
+	for _, name := range GetCharsetNames() {
+		var charset, found = charsetDefinitions[name]
+		if !found {
+			continue
+		}
+		var decoder = newCharsetDecoder(charset)
+		if isVietnamese && !decoder.isASCII {
+			continue
+		}
+		var output, nDecoded = decoder.decode(runes)
+		if nDecoded == 0 {
+			continue
+		}
+		if score, _ := scoreDecodedText(output); score > bestScore {
+			bestName = name
+			bestScore = score
+		}
+	}
+	return bestName
+}
+
+// scoreDecodedText returns the score of a text and whether it has Vietnamese
+// words which are all valid syllables
+func scoreDecodedText(text []rune) (int, bool) {
+	var score = 0
+	var nValid, nInvalid = 0, 0
+	var isLetter = func(chr rune) bool {
+		return unicode.IsLetter(chr) || unicode.Is(unicode.Mn, chr)
+	}
+	for i := 0; i < len(text); {
+		if !isLetter(text[i]) {
+			if text[i] > unicode.MaxASCII && !unicode.IsSpace(text[i]) {
+				// a symbol left by a wrong charset
+				score--
+			}
+			i++
+			continue
+		}
+		var j = i
+		for j < len(text) && isLetter(text[j]) {
+			j++
+		}
+		var word = text[i:j]
+		if hasVietnameseRune(word) {
+			if isValidVietnameseWord(word) {
+				score += len(word)
+				nValid++
+			} else {
+				score -= len(word)
+				nInvalid++
+			}
+		}
+		i = j
+	}
+	return score, nValid > 0 && nInvalid == 0
+}
+
+func hasVietnameseRune(word []rune) bool {
+	for _, chr := range word {
+		if chr > unicode.MaxASCII {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidVietnameseWord(word []rune) bool {
+	return StrictSpellingProfile.IsValidWord(string(word))
 }
@@ -0,0 +1,84 @@
+package bamboo
+
+import (
+	"testing"
+)
+
+const charsetSample = "Tiếng Việt là ngôn ngữ chính thức của Việt Nam, những người dân tộc thiểu số cũng dùng nó. ĐẶNG THUỲ TRÂM, Đà Lạt, quyển vở, khuỷu tay, giường"
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for name, charset := range charsetDefinitions {
+		for chr, code := range charset {
+			var decoded = Decode(name, code)
+			if decoded != string(chr) && Encode(name, decoded) != code {
+				t.Errorf("Decode(%s, %q), got [%s] expected [%c]", name, code, decoded, chr)
+			}
+		}
+		var encoded = Encode(name, charsetSample)
+		if decoded := Decode(name, encoded); Encode(name, decoded) != encoded {
+			t.Errorf("Decode(%s, %q), got [%s] expected [%s]", name, encoded, decoded, charsetSample)
+		}
+	}
+}
+
+func TestDecodeLowerCaseText(t *testing.T) {
+	var text = "tiếng việt là ngôn ngữ chính thức của việt nam, đà lạt, quyển vở, khuỷu tay, giường, ặc ẫm ỵ"
+	for _, name := range GetCharsetNames() {
+		if decoded := Decode(name, Encode(name, text)); decoded != text {
+			t.Errorf("Decode(%s), got [%s] expected [%s]", name, decoded, text)
+		}
+	}
+}
+
+func TestDecodeMultiByteCodes(t *testing.T) {
+	var tests = []struct {
+		charset string
+		input   string
+		output  string
+	}{
+		{"VNI Windows", "Vieät Nam", "Việt Nam"},
+		{"VNI Windows", "ñöôøng", "đường"},
+		{"VIQR", "Vie^.t Nam", "Việt Nam"},
+		{"VIQR", "DDa`", "Đà"},
+		{"NCR Decimal", "Vi&#7879;t", "Việt"},
+		{"TCVN3 (ABC)", "ViÖt Nam", "Việt Nam"},
+		{"Unknown", "ViÖt", "ViÖt"},
+	}
+	for _, test := range tests {
+		if output := Decode(test.charset, test.input); output != test.output {
+			t.Errorf("Decode(%s, %s), got [%s] expected [%s]", test.charset, test.input, output, test.output)
+		}
+	}
+}
+
+func TestDetectCharset(t *testing.T) {
+	for _, name := range GetCharsetNames() {
+		if detected := DetectCharset(Encode(name, charsetSample)); detected != name {
+			t.Errorf("DetectCharset(%s), got [%s] expected [%s]", name, detected, name)
+		}
+	}
+	var unicodeTexts = []string{
+		"Hello world.",
+		"Are you ok?",
+		charsetSample,
+		"Đà Nẵng — 100€",
+		"vn:Việt Nam…\ncty:Công ty TNHH — chi nhánh\ngia:Giá 100€",
+		"café naïve",
+		"tm:™ thương hiệu",
+		"• Hà Nội",
+	}
+	for _, text := range unicodeTexts {
+		if detected := DetectCharset(text); detected != UNICODE {
+			t.Errorf("DetectCharset(%s), got [%s] expected [%s]", text, detected, UNICODE)
+		}
+	}
+}
+
+func TestUnicodeNFD(t *testing.T) {
+	if output := Encode(UNICODE_NFD, "Việt Đức"); output != "Vie\u0323\u0302t \u0110u\u031B\u0301c" {
+		t.Errorf("Encode(%s, Việt Đức), got [%q]", UNICODE_NFD, output)
+	}
+	if output := Decode(UNICODE_NFD, "ngu\u031Bo\u031B\u0300i"); output != "người" {
+		t.Errorf("Decode(%s), got [%s] expected [người]", UNICODE_NFD, output)
+	}
+}
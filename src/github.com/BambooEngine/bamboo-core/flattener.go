@@ -12,63 +12,168 @@ import (
 	"unicode"
 )
 
+// canvasLetter is a key of the composition with the effects applied so far,
+// the keys of the effects are letters in EnglishMode only
+type canvasLetter struct {
+	trans   *Transformation
+	chr     rune
+	tone    Tone
+	letters *letterTable
+}
+
+func (l canvasLetter) isRendered(mode Mode) bool {
+	return mode&EnglishMode != 0 || l.trans.Rule.EffectType == Appending
+}
+
+// maxStackLetters is the number of letters rendered without a heap allocation
+const maxStackLetters = 64
+
+// Flatten renders the composition in a single pass, the buffers are on the stack
+// for a short composition and allocated once for a long one
 func Flatten(composition []*Transformation, mode Mode) string {
-	return string(getCanvas(composition, mode))
+	var canvasBuf [maxStackLetters]rune
+	var lettersBuf [maxStackLetters]canvasLetter
+	var canvas, letters = canvasBuf[:0], lettersBuf[:0]
+	if len(composition) > maxStackLetters {
+		canvas = make([]rune, 0, len(composition))
+		letters = make([]canvasLetter, 0, len(composition))
+	}
+	for _, letter := range appendLetters(letters, composition) {
+		canvas = appendCanvasLetter(canvas, letter, mode)
+	}
+	return string(canvas)
 }
 
-func getCanvas(composition []*Transformation, mode Mode) []rune {
-	var canvas []rune
-	var appendingMap = map[*Transformation][]*Transformation{}
-	var appendingList []*Transformation
+// appendLetters appends the letters of the composition and applies the effects
+// on them, an effect always comes after its target so the target is looked up
+// backwards from the end of the letters, which is where it is in almost every case
+func appendLetters(letters []canvasLetter, composition []*Transformation) []canvasLetter {
 	for _, trans := range composition {
-		if mode&EnglishMode != 0 {
-			if trans.Rule.Key == 0 {
-				// ignore virtual key
-				continue
-			}
-			appendingList = append(appendingList, trans)
-		} else if trans.Rule.EffectType == Appending {
-			if trans.Rule.Key == 0 {
-				// ignore virtual key
-				continue
+		if trans.Rule.Key != 0 {
+			// the virtual keys are ignored
+			letters = append(letters, canvasLetter{trans: trans, chr: trans.Rule.EffectOn, letters: trans.Rule.letters})
+		}
+		if trans.Rule.EffectType == Appending || trans.Target == nil {
+			continue
+		}
+		for i := len(letters) - 1; i >= 0; i-- {
+			if letters[i].trans == trans.Target {
+				letters[i].applyEffect(trans)
+				break
 			}
-			appendingList = append(appendingList, trans)
-		} else if trans.Target != nil {
-			appendingMap[trans.Target] = append(appendingMap[trans.Target], trans)
 		}
 	}
-	for _, appendingTrans := range appendingList {
-		var chr rune
-		var transList = appendingMap[appendingTrans]
-		if mode&EnglishMode != 0 {
-			chr = appendingTrans.Rule.Key
-		} else {
-			chr = appendingTrans.Rule.EffectOn
-			for _, trans := range transList {
-				switch trans.Rule.EffectType {
-				case MarkTransformation:
-					if trans.Rule.Effect == uint8(MarkRaw) {
-						chr = appendingTrans.Rule.Key
-					} else {
-						chr = AddMarkToChar(chr, trans.Rule.Effect)
-					}
-				case ToneTransformation:
-					chr = AddToneToChar(chr, trans.Rule.Effect)
-				}
-			}
+	return letters
+}
+
+// incrementalCanvas keeps the letters of the words before the last one, which
+// the key strokes do not change, so that rendering the full text only renders
+// the last word again.
+type incrementalCanvas struct {
+	letters []canvasLetter
+	// length is the number of transformations whose letters are kept, last is
+	// the last of them, the letters are dropped if it is not in its place anymore
+	length int
+	last   *Transformation
+	runes  []rune
+}
+
+func (c *incrementalCanvas) reset() {
+	c.letters = c.letters[:0]
+	c.length = 0
+	c.last = nil
+}
+
+// render renders the composition, the letters of the transformations before
+// stable are kept for the next call. The returned runes are valid until the
+// next call.
+func (c *incrementalCanvas) render(composition []*Transformation, stable int, mode Mode) []rune {
+	if c.length > stable || (c.length > 0 && composition[c.length-1] != c.last) {
+		c.reset()
+	}
+	c.letters = appendLetters(c.letters, composition[c.length:stable])
+	c.length = stable
+	if stable > 0 {
+		c.last = composition[stable-1]
+	}
+	// the letters of the last word are appended after the kept ones, they are
+	// looked up on their own so that the kept letters are never changed
+	var size = len(composition) - stable
+	if cap(c.letters)-len(c.letters) < size {
+		var letters = make([]canvasLetter, len(c.letters), 2*cap(c.letters)+size)
+		copy(letters, c.letters)
+		c.letters = letters
+	}
+	var lastWord = appendLetters(c.letters[len(c.letters):], composition[stable:])
+	c.runes = c.runes[:0]
+	for _, letters := range [][]canvasLetter{c.letters, lastWord} {
+		for _, letter := range letters {
+			c.runes = appendCanvasLetter(c.runes, letter, mode)
 		}
-		if mode&ToneLess != 0 {
-			chr = AddToneToChar(chr, 0)
+	}
+	return c.runes
+}
+
+// applyEffect applies a mark or a tone transformation to the letter of its
+// target, the letter keeps the letter table of the rule which declared it
+func (l *canvasLetter) applyEffect(trans *Transformation) {
+	if trans.Rule.letters != nil {
+		l.letters = trans.Rule.letters
+	}
+	switch trans.Rule.EffectType {
+	case MarkTransformation:
+		if trans.Rule.Effect == uint8(MarkRaw) {
+			l.chr = l.trans.Rule.Key
+		} else {
+			l.chr = l.letters.addMark(l.chr, trans.Rule.Effect)
 		}
-		if mode&MarkLess != 0 {
-			chr = AddMarkToChar(chr, 0)
+	case ToneTransformation:
+		l.chr = AddToneToChar(l.chr, trans.Rule.Effect)
+		l.tone = Tone(trans.Rule.Effect)
+	}
+}
+
+func appendCanvasLetter(canvas []rune, letter canvasLetter, mode Mode) []rune {
+	if !letter.isRendered(mode) {
+		return canvas
+	}
+	var chr, tone = letter.chr, letter.tone
+	if mode&EnglishMode != 0 {
+		chr, tone = letter.trans.Rule.Key, ToneNone
+		// the previous keys of a multi-key trigger
+		for _, key := range letter.trans.Rule.Prefix {
+			if mode&LowerCase == 0 && letter.trans.IsUpperCase {
+				key = unicode.ToUpper(key)
+			}
+			canvas = append(canvas, key)
 		}
-		if mode&LowerCase != 0 {
-			chr = unicode.ToLower(chr)
-		} else if appendingTrans.IsUpperCase {
-			chr = unicode.ToUpper(chr)
+	}
+	if mode&ToneLess != 0 {
+		chr = AddToneToChar(chr, 0)
+		tone = ToneNone
+	}
+	if mode&MarkLess != 0 {
+		chr = letter.letters.addMark(chr, 0)
+	}
+	if mode&LowerCase != 0 {
+		chr = unicode.ToLower(chr)
+	}
+	return letter.letters.appendLetter(canvas, chr, tone, mode&LowerCase == 0 && letter.trans.IsUpperCase)
+}
+
+// getFirstLetter renders the first letter of the canvas of composition[i:] if it
+// is the key at i, the effects on the letter are looked up in the
+// transformations after it
+func getFirstLetter(composition []*Transformation, i int, mode Mode) (rune, bool) {
+	var letter = canvasLetter{trans: composition[i], chr: composition[i].Rule.EffectOn, letters: composition[i].Rule.letters}
+	if letter.trans.Rule.Key == 0 || !letter.isRendered(mode) {
+		return 0, false
+	}
+	for _, trans := range composition[i+1:] {
+		if trans.Target == letter.trans && trans.Rule.EffectType != Appending {
+			letter.applyEffect(trans)
 		}
-		canvas = append(canvas, chr)
 	}
-	return canvas
+	var buf [8]rune
+	return appendCanvasLetter(buf[:0], letter, mode)[0], true
 }
@@ -0,0 +1,62 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"fmt"
+	"testing"
+)
+
+// checkCanvas compares the full text rendered by the engine, which keeps the
+// previous words, with the one rendered from scratch
+func checkCanvas(t *testing.T, ng *BambooEngine, step string) {
+	for _, mode := range []Mode{VietnameseMode, EnglishMode, VietnameseMode | ToneLess | LowerCase} {
+		var expected = Flatten(ng.composition, mode)
+		if got := ng.GetProcessedString(mode | FullText); got != expected {
+			t.Errorf("Process [%s], got [%s] expected [%s]", step, got, expected)
+		}
+	}
+}
+
+func TestIncrementalCanvas(t *testing.T) {
+	var ng = newStdEngine().(*BambooEngine)
+	for _, key := range "Tieengs Vieetj laf ngoon nguwx" {
+		ng.ProcessKey(key, VietnameseMode)
+		checkCanvas(t, ng, string(key))
+	}
+	ng.RemoveLastChar(true)
+	checkCanvas(t, ng, "backspace")
+	for i := 0; i < 4; i++ {
+		ng.RemoveLastChar(true)
+	}
+	checkCanvas(t, ng, "backspaces")
+	ng.ProcessString("  nghieengs nga ddi", VietnameseMode)
+	checkCanvas(t, ng, "nghieengs nga ddi")
+	ng.ProcessString("a ", VietnameseMode|InReverseOrder)
+	checkCanvas(t, ng, "reverse order")
+	var snapshot = ng.Snapshot()
+	ng.RestoreSnapshot(snapshot)
+	checkCanvas(t, ng, "restore snapshot")
+	ng.Reset()
+	ng.ProcessString("ddi", VietnameseMode)
+	checkCanvas(t, ng, "reset")
+}
+
+func BenchmarkFlatten(b *testing.B) {
+	for _, words := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%dwords", words), func(b *testing.B) {
+			var engine = newLongComposition(words)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				Flatten(engine.composition, VietnameseMode)
+			}
+		})
+	}
+}
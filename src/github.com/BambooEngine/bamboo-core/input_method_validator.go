@@ -0,0 +1,163 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var regDslLine = regexp.MustCompile(`^([a-zA-Z]+)_([\p{L}\p{M}]+)([_\p{L}]*)$`)
+var regDslAppendingLine = regexp.MustCompile(`^_?_\p{L}+$`)
+
+// InputMethodError describes a line of an input method definition which is
+// ignored or which does not do what it seems to. A conflict is a line which can
+// be parsed but clashes with the line of another key.
+type InputMethodError struct {
+	Key      string
+	Reason   string
+	Conflict bool
+}
+
+func (e *InputMethodError) Error() string {
+	return fmt.Sprintf("key %q: %s", e.Key, e.Reason)
+}
+
+// ValidateInputMethodDefinition reports the problems that ParseRules would
+// silently ignore, the errors are sorted by key.
+func ValidateInputMethodDefinition(imDef InputMethodDefinition) []*InputMethodError {
+	var errs []*InputMethodError
+	var keys = make([]string, 0, len(imDef))
+	for key := range imDef {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sameKeys = map[string][]string{}
+	var effects = map[string]string{}
+	for _, key := range keys {
+		var line = imDef[key]
+		var keyRunes = []rune(key)
+		if len(keyRunes) == 0 {
+			errs = append(errs, &InputMethodError{Key: key, Reason: "the key is empty"})
+			continue
+		}
+		var sequence = parseDefinitionKey(key)
+		if len(keyRunes) > 1 && len(sequence) == 1 {
+			errs = append(errs, &InputMethodError{Key: key, Reason: fmt.Sprintf("a key is a single character, only %q is used, a key sequence is written in brackets", keyRunes[0])})
+		}
+		if strings.IndexFunc(string(sequence), unicode.IsUpper) >= 0 {
+			errs = append(errs, &InputMethodError{Key: key, Reason: "upper case keys are never matched, the keys are compared in lower case"})
+		}
+		var lowerKeys = strings.ToLower(string(sequence))
+		sameKeys[lowerKeys] = append(sameKeys[lowerKeys], key)
+		if line == escapeRule && len(sequence) > 1 {
+			errs = append(errs, &InputMethodError{Key: key, Reason: "an escape key is a single key"})
+			continue
+		}
+		var lowerKey = unicode.ToLower(sequence[len(sequence)-1])
+		if reason := validateRuleLine(line); reason != "" {
+			errs = append(errs, &InputMethodError{Key: key, Reason: reason})
+			continue
+		}
+		for _, effect := range getRuleEffects(ParseRules(lowerKey, line)) {
+			if other, found := effects[effect]; found && other != key {
+				errs = append(errs, &InputMethodError{
+					Key:      key,
+					Reason:   fmt.Sprintf("%q does the same as key %q", line, other),
+					Conflict: true,
+				})
+				break
+			}
+			effects[effect] = key
+		}
+	}
+	for _, keys := range sameKeys {
+		for _, key := range keys[1:] {
+			errs = append(errs, &InputMethodError{
+				Key:      key,
+				Reason:   fmt.Sprintf("%q and %q are typed with the same key", imDef[key], imDef[keys[0]]),
+				Conflict: true,
+			})
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Key < errs[j].Key
+	})
+	return errs
+}
+
+func validateRuleLine(line string) string {
+	if _, ok := tones[line]; ok || line == escapeRule {
+		return ""
+	}
+	for name := range tones {
+		if strings.EqualFold(strings.TrimSpace(line), name) {
+			return fmt.Sprintf("unknown tone %q, did you mean %q?", line, name)
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(line), escapeRule) {
+		return fmt.Sprintf("unknown rule %q, did you mean %q?", line, escapeRule)
+	}
+	if regDslAppendingLine.MatchString(line) {
+		return ""
+	}
+	var parts = regDslLine.FindStringSubmatch(line)
+	if parts == nil {
+		return fmt.Sprintf("cannot parse %q, expected a tone name, a mark rule like \"A_Â\" or an appending rule like \"__ư\"", line)
+	}
+	var effectiveOns = []rune(strings.ToLower(parts[1]))
+	var results = splitLetters(strings.ToLower(parts[2]))
+	if len(effectiveOns) != len(results) {
+		return fmt.Sprintf("%q has %d letters before \"_\" but %d after it", line, len(effectiveOns), len(results))
+	}
+	// a letter of the line is checked on its own, it is not declared yet
+	var letters *letterTable
+	for i, effectiveOn := range effectiveOns {
+		var found = letters.isMarkedLetter(effectiveOn, results[i])
+		for _, chr := range getMarkFamily(marksMaps, effectiveOn) {
+			if len(results[i]) == 1 && chr == results[i][0] && chr != effectiveOn {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Sprintf("%q cannot be turned into %q", effectiveOn, string(results[i]))
+		}
+	}
+	if parts[3] != "" && !regDslAppendingLine.MatchString(parts[3]) {
+		return fmt.Sprintf("cannot parse %q after the mark rule, expected an appending rule like \"__ư\"", parts[3])
+	}
+	return ""
+}
+
+// getRuleEffects describes what the rules do regardless of their key
+func getRuleEffects(rules []Rule) []string {
+	var effects []string
+	for _, rule := range rules {
+		switch rule.EffectType {
+		case ToneTransformation:
+			effects = append(effects, fmt.Sprintf("tone %d", rule.Effect))
+		case MarkTransformation:
+			if rule.Effect != 0 && FindToneFromChar(rule.EffectOn) == ToneNone {
+				effects = append(effects, fmt.Sprintf("mark %d on %c", rule.Effect, rule.EffectOn))
+			}
+		case Appending:
+			var chars = []rune{rule.EffectOn}
+			for _, appendedRule := range rule.AppendedRules {
+				chars = append(chars, appendedRule.EffectOn)
+			}
+			effects = append(effects, "append "+string(chars))
+		case Escaping:
+			effects = append(effects, "escape")
+		}
+	}
+	return effects
+}
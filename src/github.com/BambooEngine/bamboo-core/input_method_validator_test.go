@@ -0,0 +1,85 @@
+package bamboo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBuiltinInputMethods(t *testing.T) {
+	for name, imDef := range InputMethodDefinitions {
+		for _, err := range ValidateInputMethodDefinition(imDef) {
+			// some input methods have several keys for the same effect on purpose
+			if !err.Conflict {
+				t.Errorf("Validate %s, got [%v] expected no error", name, err)
+			}
+		}
+	}
+}
+
+func TestValidateInputMethodDefinition(t *testing.T) {
+	var tests = []struct {
+		line     string
+		expected string
+	}{
+		{"DauSac", ""},
+		{"Dausac", "did you mean"},
+		{"PhimThoat", ""},
+		{"phimthoat", "did you mean"},
+		{"UOA_ƯƠĂ__Ư", ""},
+		{"__ư", ""},
+		{"UOA_ƯƠ", "3 letters before"},
+		{"A_Ê", "cannot be turned"},
+		{"EIOU_ĔĬŎŬ", ""},
+		{"CZ_ČŽ", ""},
+		{"O_Ơ\u0306", ""},
+		{"A_Ĕ", "cannot be turned"},
+		{"A_Â ", "cannot parse"},
+		{"hello", "cannot parse"},
+	}
+	for _, test := range tests {
+		var errs = ValidateInputMethodDefinition(InputMethodDefinition{"k": test.line})
+		if test.expected == "" && len(errs) != 0 {
+			t.Errorf("Validate [%s], got [%v] expected no error", test.line, errs[0])
+		}
+		if test.expected != "" && (len(errs) != 1 || !strings.Contains(errs[0].Reason, test.expected)) {
+			t.Errorf("Validate [%s], got %v expected [%s]", test.line, errs, test.expected)
+		}
+	}
+}
+
+func TestValidateInputMethodConflicts(t *testing.T) {
+	var errs = ValidateInputMethodDefinition(InputMethodDefinition{
+		"s":      "DauSac",
+		"S":      "__ă",
+		"1":      "DauSac",
+		"ww":     "UO_ƯƠ",
+		"[wW]":   "__ư",
+		"[ww]":   "__ơ",
+		"[dd]":   "__đ",
+		"\\":     "PhimThoat",
+		"[\\\\]": "PhimThoat",
+	})
+	var reasons []string
+	for _, err := range errs {
+		reasons = append(reasons, err.Key+": "+err.Reason)
+	}
+	var expected = []string{
+		`S: upper case keys are never matched, the keys are compared in lower case`,
+		`[\\]: an escape key is a single key`,
+		`[wW]: upper case keys are never matched, the keys are compared in lower case`,
+		`[ww]: "__ơ" and "__ư" are typed with the same key`,
+		`s: "DauSac" does the same as key "1"`,
+		`s: "DauSac" and "__ă" are typed with the same key`,
+		`ww: a key is a single character, only 'w' is used, a key sequence is written in brackets`,
+	}
+	if strings.Join(reasons, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Validate conflicts, got [%s] expected [%s]", strings.Join(reasons, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestParseMalformedToneLessRules(t *testing.T) {
+	// more effective letters than results used to panic
+	if rules := ParseRules('w', "UOA_ƯƠ"); len(rules) == 0 {
+		t.Errorf("ParseRules [UOA_ƯƠ], got no rule expected the rules of u and o")
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+)
+
+// The reverse conversion: find the keys which produce a given text. The text is
+// cut into segments (a run of letters or a single other character), the
+// candidate key sequences of every segment are tried from the shortest to the
+// longest one, and the first sequence whose output matches is kept. The output
+// of a segment only depends on the keys typed since the last space, so a
+// candidate is checked against that context instead of the whole text.
+
+// GenerateKeyStrokes returns a short key sequence which reproduces the text
+// through ProcessString(keys, VietnameseMode) on an engine created with the same
+// input method and flags.
+func GenerateKeyStrokes(im InputMethod, flags uint, text string) (string, error) {
+	var g = keyStrokeGenerator{im: im, flags: flags}
+	var keys, ctxKeys, ctxOutput []rune
+	for _, segment := range splitKeyStrokeSegments([]rune(text)) {
+		var segmentKeys, ok = g.findSegmentKeys(ctxKeys, ctxOutput, segment)
+		if !ok {
+			return "", fmt.Errorf("bamboo: cannot type %q with %s", string(segment), im.Name)
+		}
+		keys = append(keys, segmentKeys...)
+		if len(segment) == 1 && unicode.IsSpace(segment[0]) {
+			ctxKeys, ctxOutput = nil, nil
+		} else {
+			ctxKeys = append(ctxKeys, segmentKeys...)
+			ctxOutput = append(ctxOutput, segment...)
+		}
+	}
+	if !g.produces(keys, []rune(text)) {
+		return "", fmt.Errorf("bamboo: cannot type %q with %s", text, im.Name)
+	}
+	return string(keys), nil
+}
+
+type keyStrokeGenerator struct {
+	im    InputMethod
+	flags uint
+}
+
+func splitKeyStrokeSegments(text []rune) [][]rune {
+	var segments [][]rune
+	for i := 0; i < len(text); {
+		var j = i + 1
+		if unicode.IsLetter(text[i]) {
+			for j < len(text) && unicode.IsLetter(text[j]) {
+				j++
+			}
+		}
+		segments = append(segments, text[i:j])
+		i = j
+	}
+	return segments
+}
+
+func (g *keyStrokeGenerator) produces(keys, expected []rune) bool {
+	var engine = NewEngine(g.im, g.flags)
+	engine.ProcessString(string(keys), VietnameseMode)
+	return engine.GetProcessedString(VietnameseMode|FullText) == string(expected)
+}
+
+func (g *keyStrokeGenerator) findSegmentKeys(ctxKeys, ctxOutput, segment []rune) ([]rune, bool) {
+	var expected = append(append([]rune(nil), ctxOutput...), segment...)
+	var try = func(candidate []rune) bool {
+		return g.produces(append(append([]rune(nil), ctxKeys...), candidate...), expected)
+	}
+	for _, candidate := range g.wordCandidates(segment) {
+		if try(candidate) {
+			return candidate, true
+		}
+	}
+	// type the characters one by one, each of them may need its last key to be
+	// repeated in order to undo an unwanted transformation
+	var keys []rune
+	for i, chr := range segment {
+		var found = false
+		expected = append(append([]rune(nil), ctxOutput...), segment[:i+1]...)
+		for _, candidate := range g.charCandidates(chr) {
+			var prefix = append(append([]rune(nil), ctxKeys...), keys...)
+			for n := 0; n < 3 && !found; n++ {
+				if g.produces(append(prefix, candidate...), expected) {
+					keys = append(keys, candidate...)
+					found = true
+				}
+				candidate = append(candidate, candidate[len(candidate)-1])
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return keys, true
+}
+
+// wordCandidates builds the key sequences a user would type for a word: the
+// marks right after their letters or at the end of the word, the tone right
+// after its vowel or at the end of the word, with or without the keys which
+// append a marked letter at once. They are sorted by length.
+func (g *keyStrokeGenerator) wordCandidates(word []rune) [][]rune {
+	var candidates [][]rune
+	var seen = map[string]bool{}
+	for _, useAppending := range []bool{true, false} {
+		for _, marksAtEnd := range []bool{false, true} {
+			for _, toneAtEnd := range []bool{true, false} {
+				var keys, ok = g.wordKeys(word, useAppending, marksAtEnd, toneAtEnd)
+				if ok && !seen[string(keys)] {
+					seen[string(keys)] = true
+					candidates = append(candidates, keys)
+				}
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i]) < len(candidates[j])
+	})
+	return candidates
+}
+
+func (g *keyStrokeGenerator) wordKeys(word []rune, useAppending, marksAtEnd, toneAtEnd bool) ([]rune, bool) {
+	var keys, markKeys, toneKeys []rune
+	var isUpperWord = isUpperCaseWord(word)
+	for _, chr := range word {
+		var lowerChr = unicode.ToLower(chr)
+		var toneKey, hasToneKey = g.toneKey(FindToneFromChar(lowerChr))
+		if !hasToneKey {
+			return nil, false
+		}
+		var letterKeys, markKey, ok = g.letterKeys(chr, useAppending)
+		if !ok {
+			return nil, false
+		}
+		keys = append(keys, letterKeys...)
+		if markKey != 0 {
+			if marksAtEnd {
+				if len(markKeys) == 0 || markKeys[len(markKeys)-1] != markKey {
+					markKeys = append(markKeys, markKey)
+				}
+			} else {
+				keys = append(keys, markKey)
+			}
+		}
+		if toneKey != 0 {
+			if toneAtEnd {
+				toneKeys = append(toneKeys, toneKey)
+			} else {
+				keys = append(keys, toneKey)
+			}
+		}
+	}
+	keys = append(append(keys, markKeys...), toneKeys...)
+	if isUpperWord {
+		for i, key := range keys {
+			keys[i] = unicode.ToUpper(key)
+		}
+	}
+	return keys, true
+}
+
+// charCandidates lists the ways to type a single character
+func (g *keyStrokeGenerator) charCandidates(chr rune) [][]rune {
+	var candidates [][]rune
+	var toneKey, hasToneKey = g.toneKey(FindToneFromChar(unicode.ToLower(chr)))
+	if !hasToneKey {
+		return [][]rune{{chr}}
+	}
+	for _, useAppending := range []bool{true, false} {
+		var keys, markKey, ok = g.letterKeys(chr, useAppending)
+		if !ok {
+			continue
+		}
+		if markKey != 0 {
+			keys = append(keys, markKey)
+		}
+		if toneKey != 0 {
+			keys = append(keys, toneKey)
+		}
+		candidates = append(candidates, keys)
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, []rune{chr})
+	}
+	return candidates
+}
+
+// letterKeys returns the keys of a character without its tone, the mark key is
+// returned separately so that it can be typed later
+func (g *keyStrokeGenerator) letterKeys(chr rune, useAppending bool) ([]rune, rune, bool) {
+	var isUpperCase = unicode.IsUpper(chr)
+	var toneless = AddToneToChar(unicode.ToLower(chr), 0)
+	var base = g.im.letters.addMark(toneless, 0)
+	var toCase = func(key rune) rune {
+		if isUpperCase {
+			return unicode.ToUpper(key)
+		}
+		return key
+	}
+	if toneless == base {
+		return []rune{toCase(base)}, 0, true
+	}
+	if useAppending {
+		if key, ok := g.appendingKey(toneless, isUpperCase); ok {
+			return []rune{key}, 0, true
+		}
+	}
+	var mark, _ = g.im.letters.findMarkFromChar(toneless)
+	if key, ok := g.markKey(base, mark); ok {
+		return []rune{toCase(base)}, key, true
+	}
+	return nil, 0, false
+}
+
+func (g *keyStrokeGenerator) toneKey(tone Tone) (rune, bool) {
+	if tone == ToneNone {
+		return 0, true
+	}
+	return g.findKey(func(rule Rule) bool {
+		return rule.EffectType == ToneTransformation && rule.GetTone() == tone
+	})
+}
+
+func (g *keyStrokeGenerator) markKey(base rune, mark Mark) (rune, bool) {
+	return g.findKey(func(rule Rule) bool {
+		return rule.EffectType == MarkTransformation && rule.GetMark() == mark && rule.EffectOn == base
+	})
+}
+
+// appendingKey finds a key which appends the (toneless) character at once,
+// e.g. "w" appends "ư" in Telex 2 and "[" appends "ư" in Microsoft layout
+func (g *keyStrokeGenerator) appendingKey(chr rune, isUpperCase bool) (rune, bool) {
+	var target = chr
+	if isUpperCase {
+		target = unicode.ToUpper(chr)
+	}
+	if key, ok := g.findKey(func(rule Rule) bool {
+		return rule.EffectType == Appending && len(rule.AppendedRules) == 0 && rule.EffectOn == target
+	}); ok || !isUpperCase {
+		return key, ok
+	}
+	// an upper case letter key appends the upper case form
+	var key, ok = g.findKey(func(rule Rule) bool {
+		return rule.EffectType == Appending && len(rule.AppendedRules) == 0 && rule.EffectOn == chr && unicode.IsLetter(rule.Key)
+	})
+	return unicode.ToUpper(key), ok
+}
+
+// findKey returns the smallest key of the matching rules, the rules of an input
+// method are built from a map so their order is not stable. The rules of the
+// multi-key triggers are left out.
+func (g *keyStrokeGenerator) findKey(match func(rule Rule) bool) (rune, bool) {
+	var key rune
+	var found = false
+	for _, rule := range g.im.Rules {
+		if len(rule.Prefix) == 0 && match(rule) && (!found || rule.Key < key) {
+			key = rule.Key
+			found = true
+		}
+	}
+	return key, found
+}
+
+func isUpperCaseWord(word []rune) bool {
+	if len(word) < 2 {
+		return false
+	}
+	for _, chr := range word {
+		if !unicode.IsUpper(chr) {
+			return false
+		}
+	}
+	return true
+}
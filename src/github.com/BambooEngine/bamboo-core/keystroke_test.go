@@ -0,0 +1,75 @@
+package bamboo
+
+import (
+	"testing"
+)
+
+func TestGenerateKeyStrokes(t *testing.T) {
+	var tests = []struct {
+		im       string
+		input    string
+		expected string
+	}{
+		{"Telex", "việt", "vieetj"},
+		{"Telex", "Người", "Nguoiwf"},
+		{"Telex 2", "ưa", "wa"},
+		{"VNI", "việt", "vie65t"},
+		{"VIQR", "việt", "vie^.t"},
+		{"Microsoft layout", "đường", "0[]ng2"},
+	}
+	for _, test := range tests {
+		var im = ParseInputMethod(InputMethodDefinitions, test.im)
+		var keys, err = GenerateKeyStrokes(im, EstdFlags, test.input)
+		if err != nil || len([]rune(keys)) > len([]rune(test.expected)) {
+			t.Errorf("GenerateKeyStrokes(%s, %s), got [%s, %v] expected [%s]", test.im, test.input, keys, err, test.expected)
+		}
+	}
+}
+
+func TestGenerateKeyStrokesRoundTrip(t *testing.T) {
+	var texts = []string{
+		"Tiếng Việt có dấu, chữ Đ hoa; người ướt ươm.",
+		"NGHIÊNG NGẢ khuỷu tay, quốc gia, gì giữa",
+		"Bảng 1: a1 aa dd ww windows 2019!",
+		"ăn ở, thuở xưa (ngày 25/12) - uống!",
+	}
+	for _, name := range []string{"Telex", "Telex 2", "VNI", "VIQR", "Microsoft layout", "Telex + VNI + VIQR"} {
+		var im = ParseInputMethod(InputMethodDefinitions, name)
+		for _, text := range texts {
+			var keys, err = GenerateKeyStrokes(im, EstdFlags, text)
+			if err != nil {
+				t.Errorf("GenerateKeyStrokes(%s, %s), got error [%v]", name, text, err)
+				continue
+			}
+			var ng = NewEngine(im, EstdFlags)
+			ng.ProcessString(keys, VietnameseMode)
+			if ng.GetProcessedString(VietnameseMode|FullText) != text {
+				t.Errorf("Process [%s] with %s, got [%s] expected [%s]", keys, name, ng.GetProcessedString(VietnameseMode|FullText), text)
+			}
+		}
+	}
+}
+
+func TestGenerateKeyStrokesCustomInputMethod(t *testing.T) {
+	var imDef = map[string]InputMethodDefinition{
+		"Custom": {
+			"q": "DauSac",
+			"k": "DauNang",
+			"h": "AEO_ÂÊÔ",
+			"y": "D_Đ",
+		},
+	}
+	var im = ParseInputMethod(imDef, "Custom")
+	var keys, err = GenerateKeyStrokes(im, EstdFlags, "đấy")
+	if err != nil || keys != "dyahqyy" {
+		t.Errorf("GenerateKeyStrokes(Custom, đấy), got [%s, %v] expected [dyahqyy]", keys, err)
+	}
+}
+
+func TestGenerateKeyStrokesImpossible(t *testing.T) {
+	// "uống" then "??" gives "uông?": the acute tone is lost when the hook is undone
+	var im = ParseInputMethod(InputMethodDefinitions, "VIQR")
+	if keys, err := GenerateKeyStrokes(im, EstdFlags, "uống?"); err == nil {
+		t.Errorf("GenerateKeyStrokes(VIQR, uống?), got [%s] expected an error", keys)
+	}
+}
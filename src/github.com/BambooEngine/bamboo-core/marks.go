@@ -0,0 +1,255 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"unicode"
+)
+
+// The mark inventory beyond standard Vietnamese is declared by the input methods.
+// A mark rule may turn a letter into any letter written as a base letter and
+// combining marks, e.g. "EIOU_ĔĬŎŬ" puts the breve on e, i, o and u, "C_Č" puts
+// the caron on c and "O_Ơ̆" puts the horn and the breve on o. A mark is
+// identified by its combining marks, the marks which are not standard get a
+// number after MarkRaw in the order they are declared.
+// A letter which has no precomposed form is kept in the private use area
+// while it is being typed, Flatten renders it as the base letter followed by
+// the combining marks.
+// The declared letters belong to the input method which declares them, the
+// rules of the input method refer to its letter table.
+
+// precomposedLetters lists the letters of the Latin scripts of the minority
+// languages which have a precomposed form
+var precomposedLetters = map[string]rune{
+	"e\u0306": 'ĕ', "i\u0306": 'ĭ', "o\u0306": 'ŏ', "u\u0306": 'ŭ', "g\u0306": 'ğ',
+	"a\u030C": 'ǎ', "e\u030C": 'ě', "i\u030C": 'ǐ', "o\u030C": 'ǒ', "u\u030C": 'ǔ',
+	"c\u030C": 'č', "d\u030C": 'ď', "g\u030C": 'ǧ', "j\u030C": 'ǰ', "k\u030C": 'ǩ',
+	"n\u030C": 'ň', "r\u030C": 'ř', "s\u030C": 'š', "t\u030C": 'ť', "z\u030C": 'ž',
+	"a\u0304": 'ā', "e\u0304": 'ē', "i\u0304": 'ī', "o\u0304": 'ō', "u\u0304": 'ū', "y\u0304": 'ȳ',
+	"a\u0308": 'ä', "e\u0308": 'ë', "i\u0308": 'ï', "o\u0308": 'ö', "u\u0308": 'ü', "y\u0308": 'ÿ',
+	"c\u0327": 'ç', "s\u0327": 'ş', "t\u0327": 'ţ',
+	"n\u0301": 'ń', "c\u0301": 'ć', "s\u0301": 'ś', "z\u0301": 'ź',
+	"a\u030A": 'å', "u\u030A": 'ů',
+	"n\u0303": 'ñ',
+}
+
+var decomposedLetters = func() map[rune]string {
+	var letters = make(map[rune]string, len(precomposedLetters))
+	for letter, chr := range precomposedLetters {
+		letters[chr] = letter
+	}
+	return letters
+}()
+
+// privateUseStart is the first rune given to a letter without a precomposed form
+const privateUseStart = rune(0xF0000)
+
+// letterTable holds the letters declared by the mark rules of an input method,
+// a nil table only knows the standard Vietnamese letters
+type letterTable struct {
+	// families extends the standard mark families with the declared letters
+	families map[rune]string
+	// privateLetters maps the private use letters to their base letter and combining marks
+	privateLetters map[rune][]rune
+	declared       map[rune]bool
+	// marks holds the combining marks of the marks after MarkRaw
+	marks       []string
+	nextPrivate rune
+}
+
+func newLetterTable() *letterTable {
+	var families = make(map[rune]string, len(marksMaps))
+	for k, v := range marksMaps {
+		families[k] = v
+	}
+	return &letterTable{
+		families:       families,
+		privateLetters: map[rune][]rune{},
+		declared:       map[rune]bool{},
+		nextPrivate:    privateUseStart,
+	}
+}
+
+func (t *letterTable) isEmpty() bool {
+	return t == nil || len(t.declared) == 0
+}
+
+func (t *letterTable) markFamilies() map[rune]string {
+	if t == nil {
+		return marksMaps
+	}
+	return t.families
+}
+
+func (t *letterTable) getPrivateLetter(chr rune) ([]rune, bool) {
+	if t == nil {
+		return nil, false
+	}
+	var letter, found = t.privateLetters[chr]
+	return letter, found
+}
+
+// isDeclared tells whether the letter comes from a mark declared by the input
+// method rather than from the standard Vietnamese marks
+func (t *letterTable) isDeclared(chr rune) bool {
+	return t != nil && t.declared[unicode.ToLower(chr)]
+}
+
+// addMark is AddMarkToChar with the declared letters
+func (t *letterTable) addMark(chr rune, mark uint8) rune {
+	var families = t.markFamilies()
+	tone := FindToneFromChar(chr)
+	chr = AddToneToChar(chr, 0)
+	chr = addMarkToTonelessChar(families, chr, mark)
+	return AddToneToChar(chr, uint8(tone))
+}
+
+// findMarkFromChar is FindMarkFromChar with the declared letters
+func (t *letterTable) findMarkFromChar(chr rune) (Mark, bool) {
+	if str, found := t.markFamilies()[chr]; found {
+		for pos, v := range []rune(str) {
+			if v == chr {
+				return Mark(pos), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// decomposeLetter splits a letter, which may be followed by combining marks,
+// into its base letter and its combining marks
+func (t *letterTable) decomposeLetter(letter []rune) (rune, string) {
+	if len(letter) == 0 {
+		return 0, ""
+	}
+	var base = letter[0]
+	var marks string
+	if decomposed, found := decomposedLetters[base]; found {
+		var runes = []rune(decomposed)
+		base, marks = runes[0], string(runes[1:])
+	} else if privateLetter, found := t.getPrivateLetter(base); found {
+		base, marks = privateLetter[0], string(privateLetter[1:])
+	} else if mark, found := FindMarkFromChar(base); found && mark != MarkNone {
+		if mark == MarkDash {
+			// đ is a letter of its own
+			return base, string(letter[1:])
+		}
+		base, marks = AddMarkToTonelessChar(base, 0), string(nfdMarks[mark])
+	}
+	return base, marks + string(letter[1:])
+}
+
+// isMarkedLetter tells whether the letter is the base letter with some marks
+func (t *letterTable) isMarkedLetter(base rune, letter []rune) bool {
+	var letterBase, marks = t.decomposeLetter(letter)
+	return letterBase == AddMarkToTonelessChar(base, 0) && marks != ""
+}
+
+// findMark finds the mark of the combining marks, a new mark is numbered after
+// the declared ones
+func (t *letterTable) findMark(marks string) Mark {
+	for mark, combiningChar := range nfdMarks {
+		if string(combiningChar) == marks {
+			return mark
+		}
+	}
+	for i, declaredMark := range t.marks {
+		if declaredMark == marks {
+			return MarkRaw + 1 + Mark(i)
+		}
+	}
+	return MarkRaw + 1 + Mark(len(t.marks))
+}
+
+// declareMarkedLetter adds a letter to the mark family of the base letter and
+// returns the rune which stands for it
+func (t *letterTable) declareMarkedLetter(base rune, letter []rune) (Mark, rune, bool) {
+	if !t.isMarkedLetter(base, letter) {
+		return MarkNone, 0, false
+	}
+	var _, marks = t.decomposeLetter(letter)
+	var mark = t.findMark(marks)
+	base = AddMarkToTonelessChar(base, 0)
+	var family = []rune(t.families[base])
+	if len(family) == 0 {
+		family = []rune{base}
+	}
+	if int(mark) < len(family) && family[mark] != '_' {
+		return mark, family[mark], true
+	}
+	if mark == MarkRaw+1+Mark(len(t.marks)) {
+		t.marks = append(t.marks, marks)
+	}
+	var chr, precomposed = precomposedLetters[string(base)+marks]
+	if !precomposed {
+		chr = t.nextPrivate
+		t.nextPrivate++
+		t.privateLetters[chr] = append([]rune{base}, []rune(marks)...)
+	}
+	t.declared[chr] = true
+	for len(family) <= int(mark) {
+		family = append(family, '_')
+	}
+	family[mark] = chr
+	for _, c := range family {
+		if c != '_' {
+			t.families[c] = string(family)
+		}
+	}
+	return mark, chr, true
+}
+
+// declareLetter lets an appending rule type a precomposed letter which is not
+// Vietnamese, e.g. "__ĕ", its tone is then rendered as a combining mark
+func (t *letterTable) declareLetter(chr rune) {
+	if _, found := decomposedLetters[unicode.ToLower(chr)]; found {
+		t.declared[unicode.ToLower(chr)] = true
+	}
+}
+
+// appendLetter renders a letter of the composition, the tone of a declared
+// letter is rendered as a combining mark
+func (t *letterTable) appendLetter(canvas []rune, chr rune, tone Tone, isUpperCase bool) []rune {
+	var letter, declared = t.getPrivateLetter(chr)
+	if !declared {
+		letter = []rune{chr}
+	}
+	for i, c := range letter {
+		if i == 0 && isUpperCase {
+			c = unicode.ToUpper(c)
+		}
+		canvas = append(canvas, c)
+	}
+	if tone != ToneNone && t.isDeclared(chr) {
+		canvas = append(canvas, nfdTones[tone])
+	}
+	return canvas
+}
+
+// splitLetters cuts a text into letters followed by their combining marks
+func splitLetters(text string) [][]rune {
+	var letters [][]rune
+	for _, chr := range text {
+		if unicode.Is(unicode.M, chr) && len(letters) > 0 {
+			letters[len(letters)-1] = append(letters[len(letters)-1], chr)
+			continue
+		}
+		letters = append(letters, []rune{chr})
+	}
+	return letters
+}
+
+func hasDeclaredLetter(composition []*Transformation) bool {
+	for _, trans := range composition {
+		if trans.Rule.letters.isDeclared(trans.Rule.Result) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package bamboo
+
+import (
+	"testing"
+)
+
+func newMinorityEngine() IEngine {
+	var imDef = InputMethodDefinition{}
+	for key, line := range InputMethodDefinitions["Telex"] {
+		imDef[key] = line
+	}
+	imDef["v"] = "EIOU_ĔĬŎŬ"
+	imDef["q"] = "O_Ơ\u0306"
+	imDef["'"] = "CZ_ČŽ"
+	var im = ParseInputMethod(map[string]InputMethodDefinition{"Minority": imDef}, "Minority")
+	return NewEngine(im, EstdFlags)
+}
+
+func TestProcessDeclaredMarks(t *testing.T) {
+	var tests = []struct {
+		keys, expected string
+	}{
+		{"mev", "mĕ"},
+		{"MEV", "MĔ"},
+		{"ov", "ŏ"},
+		{"oov", "ŏ"},
+		{"mevv", "mev"},
+		{"c'", "č"},
+		{"Z'", "Ž"},
+		{"boq", "bơ̆"},
+		{"Bowq", "Bơ̆"},
+		{"Oq", "Ơ̆"},
+		{"mevs", "mĕ́"},
+		{"mesv", "mĕ́"},
+	}
+	for _, test := range tests {
+		var ng = newMinorityEngine()
+		ng.ProcessString(test.keys, VietnameseMode)
+		if ng.GetProcessedString(VietnameseMode) != test.expected {
+			t.Errorf("Process [%s], got [%+q] expected [%+q]", test.keys, ng.GetProcessedString(VietnameseMode), test.expected)
+		}
+		if ng.GetProcessedString(EnglishMode) != test.keys {
+			t.Errorf("Process-ENG [%s], got [%s] expected [%s]", test.keys, ng.GetProcessedString(EnglishMode), test.keys)
+		}
+	}
+	var ng = newMinorityEngine()
+	ng.ProcessString("boqs", VietnameseMode)
+	if ng.GetProcessedString(VietnameseMode|MarkLess|ToneLess) != "bo" {
+		t.Errorf("Process-MarkLess [boqs], got [%s] expected [bo]", ng.GetProcessedString(VietnameseMode|MarkLess|ToneLess))
+	}
+}
+
+func TestDeclareMarkedLetter(t *testing.T) {
+	var letters = newLetterTable()
+	var mark, chr, ok = letters.declareMarkedLetter('e', []rune("ĕ"))
+	if !ok || mark != MarkBreve || chr != 'ĕ' {
+		t.Errorf("Declare [ĕ], got [%d %c %v] expected [%d ĕ true]", mark, chr, ok, MarkBreve)
+	}
+	if letters.addMark('é', uint8(MarkBreve)) != 'ĕ' || letters.addMark('ĕ', 0) != 'e' {
+		t.Errorf("Add breve to [é], got [%c] expected [ĕ]", letters.addMark('é', uint8(MarkBreve)))
+	}
+	var caron, _, _ = letters.declareMarkedLetter('s', []rune("š"))
+	var caronOnC, _, _ = letters.declareMarkedLetter('c', []rune("č"))
+	if caron <= MarkRaw || caron != caronOnC {
+		t.Errorf("Declare caron, got marks [%d %d] expected the same mark after MarkRaw", caron, caronOnC)
+	}
+	if _, _, ok := letters.declareMarkedLetter('a', []rune("ĕ")); ok {
+		t.Errorf("Declare [ĕ] on [a], got ok expected a failure")
+	}
+	for _, chr := range []rune("ñüç") {
+		if letters.isDeclared(chr) {
+			t.Errorf("isDeclared [%c], got true expected false", chr)
+		}
+	}
+}
+
+func TestDeclaredLettersPerInputMethod(t *testing.T) {
+	var ng = newMinorityEngine()
+	ng.ProcessString("boq", VietnameseMode)
+	var telex = NewEngine(ParseInputMethod(InputMethodDefinitions, "Telex"), EstdFlags)
+	if telex.(*BambooEngine).inputMethod.letters != nil {
+		t.Errorf("Telex letter table, got declared letters expected none")
+	}
+	if AddMarkToChar('e', uint8(MarkBreve)) != 'e' {
+		t.Errorf("Add breve to [e] without a letter table, got [%c] expected [e]", AddMarkToChar('e', uint8(MarkBreve)))
+	}
+	var other = newMinorityEngine()
+	var snapshot = ng.(*BambooEngine).Snapshot()
+	if err := other.(*BambooEngine).RestoreSnapshot(snapshot); err != nil {
+		t.Fatalf("RestoreSnapshot, got error %v", err)
+	}
+	if other.GetProcessedString(VietnameseMode) != ng.GetProcessedString(VietnameseMode) {
+		t.Errorf("Restore [boq] in another engine, got [%+q] expected [%+q]", other.GetProcessedString(VietnameseMode), ng.GetProcessedString(VietnameseMode))
+	}
+}
@@ -10,6 +10,7 @@ package bamboo
 
 import (
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -29,8 +30,13 @@ const (
 	MarkTransformation EffectType = iota
 	ToneTransformation EffectType = iota
 	Replacing          EffectType = iota
+	Escaping           EffectType = iota
 )
 
+// escapeRule is the rule of a dead key which makes the next key typed as it is,
+// e.g. "\\": "PhimThoat" makes a\s typed "as" in Telex
+const escapeRule = "PhimThoat"
+
 // type alias
 type Mark uint8
 
@@ -61,6 +67,9 @@ type Rule struct {
 	EffectOn      rune
 	Result        rune
 	AppendedRules []Rule
+	Prefix        []rune // the keys typed before Key in a multi-key trigger, e.g. "d" of "[dd]"
+	// letters is the letter table of the input method if it declares letters
+	letters *letterTable
 }
 
 func (r *Rule) SetTone(tone Tone) {
@@ -85,13 +94,18 @@ type InputMethod struct {
 	SuperKeys     []rune
 	ToneKeys      []rune
 	AppendingKeys []rune
+	EscapeKeys    []rune
 	Keys          []rune
+	keyRules      map[rune][]Rule
+	sequenceRules map[rune][][]Rule
+	letters       *letterTable
 }
 
+// ParseInputMethod parses the definition of the input method only, the other
+// definitions are left as they are
 func ParseInputMethod(imDef map[string]InputMethodDefinition, imName string) InputMethod {
-	var inputMethods = parseInputMethods(imDef)
-	if inputMethod, found := inputMethods[imName]; found {
-		return inputMethod
+	if imDefinition, found := imDef[imName]; found {
+		return parseInputMethod(imName, imDefinition)
 	}
 	return InputMethod{}
 }
@@ -99,34 +113,124 @@ func ParseInputMethod(imDef map[string]InputMethodDefinition, imName string) Inp
 func parseInputMethods(imDef map[string]InputMethodDefinition) map[string]InputMethod {
 	var inputMethods = make(map[string]InputMethod, len(imDef))
 	for name, imDefinition := range imDef {
-		var im InputMethod
-		im.Name = name
-		for keyStr, line := range imDefinition {
-			var keys = []rune(keyStr)
-			if len(keys) == 0 {
-				continue
-			}
-			var key = keys[0]
-			im.Rules = append(im.Rules, ParseRules(key, line)...)
-			if strings.Contains(strings.ToLower(line), "uo") {
-				im.SuperKeys = append(im.SuperKeys, key)
-			}
-			im.Keys = append(im.Keys, key)
+		inputMethods[name] = parseInputMethod(name, imDefinition)
+	}
+	return inputMethods
+}
+
+func parseInputMethod(name string, imDefinition InputMethodDefinition) InputMethod {
+	var im InputMethod
+	im.Name = name
+	im.letters = newLetterTable()
+	// the keys are parsed in order so that the declared marks and letters are
+	// numbered the same way every time the definition is parsed
+	var keyStrs = make([]string, 0, len(imDefinition))
+	for keyStr := range imDefinition {
+		keyStrs = append(keyStrs, keyStr)
+	}
+	sort.Strings(keyStrs)
+	for _, keyStr := range keyStrs {
+		var line = imDefinition[keyStr]
+		var keys = parseDefinitionKey(keyStr)
+		if len(keys) == 0 {
+			continue
 		}
-		for _, rule := range im.Rules {
-			if rule.EffectType == Appending {
-				im.AppendingKeys = append(im.AppendingKeys, rule.Key)
-			}
-			if rule.EffectType == ToneTransformation {
-				im.ToneKeys = append(im.ToneKeys, rule.Key)
+		// a rule is triggered by the last key of the sequence
+		var key = keys[len(keys)-1]
+		var rules = im.letters.parseRules(key, line)
+		if len(keys) > 1 {
+			var prefix = []rune(strings.ToLower(string(keys[:len(keys)-1])))
+			for i := range rules {
+				rules[i].Prefix = prefix
 			}
+		} else if strings.Contains(strings.ToLower(line), "uo") {
+			im.SuperKeys = append(im.SuperKeys, key)
 		}
-		inputMethods[name] = im
+		im.Rules = append(im.Rules, rules...)
+		im.Keys = append(im.Keys, key)
+	}
+	for _, rule := range im.Rules {
+		if len(rule.Prefix) > 0 {
+			continue
+		}
+		if rule.EffectType == Escaping {
+			im.EscapeKeys = append(im.EscapeKeys, rule.Key)
+		}
+		if rule.EffectType == Appending {
+			im.AppendingKeys = append(im.AppendingKeys, rule.Key)
+		}
+		if rule.EffectType == ToneTransformation {
+			im.ToneKeys = append(im.ToneKeys, rule.Key)
+		}
+	}
+	if im.letters.isEmpty() {
+		im.letters = nil
+	}
+	im.letters.attach(im.Rules)
+	im.compile()
+	return im
+}
+
+// parseDefinitionKey returns the keys which are typed for a key of a
+// definition. A key sequence is written in brackets, e.g. "[dd]", any other key
+// is its first character as it has always been, so "ww" is typed with "w".
+func parseDefinitionKey(keyStr string) []rune {
+	var keys = []rune(keyStr)
+	if len(keys) > 3 && keys[0] == '[' && keys[len(keys)-1] == ']' {
+		return keys[1 : len(keys)-1]
+	}
+	if len(keys) > 1 {
+		return keys[:1]
+	}
+	return keys
+}
+
+// compile indexes the rules by their key so that a key stroke does not look
+// through all the rules. The rules of the multi-key triggers are grouped by
+// their prefix, the longest prefixes first.
+func (im *InputMethod) compile() {
+	im.keyRules = make(map[rune][]Rule)
+	im.sequenceRules = make(map[rune][][]Rule)
+	var groups = map[string]int{}
+	for _, rule := range im.Rules {
+		if len(rule.Prefix) == 0 {
+			im.keyRules[rule.Key] = append(im.keyRules[rule.Key], rule)
+			continue
+		}
+		var group = string(rule.Key) + string(rule.Prefix)
+		if i, found := groups[group]; found {
+			im.sequenceRules[rule.Key][i] = append(im.sequenceRules[rule.Key][i], rule)
+			continue
+		}
+		groups[group] = len(im.sequenceRules[rule.Key])
+		im.sequenceRules[rule.Key] = append(im.sequenceRules[rule.Key], []Rule{rule})
+	}
+	for _, sequenceRules := range im.sequenceRules {
+		sort.SliceStable(sequenceRules, func(i, j int) bool {
+			return len(sequenceRules[i][0].Prefix) > len(sequenceRules[j][0].Prefix)
+		})
 	}
-	return inputMethods
 }
 
 func ParseRules(key rune, line string) []Rule {
+	var letters = newLetterTable()
+	var rules = letters.parseRules(key, line)
+	letters.attach(rules)
+	return rules
+}
+
+// attach lets the rules which may produce the declared letters render them
+func (t *letterTable) attach(rules []Rule) {
+	if t.isEmpty() {
+		return
+	}
+	for i := range rules {
+		rules[i].letters = t
+		t.attach(rules[i].AppendedRules)
+	}
+}
+
+func (t *letterTable) parseRules(key rune, line string) []Rule {
 	var rules []Rule
 	if tone, ok := tones[line]; ok {
 		var rule Rule
@@ -134,41 +238,67 @@ func ParseRules(key rune, line string) []Rule {
 		rule.EffectType = ToneTransformation
 		rule.Effect = uint8(tone)
 		rules = append(rules, rule)
+	} else if line == escapeRule {
+		rules = append(rules, Rule{Key: key, EffectType: Escaping})
 	} else {
-		rules = ParseTonelessRules(key, line)
+		rules = t.parseTonelessRules(key, line)
 	}
 	return rules
 }
 
-var regDsl = regexp.MustCompile(`([a-zA-Z]+)_(\p{L}+)([_\p{L}]*)`)
+var regDsl = regexp.MustCompile(`([a-zA-Z]+)_([\p{L}\p{M}]+)([_\p{L}]*)`)
 
 func ParseTonelessRules(key rune, line string) []Rule {
+	var letters = newLetterTable()
+	var rules = letters.parseTonelessRules(key, line)
+	letters.attach(rules)
+	return rules
+}
+
+func (t *letterTable) parseTonelessRules(key rune, line string) []Rule {
 	var rules []Rule
 	if regDsl.MatchString(line) {
 		parts := regDsl.FindStringSubmatch(strings.ToLower(line))
 		effectiveOns := []rune(parts[1])
-		results := []rune(parts[2])
+		results := splitLetters(parts[2])
 		for i, effectiveOn := range effectiveOns {
-			effect, found := FindMarkFromChar(results[i])
+			if i >= len(results) {
+				break
+			}
+			result := results[i][0]
+			effect, found := FindMarkFromChar(result)
+			if !found || len(results[i]) > 1 {
+				// a letter which is not in the standard mark families
+				effect, result, found = t.declareMarkedLetter(effectiveOn, results[i])
+			}
 			if !found {
 				continue
 			}
-			rules = append(rules, ParseToneLessRule(key, effectiveOn, results[i], effect)...)
+			rules = append(rules, t.parseToneLessRule(key, effectiveOn, result, effect)...)
 		}
-		if rule, ok := getAppendingRule(key, parts[3]); ok {
+		if rule, ok := t.getAppendingRule(key, parts[3]); ok {
 			rules = append(rules, rule)
 		}
 
-	} else if rule, ok := getAppendingRule(key, line); ok {
+	} else if rule, ok := t.getAppendingRule(key, line); ok {
 		rules = append(rules, rule)
 	}
 	return rules
 }
 
 func ParseToneLessRule(key, effectiveOn, result rune, effect Mark) []Rule {
+	var letters *letterTable
+	return letters.parseToneLessRule(key, effectiveOn, result, effect)
+}
+
+func (t *letterTable) parseToneLessRule(key, effectiveOn, result rune, effect Mark) []Rule {
 	var rules []Rule
 	var tones = []Tone{ToneNone, ToneDot, ToneAcute, ToneGrave, ToneHook, ToneTilde}
-	for _, chr := range getMarkFamily(effectiveOn) {
+	for _, chr := range getMarkFamily(t.markFamilies(), effectiveOn) {
+		if chr != result && t.isDeclared(chr) {
+			// the letters declared by other rules are left to them
+			continue
+		}
 		if chr == result {
 			var rule Rule
 			rule.Key = key
@@ -202,11 +332,14 @@ func ParseToneLessRule(key, effectiveOn, result rune, effect Mark) []Rule {
 
 var regDslAppending = regexp.MustCompile(`(_?)_(\p{L}+)`)
 
-func getAppendingRule(key rune, value string) (Rule, bool) {
+func (t *letterTable) getAppendingRule(key rune, value string) (Rule, bool) {
 	var rule Rule
 	if regDslAppending.MatchString(value) {
 		parts := regDslAppending.FindStringSubmatch(value)
 		chars := []rune(parts[2])
+		for _, chr := range chars {
+			t.declareLetter(chr)
+		}
 		rule.Key = key
 		rule.EffectType = Appending
 		rule.EffectOn = chars[0]
@@ -139,3 +139,57 @@ func TestAppendRule(t *testing.T) {
 
 func TestParseRulesWithIm(t *testing.T) {
 }
+
+func TestParseKeySequenceRules(t *testing.T) {
+	var im = ParseInputMethod(map[string]InputMethodDefinition{"Test": {
+		"[dd]": "__đ",
+		"\\":   "PhimThoat",
+		"ww":   "UO_ƯƠ",
+	}}, "Test")
+	if string(im.Keys) != "d\\w" {
+		t.Fatalf("Parse key sequences, got keys %q expected [d\\w]", string(im.Keys))
+	}
+	for _, rule := range im.Rules {
+		if rule.EffectType == Appending && (rule.Key != 'd' || string(rule.Prefix) != "d" || rule.EffectOn != 'đ') {
+			t.Errorf("Parse [[dd]], got %v expected the rule of 'd' with prefix \"d\"", rule)
+		}
+		// a key out of brackets is its first character
+		if rule.EffectType == MarkTransformation && (rule.Key != 'w' || len(rule.Prefix) != 0) {
+			t.Errorf("Parse [ww], got %v expected the rule of 'w' without prefix", rule)
+		}
+		if rule.EffectType == Escaping && (rule.Key != '\\' || len(rule.Prefix) != 0) {
+			t.Errorf("Parse [\\], got %v expected an escape rule", rule)
+		}
+	}
+	if string(im.EscapeKeys) != "\\" || len(im.AppendingKeys) != 0 {
+		t.Errorf("Parse key sequences, got escape keys %q and appending keys %q expected [\\] and none", string(im.EscapeKeys), string(im.AppendingKeys))
+	}
+}
+
+func TestParseInputMethodIndex(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex")
+	for key, rules := range im.keyRules {
+		for _, rule := range rules {
+			if rule.Key != key || len(rule.Prefix) > 0 {
+				t.Errorf("Index [%c], got rule of [%s%c]", key, string(rule.Prefix), rule.Key)
+			}
+		}
+	}
+	var count = 0
+	for _, rules := range im.keyRules {
+		count += len(rules)
+	}
+	if count != len(im.Rules) {
+		t.Errorf("Index Telex, got [%d] rules expected [%d]", count, len(im.Rules))
+	}
+	if im := ParseInputMethod(InputMethodDefinitions, "Unknown"); im.Name != "" {
+		t.Errorf("Parse [Unknown], got [%s] expected an empty input method", im.Name)
+	}
+}
+
+func BenchmarkParseInputMethod(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseInputMethod(InputMethodDefinitions, "Telex 2")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"sync"
+)
+
+// Session makes an engine safe for concurrent use, e.g. by the goroutine which
+// receives the key events and the one which replays them. Every method holds
+// the session's lock while it runs, so a key is never processed on a
+// composition which is being changed by another goroutine. A sequence of calls
+// which must not be interleaved with the other goroutines, e.g. rebuilding the
+// composition from the surrounding text, runs in Do.
+type Session struct {
+	mu     sync.RWMutex
+	engine IEngine
+}
+
+var _ IEngine = (*Session)(nil)
+
+func NewSession(engine IEngine) *Session {
+	return &Session{engine: engine}
+}
+
+// Do runs fn with the lock held, fn must use the given engine rather than the
+// session, whose methods would wait for the lock forever
+func (s *Session) Do(fn func(engine IEngine)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.engine)
+}
+
+// SetEngine replaces the engine, e.g. when the input method is changed, the
+// goroutines which hold the session see the new engine on their next call
+func (s *Session) SetEngine(engine IEngine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine = engine
+}
+
+func (s *Session) SetFlag(flag uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.SetFlag(flag)
+}
+
+func (s *Session) SetSpellingProfile(profile *SpellingProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.SetSpellingProfile(profile)
+}
+
+func (s *Session) GetInputMethod() InputMethod {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.GetInputMethod()
+}
+
+func (s *Session) ProcessKey(key rune, mode Mode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.ProcessKey(key, mode)
+}
+
+func (s *Session) ProcessString(str string, mode Mode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.ProcessString(str, mode)
+}
+
+// GetProcessedString holds the write lock, the engine keeps the rendering of the
+// full text for the next call
+func (s *Session) GetProcessedString(mode Mode) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.engine.GetProcessedString(mode)
+}
+
+func (s *Session) IsValid(inputIsFullComplete bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.IsValid(inputIsFullComplete)
+}
+
+func (s *Session) CanProcessKey(key rune) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.CanProcessKey(key)
+}
+
+func (s *Session) RemoveLastChar(refreshLastToneTarget bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.RemoveLastChar(refreshLastToneTarget)
+}
+
+func (s *Session) RestoreLastWord(toVietnamese bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.RestoreLastWord(toVietnamese)
+}
+
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.Reset()
+}
+
+func (s *Session) Snapshot() *Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.Snapshot()
+}
+
+func (s *Session) RestoreSnapshot(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.engine.RestoreSnapshot(snapshot)
+}
@@ -0,0 +1,122 @@
+package bamboo
+
+import (
+	"sync"
+	"testing"
+)
+
+// The tests of the session are meant to be run with the race detector:
+// go test -race -run Session
+
+func newTestSession() *Session {
+	return NewSession(NewEngine(ParseInputMethod(InputMethodDefinitions, "Telex"), EstdFlags))
+}
+
+func TestSessionProcessKey(t *testing.T) {
+	var s = newTestSession()
+	s.ProcessString("tieengs", VietnameseMode)
+	if s.GetProcessedString(VietnameseMode) != "tiếng" {
+		t.Errorf("Process [tieengs], got [%s] expected [tiếng]", s.GetProcessedString(VietnameseMode))
+	}
+	s.RemoveLastChar(true)
+	if s.GetProcessedString(VietnameseMode) != "tiến" {
+		t.Errorf("Process [tieengs] then remove the last char, got [%s] expected [tiến]", s.GetProcessedString(VietnameseMode))
+	}
+	s.Reset()
+	if s.GetProcessedString(VietnameseMode) != "" {
+		t.Errorf("Reset, got [%s] expected []", s.GetProcessedString(VietnameseMode))
+	}
+}
+
+func TestSessionConcurrentKeys(t *testing.T) {
+	var s = newTestSession()
+	var expected = NewEngine(ParseInputMethod(InputMethodDefinitions, "Telex"), EstdFlags)
+	var typeKeys = func(engine IEngine) {
+		for j := 0; j < 50; j++ {
+			for _, key := range "vieetj " {
+				engine.ProcessKey(key, VietnameseMode)
+			}
+			engine.RemoveLastChar(true)
+			engine.RestoreLastWord(false)
+			engine.ProcessKey(' ', VietnameseMode)
+		}
+	}
+	typeKeys(expected)
+	var wg sync.WaitGroup
+	var done = make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		typeKeys(s)
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s.GetProcessedString(VietnameseMode)
+				s.GetProcessedString(FullText)
+				s.IsValid(false)
+				s.CanProcessKey('s')
+				s.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, mode := range []Mode{VietnameseMode | FullText, EnglishMode | FullText} {
+		if text := s.GetProcessedString(mode); text != expected.GetProcessedString(mode) {
+			t.Errorf("Process the keys while reading the text [%d], got [%s] expected [%s]", mode, text, expected.GetProcessedString(mode))
+		}
+	}
+}
+
+func TestSessionDo(t *testing.T) {
+	var s = newTestSession()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.Do(func(engine IEngine) {
+					// no other goroutine may change the composition in the middle
+					engine.Reset()
+					engine.ProcessString("dduwowcj", VietnameseMode)
+					if text := engine.GetProcessedString(VietnameseMode); text != "được" {
+						t.Errorf("Process [dduwowcj] in Do, got [%s] expected [được]", text)
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSessionSetEngine(t *testing.T) {
+	var s = newTestSession()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 200; j++ {
+			s.ProcessKey('a', VietnameseMode)
+			s.GetInputMethod()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			s.SetEngine(NewEngine(ParseInputMethod(InputMethodDefinitions, "VNI"), EstdFlags))
+		}
+	}()
+	wg.Wait()
+	if name := s.GetInputMethod().Name; name != "VNI" {
+		t.Errorf("SetEngine, got [%s] expected [VNI]", name)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"fmt"
+)
+
+// Snapshot is a copy of a composition which can be kept aside, e.g. as JSON,
+// and restored later, so that the word in progress keeps its tone placement
+// and its key strokes. The target of a transformation is kept as its index in
+// the composition. The marks and the letters which are declared by an input
+// method are numbered in the order of its keys, so a snapshot can be restored
+// with any input method parsed from the same definition.
+type Snapshot struct {
+	InputMethod     string                   `json:"input_method"`
+	Transformations []TransformationSnapshot `json:"transformations"`
+}
+
+type TransformationSnapshot struct {
+	Rule        Rule `json:"rule"`
+	Target      int  `json:"target"` // -1 if the transformation has no target
+	IsUpperCase bool `json:"upper_case,omitempty"`
+}
+
+// Snapshot copies the composition
+func (e *BambooEngine) Snapshot() *Snapshot {
+	var indexes = make(map[*Transformation]int, len(e.composition))
+	var snapshot = &Snapshot{
+		InputMethod:     e.inputMethod.Name,
+		Transformations: make([]TransformationSnapshot, len(e.composition)),
+	}
+	for i, trans := range e.composition {
+		indexes[trans] = i
+		var target = -1
+		if index, found := indexes[trans.Target]; found && trans.Target != nil {
+			target = index
+		}
+		snapshot.Transformations[i] = TransformationSnapshot{
+			Rule:        trans.Rule,
+			Target:      target,
+			IsUpperCase: trans.IsUpperCase,
+		}
+	}
+	return snapshot
+}
+
+// RestoreSnapshot replaces the composition with the one of the snapshot, the
+// snapshot must have been taken with the same input method
+func (e *BambooEngine) RestoreSnapshot(snapshot *Snapshot) error {
+	if snapshot == nil {
+		return fmt.Errorf("bamboo: no snapshot to restore")
+	}
+	if snapshot.InputMethod != e.inputMethod.Name {
+		return fmt.Errorf("bamboo: cannot restore a snapshot of %s with %s", snapshot.InputMethod, e.inputMethod.Name)
+	}
+	var composition = make([]*Transformation, len(snapshot.Transformations))
+	for i, trans := range snapshot.Transformations {
+		if trans.Target >= i {
+			return fmt.Errorf("bamboo: the target of transformation %d is not before it", i)
+		}
+		var rules = []Rule{trans.Rule}
+		e.inputMethod.letters.attach(rules)
+		composition[i] = &Transformation{
+			Rule:        rules[0],
+			IsUpperCase: trans.IsUpperCase,
+		}
+		if trans.Target >= 0 {
+			composition[i].Target = composition[trans.Target]
+		}
+	}
+	e.composition = composition
+	e.canvas.reset()
+	return nil
+}
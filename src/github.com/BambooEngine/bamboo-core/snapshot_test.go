@@ -0,0 +1,74 @@
+package bamboo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex")
+	var ng = NewEngine(im, EstdFlags)
+	ng.ProcessString("Tuoi", VietnameseMode)
+	data, err := json.Marshal(ng.Snapshot())
+	if err != nil {
+		t.Fatalf("Marshal the snapshot, got error %v", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("Unmarshal the snapshot, got error %v", err)
+	}
+	var restored = NewEngine(im, EstdFlags)
+	if err := restored.RestoreSnapshot(&snapshot); err != nil {
+		t.Fatalf("RestoreSnapshot, got error %v", err)
+	}
+	if restored.GetProcessedString(VietnameseMode) != "Tuoi" {
+		t.Errorf("RestoreSnapshot [Tuoi], got [%s] expected [Tuoi]", restored.GetProcessedString(VietnameseMode))
+	}
+	restored.ProcessString("wf", VietnameseMode)
+	if restored.GetProcessedString(VietnameseMode) != "Tười" {
+		t.Errorf("Process [Tuoi] + [wf] after RestoreSnapshot, got [%s] expected [Tười]", restored.GetProcessedString(VietnameseMode))
+	}
+	restored.ProcessString("n", VietnameseMode)
+	if restored.GetProcessedString(VietnameseMode) != "Tườin" {
+		t.Errorf("Process [Tuoiwfn] after RestoreSnapshot, got [%s] expected [Tườin]", restored.GetProcessedString(VietnameseMode))
+	}
+	restored.RemoveLastChar(true)
+	restored.RestoreLastWord(false)
+	if restored.GetProcessedString(VietnameseMode) != "Tuoiwf" {
+		t.Errorf("RestoreLastWord after RestoreSnapshot, got [%s] expected [Tuoiwf]", restored.GetProcessedString(VietnameseMode))
+	}
+}
+
+func TestSnapshotKeepsTargets(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex")
+	var ng = NewEngine(im, EstdFlags)
+	ng.ProcessString("nghieengs", VietnameseMode)
+	var snapshot = ng.Snapshot()
+	var restored = NewEngine(im, EstdFlags)
+	restored.RestoreSnapshot(snapshot)
+	restored.RemoveLastChar(true)
+	if restored.GetProcessedString(VietnameseMode) != "nghiến" {
+		t.Errorf("RemoveLastChar after RestoreSnapshot [nghiếng], got [%s] expected [nghiến]", restored.GetProcessedString(VietnameseMode))
+	}
+	ng.ProcessString("x", VietnameseMode)
+	if restored.GetProcessedString(VietnameseMode) != "nghiến" {
+		t.Errorf("The restored composition is shared with the original one, got [%s]", restored.GetProcessedString(VietnameseMode))
+	}
+}
+
+func TestRestoreSnapshotErrors(t *testing.T) {
+	var ng = NewEngine(ParseInputMethod(InputMethodDefinitions, "Telex"), EstdFlags)
+	ng.ProcessString("as", VietnameseMode)
+	var snapshot = ng.Snapshot()
+	var vni = NewEngine(ParseInputMethod(InputMethodDefinitions, "VNI"), EstdFlags)
+	if err := vni.RestoreSnapshot(snapshot); err == nil {
+		t.Errorf("RestoreSnapshot of Telex with VNI, expected an error")
+	}
+	snapshot.Transformations[1].Target = 1
+	if err := ng.RestoreSnapshot(snapshot); err == nil {
+		t.Errorf("RestoreSnapshot with a target after the transformation, expected an error")
+	}
+	if ng.GetProcessedString(VietnameseMode) != "á" {
+		t.Errorf("A failed RestoreSnapshot changed the composition, got [%s] expected [á]", ng.GetProcessedString(VietnameseMode))
+	}
+}
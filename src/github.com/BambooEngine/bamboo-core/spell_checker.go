@@ -0,0 +1,115 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This software is licensed under the MIT license. For more information,
+ * see <https://github.com/BambooEngine/bamboo-core/blob/master/LICENSE>.
+ */
+
+package bamboo
+
+import (
+	"strings"
+)
+
+// SpellChecker tells whether a word is a valid one, inputIsFullComplete is false
+// when the user is still typing the word.
+type SpellChecker interface {
+	IsValid(composition []*Transformation, inputIsFullComplete bool) bool
+}
+
+// SpellCheckerFunc allows the use of an ordinary function as a SpellChecker
+type SpellCheckerFunc func(composition []*Transformation, inputIsFullComplete bool) bool
+
+func (f SpellCheckerFunc) IsValid(composition []*Transformation, inputIsFullComplete bool) bool {
+	return f(composition, inputIsFullComplete)
+}
+
+// RulesSpellChecker checks the spelling with the Vietnamese syllable structure
+type RulesSpellChecker struct {
+	Profile *SpellingProfile
+}
+
+func NewRulesSpellChecker() SpellChecker {
+	return RulesSpellChecker{Profile: StrictSpellingProfile}
+}
+
+func NewRulesSpellCheckerWithProfile(profile *SpellingProfile) SpellChecker {
+	return RulesSpellChecker{Profile: profile}
+}
+
+func (c RulesSpellChecker) IsValid(composition []*Transformation, inputIsFullComplete bool) bool {
+	if c.Profile == nil {
+		return StrictSpellingProfile.isValid(composition, inputIsFullComplete)
+	}
+	return c.Profile.isValid(composition, inputIsFullComplete)
+}
+
+// DictionarySpellChecker accepts the words of a word list. While a word is being
+// typed, it is accepted if it is the beginning of a word in the list, regardless
+// of the tones and marks which have not been typed yet.
+type DictionarySpellChecker struct {
+	words    map[string]bool
+	prefixes map[string]bool
+}
+
+func NewDictionarySpellChecker(words []string) *DictionarySpellChecker {
+	var checker = &DictionarySpellChecker{
+		words:    map[string]bool{},
+		prefixes: map[string]bool{},
+	}
+	for _, word := range words {
+		checker.AddWord(word)
+	}
+	return checker
+}
+
+func (c *DictionarySpellChecker) AddWord(word string) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" {
+		return
+	}
+	c.words[word] = true
+	var runes = []rune(word)
+	for i := range runes {
+		c.prefixes[removeToneAndMark(runes[:i+1])] = true
+	}
+}
+
+func (c *DictionarySpellChecker) HasWord(word string) bool {
+	return c.words[strings.ToLower(word)]
+}
+
+func (c *DictionarySpellChecker) Len() int {
+	return len(c.words)
+}
+
+func (c *DictionarySpellChecker) IsValid(composition []*Transformation, inputIsFullComplete bool) bool {
+	if len(composition) == 0 {
+		return true
+	}
+	if inputIsFullComplete {
+		return c.words[Flatten(composition, VietnameseMode|LowerCase)]
+	}
+	return c.prefixes[Flatten(composition, VietnameseMode|LowerCase|ToneLess|MarkLess)]
+}
+
+func removeToneAndMark(runes []rune) string {
+	var result = make([]rune, len(runes))
+	for i, chr := range runes {
+		result[i] = AddMarkToChar(AddToneToChar(chr, 0), 0)
+	}
+	return string(result)
+}
+
+// ChainSpellCheckers combines the checkers, a word is valid if any of them accepts it
+func ChainSpellCheckers(checkers ...SpellChecker) SpellChecker {
+	return SpellCheckerFunc(func(composition []*Transformation, inputIsFullComplete bool) bool {
+		for _, checker := range checkers {
+			if checker.IsValid(composition, inputIsFullComplete) {
+				return true
+			}
+		}
+		return false
+	})
+}
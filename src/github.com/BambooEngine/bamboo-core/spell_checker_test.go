@@ -0,0 +1,129 @@
+package bamboo
+
+import (
+	"testing"
+)
+
+func TestDictionarySpellChecker(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex 2")
+	var dict = NewDictionarySpellChecker([]string{"việt", "Nam", "pơng"})
+	ng := NewEngineWithSpellChecker(im, EstdFlags, dict)
+	ng.ProcessString("vie", VietnameseMode)
+	if !ng.IsValid(false) {
+		t.Errorf("IsValid(false) [vie], got [false] expected [true]")
+	}
+	ng.ProcessString("etj", VietnameseMode)
+	if !ng.IsValid(true) {
+		t.Errorf("IsValid(true) [việt], got [false] expected [true]")
+	}
+	ng.Reset()
+	ng.ProcessString("vieets", VietnameseMode)
+	if ng.IsValid(true) {
+		t.Errorf("IsValid(true) [viết], got [true] expected [false]")
+	}
+	ng.Reset()
+	ng.ProcessString("nam", VietnameseMode)
+	if !ng.IsValid(true) {
+		t.Errorf("IsValid(true) [nam], got [false] expected [true]")
+	}
+	ng.Reset()
+	ng.ProcessString("nao", VietnameseMode)
+	if ng.IsValid(false) {
+		t.Errorf("IsValid(false) [nao], got [true] expected [false]")
+	}
+}
+
+func TestChainSpellCheckers(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex 2")
+	var dict = NewDictionarySpellChecker([]string{"bok"})
+	ng := NewEngineWithSpellChecker(im, EstdFlags, NewRulesSpellChecker())
+	ng.ProcessString("bok", VietnameseMode)
+	if ng.IsValid(true) {
+		t.Errorf("IsValid(true) [bok] with rules, got [true] expected [false]")
+	}
+	ng = NewEngineWithSpellChecker(im, EstdFlags, ChainSpellCheckers(NewRulesSpellChecker(), dict))
+	ng.ProcessString("bok", VietnameseMode)
+	if !ng.IsValid(true) {
+		t.Errorf("IsValid(true) [bok] with rules + dictionary, got [false] expected [true]")
+	}
+	ng.Reset()
+	ng.ProcessString("tooi", VietnameseMode)
+	if !ng.IsValid(true) {
+		t.Errorf("IsValid(true) [tôi] with rules + dictionary, got [false] expected [true]")
+	}
+	ng = NewEngineWithSpellChecker(im, EstdFlags, ChainSpellCheckers())
+	ng.ProcessString("tooi", VietnameseMode)
+	if ng.IsValid(true) {
+		t.Errorf("IsValid(true) [tôi] with no checker, got [true] expected [false]")
+	}
+}
+
+func TestSpellingProfiles(t *testing.T) {
+	var im = ParseInputMethod(InputMethodDefinitions, "Telex 2")
+	var tests = []struct {
+		keys     string
+		expected string
+		valid    []bool // strict, permissive, minority
+	}{
+		{"tooi", "tôi", []bool{true, true, true}},
+		{"fim", "fim", []bool{false, true, true}},
+		{"dzaauj", "dzậu", []bool{false, true, true}},
+		{"glei", "glei", []bool{false, true, true}},
+		{"kroong", "krông", []bool{false, true, true}},
+		{"hmoong", "hmông", []bool{false, false, true}},
+		{"bok", "bok", []bool{false, false, true}},
+	}
+	for _, test := range tests {
+		for i, name := range GetSpellingProfileNames() {
+			ng := NewEngine(im, EstdFlags)
+			ng.SetSpellingProfile(GetSpellingProfile(name))
+			ng.ProcessString(test.keys, VietnameseMode)
+			if ng.GetProcessedString(VietnameseMode) != test.expected {
+				t.Errorf("Process [%s] with %s profile, got [%s] expected [%s]", test.keys, name, ng.GetProcessedString(VietnameseMode), test.expected)
+			}
+			if ng.IsValid(true) != test.valid[i] {
+				t.Errorf("IsValid(true) [%s] with %s profile, got [%v] expected [%v]", test.expected, name, ng.IsValid(true), test.valid[i])
+			}
+			var checker = NewRulesSpellCheckerWithProfile(GetSpellingProfile(name))
+			ng = NewEngineWithSpellChecker(im, EstdFlags, checker)
+			ng.ProcessString(test.keys, VietnameseMode)
+			if ng.IsValid(true) != test.valid[i] {
+				t.Errorf("RulesSpellChecker [%s] with %s profile, got [%v] expected [%v]", test.expected, name, ng.IsValid(true), test.valid[i])
+			}
+		}
+	}
+}
+
+func TestExtendSpellingProfile(t *testing.T) {
+	var profile = StrictSpellingProfile.Extend("test", SpellingProfile{
+		VowelSeqs: []string{"ei"},
+		CVMatrix:  [][]int{0: {8}},
+		VCMatrix:  [][]int{8: {0}},
+	})
+	if len(profile.CVMatrix) != len(profile.FirstConsonantSeqs) || len(profile.VCMatrix) != len(profile.VowelSeqs) {
+		t.Errorf("Extend, got %d/%d cv rows and %d/%d vc rows", len(profile.CVMatrix), len(profile.FirstConsonantSeqs), len(profile.VCMatrix), len(profile.VowelSeqs))
+	}
+	if !profile.isValidCVC("b", "ei", "nh", true) || StrictSpellingProfile.isValidCVC("b", "ei", "nh", true) {
+		t.Errorf("Extend, expected beinh to be valid with the extended profile only")
+	}
+	if len(cvMatrix[0]) != 4 {
+		t.Errorf("Extend, the strict profile has been modified: %v", cvMatrix[0])
+	}
+}
+
+func TestIsValidWord(t *testing.T) {
+	for word, expected := range map[string]bool{
+		"trường": true,
+		"Được":   true,
+		"trnog":  false,
+		"tròc":   false,
+		"fim":    false,
+	} {
+		if StrictSpellingProfile.IsValidWord(word) != expected {
+			t.Errorf("IsValidWord [%s], got [%v] expected [%v]", word, !expected, expected)
+		}
+	}
+	if !PermissiveSpellingProfile.IsValidWord("fim") {
+		t.Errorf("IsValidWord [fim] with the permissive profile, got [false] expected [true]")
+	}
+}
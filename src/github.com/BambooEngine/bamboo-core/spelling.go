@@ -8,6 +8,8 @@
 
 package bamboo
 
+import "unicode"
+
 var firstConsonantSeqs = []string{
 	"b d đ g gh m n nh p ph r s t tr v z",
 	"c h k kh qu th",
@@ -54,6 +56,139 @@ var vcMatrix = [][]int{
 	{4},
 }
 
+// SpellingProfile is a set of syllable tables used by the rules-based spell
+// checker. The rows of cvMatrix are indexed by the rows of firstConsonantSeqs and
+// contain the allowed rows of vowelSeqs, the same goes for vcMatrix with the rows
+// of vowelSeqs and lastConsonantSeqs.
+type SpellingProfile struct {
+	Name               string
+	FirstConsonantSeqs []string
+	VowelSeqs          []string
+	LastConsonantSeqs  []string
+	CVMatrix           [][]int
+	VCMatrix           [][]int
+}
+
+// Extend returns a new profile with the rows of ext appended to the tables of p.
+// The rows of ext.CVMatrix (and ext.VCMatrix) are merged into the rows with the
+// same index in p, so an extension may either allow more vowels for an existing
+// consonant row, or describe a new one.
+func (p *SpellingProfile) Extend(name string, ext SpellingProfile) *SpellingProfile {
+	var mergeMatrix = func(base, ext [][]int) [][]int {
+		var n = len(base)
+		if len(ext) > n {
+			n = len(ext)
+		}
+		var matrix = make([][]int, n)
+		for i := range matrix {
+			if i < len(base) {
+				matrix[i] = append(matrix[i], base[i]...)
+			}
+			if i < len(ext) {
+				matrix[i] = append(matrix[i], ext[i]...)
+			}
+		}
+		return matrix
+	}
+	var concat = func(base, ext []string) []string {
+		return append(append([]string(nil), base...), ext...)
+	}
+	return &SpellingProfile{
+		Name:               name,
+		FirstConsonantSeqs: concat(p.FirstConsonantSeqs, ext.FirstConsonantSeqs),
+		VowelSeqs:          concat(p.VowelSeqs, ext.VowelSeqs),
+		LastConsonantSeqs:  concat(p.LastConsonantSeqs, ext.LastConsonantSeqs),
+		CVMatrix:           mergeMatrix(p.CVMatrix, ext.CVMatrix),
+		VCMatrix:           mergeMatrix(p.VCMatrix, ext.VCMatrix),
+	}
+}
+
+// StrictSpellingProfile only accepts the syllables of modern standard Vietnamese
+var StrictSpellingProfile = &SpellingProfile{
+	Name:               "strict",
+	FirstConsonantSeqs: firstConsonantSeqs,
+	VowelSeqs:          vowelSeqs,
+	LastConsonantSeqs:  lastConsonantSeqs,
+	CVMatrix:           cvMatrix,
+	VCMatrix:           vcMatrix,
+}
+
+// PermissiveSpellingProfile also accepts the loanwords and the southern dialect
+// spellings, e.g. fim, wifi, blốc, dzậy, gas
+var PermissiveSpellingProfile = StrictSpellingProfile.Extend("permissive", SpellingProfile{
+	FirstConsonantSeqs: []string{"bl br cl cr dr dz f fl fr gl gr j kl kr pl pr sl sp st w"},
+	VowelSeqs:          []string{"ei eu ou"},
+	LastConsonantSeqs:  []string{"l s"},
+	CVMatrix: [][]int{
+		0: {8},
+		1: {8},
+		2: {8},
+		5: {0, 1, 2, 3, 4, 5, 8},
+	},
+	VCMatrix: [][]int{
+		0: {5},
+		1: {5},
+		2: {5},
+		8: nil,
+	},
+})
+
+// MinoritySpellingProfile also accepts the syllables of the ethnic-minority
+// place names and personal names, e.g. Krông, H'Mông, Jrai, Bok
+var MinoritySpellingProfile = PermissiveSpellingProfile.Extend("minority", SpellingProfile{
+	FirstConsonantSeqs: []string{"bh hl hm hn hr jr kn kp kt ml mn mr"},
+	LastConsonantSeqs:  []string{"h k r"},
+	CVMatrix: [][]int{
+		6: {0, 1, 2, 3, 4, 5, 8},
+	},
+	VCMatrix: [][]int{
+		0: {6},
+		1: {6},
+		2: {6},
+	},
+})
+
+var spellingProfiles = []*SpellingProfile{
+	StrictSpellingProfile,
+	PermissiveSpellingProfile,
+	MinoritySpellingProfile,
+}
+
+func GetSpellingProfile(name string) *SpellingProfile {
+	for _, profile := range spellingProfiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+func GetSpellingProfileNames() []string {
+	var names []string
+	for _, profile := range spellingProfiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// IsValidWord tells whether a word which is already composed, e.g. "trường",
+// follows the profile. The tone must also fit the last consonant.
+func (p *SpellingProfile) IsValidWord(word string) bool {
+	var composition []*Transformation
+	var tone = ToneNone
+	for _, chr := range word {
+		var lowerChr = unicode.ToLower(chr)
+		if lowerChr > unicode.MaxASCII && !IsVowel(lowerChr) && lowerChr != 'đ' {
+			return false
+		}
+		if t := FindToneFromChar(lowerChr); t != ToneNone {
+			tone = t
+		}
+		composition = append(composition, newAppendingTrans(lowerChr, unicode.IsUpper(chr)))
+	}
+	return hasValidTone(composition, tone) && p.isValid(composition, true)
+}
+
 func lookup(seq []string, input string, inputIsFull, inputIsComplete bool) []int {
 	var ret []int
 	var inputLen = len([]rune(input))
@@ -85,22 +220,22 @@ func lookup(seq []string, input string, inputIsFull, inputIsComplete bool) []int
 	return ret
 }
 
-func isValidCVC(fc, vo, lc string, inputIsFullComplete bool) bool {
+func (p *SpellingProfile) isValidCVC(fc, vo, lc string, inputIsFullComplete bool) bool {
 	var ret bool
 	var fcIndexes, voIndexes, lcIndexes []int
 	// log.Printf("fc=%s vo=%s lc=%s ret=%v", fc, vo, lc, ret)
 	if fc != "" {
-		if fcIndexes = lookup(firstConsonantSeqs, fc, inputIsFullComplete || vo != "", true); fcIndexes == nil {
+		if fcIndexes = lookup(p.FirstConsonantSeqs, fc, inputIsFullComplete || vo != "", true); fcIndexes == nil {
 			return false
 		}
 	}
 	if vo != "" {
-		if voIndexes = lookup(vowelSeqs, vo, inputIsFullComplete || lc != "", inputIsFullComplete); voIndexes == nil {
+		if voIndexes = lookup(p.VowelSeqs, vo, inputIsFullComplete || lc != "", inputIsFullComplete); voIndexes == nil {
 			return false
 		}
 	}
 	if lc != "" {
-		if lcIndexes = lookup(lastConsonantSeqs, lc, inputIsFullComplete, true); lcIndexes == nil {
+		if lcIndexes = lookup(p.LastConsonantSeqs, lc, inputIsFullComplete, true); lcIndexes == nil {
 			return false
 		}
 	}
@@ -110,13 +245,13 @@ func isValidCVC(fc, vo, lc string, inputIsFullComplete bool) bool {
 	}
 	if fcIndexes != nil {
 		// first consonant + vowel
-		if ret = isValidCV(fcIndexes, voIndexes); !ret || lcIndexes == nil {
+		if ret = p.isValidCV(fcIndexes, voIndexes); !ret || lcIndexes == nil {
 			return ret
 		}
 	}
 	if lcIndexes != nil {
 		// vowel + last consonant
-		ret = isValidVC(voIndexes, lcIndexes)
+		ret = p.isValidVC(voIndexes, lcIndexes)
 	} else {
 		// vowel only
 		ret = true
@@ -124,9 +259,9 @@ func isValidCVC(fc, vo, lc string, inputIsFullComplete bool) bool {
 	return ret
 }
 
-func isValidCV(fcIndexes, voIndexes []int) bool {
+func (p *SpellingProfile) isValidCV(fcIndexes, voIndexes []int) bool {
 	for _, fc := range fcIndexes {
-		for _, c := range cvMatrix[fc] {
+		for _, c := range p.CVMatrix[fc] {
 			for _, vo := range voIndexes {
 				if c == vo {
 					return true
@@ -137,9 +272,9 @@ func isValidCV(fcIndexes, voIndexes []int) bool {
 	return false
 }
 
-func isValidVC(voIndexes, lcIndexes []int) bool {
+func (p *SpellingProfile) isValidVC(voIndexes, lcIndexes []int) bool {
 	for _, vo := range voIndexes {
-		for _, c := range vcMatrix[vo] {
+		for _, c := range p.VCMatrix[vo] {
 			for _, lc := range lcIndexes {
 				if c == lc {
 					return true
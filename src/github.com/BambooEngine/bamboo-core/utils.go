@@ -57,6 +57,8 @@ func FindVowelPosition(chr rune) int {
 	return -1
 }
 
+// marksMaps holds the standard mark families, the letters declared by an
+// input method are added to a copy of it (see marks.go)
 var marksMaps = map[rune]string{
 	'a': "aâă__",
 	'â': "aâă__",
@@ -72,9 +74,9 @@ var marksMaps = map[rune]string{
 	'đ': "d___đ",
 }
 
-func getMarkFamily(chr rune) []rune {
+func getMarkFamily(families map[rune]string, chr rune) []rune {
 	var result []rune
-	if s, found := marksMaps[chr]; found {
+	if s, found := families[chr]; found {
 		for _, c := range s {
 			if c != '_' {
 				result = append(result, c)
@@ -104,9 +106,13 @@ func FindMarkFromChar(chr rune) (Mark, bool) {
 }
 
 func AddMarkToTonelessChar(chr rune, mark uint8) rune {
-	if str, found := marksMaps[chr]; found {
+	return addMarkToTonelessChar(marksMaps, chr, mark)
+}
+
+func addMarkToTonelessChar(families map[rune]string, chr rune, mark uint8) rune {
+	if str, found := families[chr]; found {
 		marks := []rune(str)
-		if marks[mark] != '_' {
+		if int(mark) < len(marks) && marks[mark] != '_' {
 			return marks[mark]
 		}
 	}
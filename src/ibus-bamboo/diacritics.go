@@ -0,0 +1,248 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+const (
+	DiacriticMaxCandidates = 9
+	diacriticBeamWidth     = 20
+	// the weight of the bigram probability, the rest goes to the unigram one
+	diacriticBigramWeight = 0.8
+)
+
+// diacriticModel holds the *DiacriticModel in use, it is replaced when the
+// dictionaries are reloaded
+var diacriticModel atomic.Value
+
+func getDiacriticModel() *DiacriticModel {
+	model, _ := diacriticModel.Load().(*DiacriticModel)
+	return model
+}
+
+// DiacriticModel restores the accents of a text typed without them, e.g.
+// "toi di hoc" -> "tôi đi học". Every syllable of the dictionary whose
+// accent-less form is a word of the text is a candidate, the sentences are
+// ranked by a bigram model: P(w1..wn) = P(w1) * P(w2|w1) * ... * P(wn|wn-1),
+// the bigram probabilities being interpolated with the unigram ones so that
+// the pairs which are not in the data file still get a score.
+type DiacriticModel struct {
+	syllables  map[string][]string
+	unigrams   map[string]float64
+	bigrams    map[string]float64
+	prevTotals map[string]float64
+	total      float64
+}
+
+func NewDiacriticModel() *DiacriticModel {
+	return &DiacriticModel{
+		syllables:  map[string][]string{},
+		unigrams:   map[string]float64{},
+		bigrams:    map[string]float64{},
+		prevTotals: map[string]float64{},
+	}
+}
+
+// loadDiacriticModel reads the n-gram counts, then the syllables of the
+// dictionaries
+func loadDiacriticModel(ngramFile string, dictFiles ...string) (*DiacriticModel, error) {
+	var m = NewDiacriticModel()
+	f, err := os.Open(ngramFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m.readNgrams(f)
+	words, err := loadDictionary(dictFiles...)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range words {
+		m.addSyllable(word)
+	}
+	return m, nil
+}
+
+// readNgrams reads the lines "word<TAB>count" and "word1 word2<TAB>count",
+// the lines starting with "#" are comments
+func (m *DiacriticModel) readNgrams(r io.Reader) {
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var parts = strings.Split(line, "\t")
+		if len(parts) != 2 {
+			continue
+		}
+		count, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || count <= 0 {
+			continue
+		}
+		var words = strings.Fields(strings.ToLower(parts[0]))
+		switch len(words) {
+		case 1:
+			m.unigrams[words[0]] += count
+			m.total += count
+			m.addSyllable(words[0])
+		case 2:
+			m.bigrams[words[0]+" "+words[1]] += count
+			m.prevTotals[words[0]] += count
+			m.addSyllable(words[0])
+			m.addSyllable(words[1])
+		}
+	}
+}
+
+func (m *DiacriticModel) addSyllable(word string) {
+	word = strings.ToLower(word)
+	if strings.ContainsRune(word, ' ') {
+		return
+	}
+	var key = removeVnAccents(word)
+	for _, syllable := range m.syllables[key] {
+		if syllable == word {
+			return
+		}
+	}
+	m.syllables[key] = append(m.syllables[key], word)
+}
+
+func (m *DiacriticModel) logProb(prev, word string) float64 {
+	var vocabulary = float64(len(m.unigrams) + 1)
+	var p = (m.unigrams[word] + 1) / (m.total + vocabulary)
+	if prevTotal := m.prevTotals[prev]; prevTotal > 0 {
+		p = diacriticBigramWeight*m.bigrams[prev+" "+word]/prevTotal + (1-diacriticBigramWeight)*p
+	}
+	return math.Log(p)
+}
+
+type diacriticPath struct {
+	parts []string
+	prev  string
+	score float64
+}
+
+// Restore returns up to n renderings of the text, the most likely first. The
+// words which already have accents and the words which are not Vietnamese are
+// kept as they are.
+func (m *DiacriticModel) Restore(text string, n int) []string {
+	var paths = []diacriticPath{{}}
+	for _, token := range splitDiacriticTokens(text) {
+		var candidates []string
+		var lowerToken = strings.ToLower(token)
+		if isLetterToken(token) && removeVnAccents(lowerToken) == lowerToken {
+			candidates = m.syllables[lowerToken]
+		}
+		var newPaths []diacriticPath
+		for _, path := range paths {
+			if len(candidates) == 0 {
+				var prev = path.prev
+				if isLetterToken(token) {
+					prev = lowerToken
+				} else if !isSpaceToken(token) {
+					// a punctuation mark ends the context
+					prev = ""
+				}
+				newPaths = append(newPaths, diacriticPath{appendPart(path.parts, token), prev, path.score})
+				continue
+			}
+			for _, candidate := range candidates {
+				newPaths = append(newPaths, diacriticPath{
+					parts: appendPart(path.parts, applyWordCase(token, candidate)),
+					prev:  candidate,
+					score: path.score + m.logProb(path.prev, candidate),
+				})
+			}
+		}
+		sort.SliceStable(newPaths, func(i, j int) bool {
+			return newPaths[i].score > newPaths[j].score
+		})
+		if len(newPaths) > diacriticBeamWidth {
+			newPaths = newPaths[:diacriticBeamWidth]
+		}
+		paths = newPaths
+	}
+	var results []string
+	var seen = map[string]bool{}
+	for _, path := range paths {
+		var result = strings.Join(path.parts, "")
+		if !seen[result] {
+			seen[result] = true
+			results = append(results, result)
+		}
+		if len(results) >= n {
+			break
+		}
+	}
+	return results
+}
+
+func appendPart(parts []string, part string) []string {
+	return append(append(make([]string, 0, len(parts)+1), parts...), part)
+}
+
+// splitDiacriticTokens cuts a text into runs of letters and runs of the other characters
+func splitDiacriticTokens(text string) []string {
+	var tokens []string
+	var runes = []rune(text)
+	for i := 0; i < len(runes); {
+		var j = i + 1
+		for j < len(runes) && unicode.IsLetter(runes[j]) == unicode.IsLetter(runes[i]) {
+			j++
+		}
+		tokens = append(tokens, string(runes[i:j]))
+		i = j
+	}
+	return tokens
+}
+
+func isLetterToken(token string) bool {
+	return token != "" && unicode.IsLetter([]rune(token)[0])
+}
+
+func isSpaceToken(token string) bool {
+	return strings.TrimSpace(token) == ""
+}
+
+// applyWordCase gives the syllable the case of the typed word
+func applyWordCase(token, syllable string) string {
+	var tokenRunes = []rune(token)
+	if len(tokenRunes) > 1 && strings.ToUpper(token) == token {
+		return strings.ToUpper(syllable)
+	}
+	if unicode.IsUpper(tokenRunes[0]) {
+		var runes = []rune(syllable)
+		runes[0] = unicode.ToUpper(runes[0])
+		return string(runes)
+	}
+	return syllable
+}
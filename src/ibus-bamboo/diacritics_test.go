@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRestoreDiacritics(t *testing.T) {
+	var m, err = loadDiacriticModel("../../"+DictVietnameseNgram, "../../"+DictVietnameseCm)
+	if err != nil {
+		t.Fatalf("Loading diacritic model, got error %v", err)
+	}
+	for text, expected := range map[string]string{
+		"toi di hoc":                 "tôi đi học",
+		"Hom nay toi di lam":         "Hôm nay tôi đi làm",
+		"VIET NAM":                   "VIỆT NAM",
+		"xin chao, toi la sinh vien": "xin chào, tôi là sinh viên",
+		"ok ban nhe":                 "ok bạn nhé",
+		"tôi di hoc":                 "tôi đi học",
+	} {
+		var sentences = m.Restore(text, DiacriticMaxCandidates)
+		if len(sentences) == 0 || sentences[0] != expected {
+			t.Errorf("Restore [%s], got %v expected [%s] first", text, sentences, expected)
+		}
+		if len(sentences) > DiacriticMaxCandidates {
+			t.Errorf("Restore [%s], got %d candidates expected at most %d", text, len(sentences), DiacriticMaxCandidates)
+		}
+	}
+	var sentences = m.Restore("toi", DiacriticMaxCandidates)
+	if !inStringList(sentences, "tối") || !inStringList(sentences, "tới") {
+		t.Errorf("Restore [toi], expected the alternatives tối and tới, got %v", sentences)
+	}
+	if sentences = m.Restore("123 ...", 3); len(sentences) != 1 || sentences[0] != "123 ..." {
+		t.Errorf("Restore [123 ...], got %v expected [123 ...]", sentences)
+	}
+}
+
+func TestReadNgrams(t *testing.T) {
+	var m = NewDiacriticModel()
+	m.readNgrams(strings.NewReader("# comment\nmá\t1\nmà\t3\nmá ơi\t5\nbad line\n"))
+	if m.total != 4 || m.bigrams["má ơi"] != 5 || m.prevTotals["má"] != 5 {
+		t.Errorf("readNgrams, got total %v bigrams %v", m.total, m.bigrams)
+	}
+	if sentences := m.Restore("ma", 2); len(sentences) != 2 || sentences[0] != "mà" {
+		t.Errorf("Restore [ma], got %v expected [mà má]", sentences)
+	}
+	if sentences := m.Restore("ma oi", 1); len(sentences) != 1 || sentences[0] != "má ơi" {
+		t.Errorf("Restore [ma oi], got %v expected [má ơi]", sentences)
+	}
+}
@@ -21,46 +21,210 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+const (
+	emojiRecentFile = "%s/ibus-%s.emoji.json"
+
+	EmojiMaxRecents = 36
+	EmojiSkinTones  = 5
+)
+
+// emojiCategories are the categories of emojione.json in the order of
+// browsing, the regional indicators and the skin tone modifiers are left out
+var emojiCategories = []string{"people", "nature", "food", "activity", "travel", "objects", "symbols", "flags"}
+
+var emojiRecents = NewEmojiRecents()
+
 type EmojiOne struct {
-	Name      string
-	Shortname string
-	Keywords  []string
-	ASCII     []string
+	Name                string
+	Category            string
+	Order               int
+	Shortname           string
+	ShortnameAlternates []string `json:"shortname_alternates"`
+	Keywords            []string
+	ASCII               []string
+	Diversity           *string
+	Diversities         []string
+	CodePoints          struct {
+		Output string
+	} `json:"code_points"`
+}
+
+// Emoji is an emoji of emojione.json. The skin tone variants have a base
+// emoji, they are not indexed but replace their base emoji when the user
+// has chosen a skin tone.
+type Emoji struct {
+	Glyph       string
+	Name        string
+	Shortname   string
+	Category    string
+	Order       int
+	Base        string
+	Diversities []string
+}
+
+func decodeEmojiCodePoints(codePoints string) string {
+	var glyph string
+	for _, codePoint := range strings.Split(codePoints, "-") {
+		if code, err := strconv.ParseInt(codePoint, 16, 32); err == nil {
+			glyph += string(rune(code))
+		}
+	}
+	return glyph
 }
 
-func loadEmojiOne(dataFile string) (*TrieNode, error) {
-	var trie = NewTrie()
+// loadEmojiOne indexes the emoji of emojione.json, with the code points of
+// their fully qualified forms
+func loadEmojiOne(dataFile string) (*EmojiIndex, error) {
 	var c = map[string]EmojiOne{}
 	var data, err = ioutil.ReadFile(dataFile)
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(data, &c)
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	var glyphs = map[string]string{}
+	for k, v := range c {
+		glyphs[k] = decodeEmojiCodePoints(k)
+		if v.CodePoints.Output != "" {
+			glyphs[k] = decodeEmojiCodePoints(v.CodePoints.Output)
+		}
+	}
+	var idx = NewEmojiIndex()
 	for k, v := range c {
-		var codePointStr string
-		for _, codePoint := range strings.Split(k, "-") {
-			if code, err := strconv.ParseInt(codePoint, 16, 32); err == nil {
-				codePointStr += string(rune(code))
+		var emoji = &Emoji{
+			Glyph:     glyphs[k],
+			Name:      v.Name,
+			Shortname: v.Shortname,
+			Category:  v.Category,
+			Order:     v.Order,
+		}
+		for _, diversity := range v.Diversities {
+			emoji.Diversities = append(emoji.Diversities, glyphs[diversity])
+		}
+		if v.Diversity != nil {
+			idx.add(k, emoji, nil, nil)
+			continue
+		}
+		var shortnames []string
+		for _, shortname := range append([]string{v.Shortname}, v.ShortnameAlternates...) {
+			if shortname != "" {
+				shortnames = append(shortnames, shortname)
 			}
 		}
-		for _, ascii := range v.ASCII {
-			InsertTrie(trie, ascii, codePointStr)
+		var terms = append([]string{v.Name}, v.ASCII...)
+		idx.add(k, emoji, shortnames, append(terms, v.Keywords...))
+	}
+	for _, emoji := range idx.emojis {
+		for _, variant := range emoji.Diversities {
+			if idx.emojis[variant] != nil {
+				idx.emojis[variant].Base = emoji.Glyph
+			}
 		}
-		for _, keyword := range v.Keywords {
-			InsertTrie(trie, keyword, codePointStr)
+	}
+	idx.sort()
+	return idx, nil
+}
+
+// EmojiRecents keeps the emoji the user picked lately, the latest first
+type EmojiRecents struct {
+	sync.RWMutex
+	fileName string
+	glyphs   []string
+}
+
+func NewEmojiRecents() *EmojiRecents {
+	return &EmojiRecents{}
+}
+
+func getEmojiRecentFile(engineName string) string {
+	return fmt.Sprintf(emojiRecentFile, getConfigDir(engineName), engineName)
+}
+
+func (r *EmojiRecents) Load(engineName string) {
+	r.Lock()
+	defer r.Unlock()
+	r.fileName = getEmojiRecentFile(engineName)
+	data, err := ioutil.ReadFile(r.fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
 		}
+		return
+	}
+	var glyphs []string
+	if err = json.Unmarshal(data, &glyphs); err != nil {
+		log.Println(err)
+		return
 	}
-	return trie, nil
+	r.glyphs = glyphs
+}
+
+func (r *EmojiRecents) save() {
+	if r.fileName == "" {
+		return
+	}
+	data, err := json.Marshal(r.glyphs)
+	if err != nil {
+		return
+	}
+	var tmpFile = r.fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		log.Println(err)
+		return
+	}
+	if err = os.Rename(tmpFile, r.fileName); err != nil {
+		log.Println(err)
+	}
+}
+
+// Add moves the glyph to the front of the recent picks
+func (r *EmojiRecents) Add(glyph string) {
+	r.Lock()
+	defer r.Unlock()
+	var glyphs = []string{glyph}
+	for _, g := range r.glyphs {
+		if g != glyph && len(glyphs) < EmojiMaxRecents {
+			glyphs = append(glyphs, g)
+		}
+	}
+	r.glyphs = glyphs
+	r.save()
+}
+
+// Rank returns the position of the glyph in the recent picks, or -1
+func (r *EmojiRecents) Rank(glyph string) int {
+	r.RLock()
+	defer r.RUnlock()
+	for i, g := range r.glyphs {
+		if g == glyph {
+			return i
+		}
+	}
+	return -1
 }
 
 type EmojiEngine struct {
 	keys []rune
+	// word is the word in progress as it is composed by the preeditor, e.g.
+	// "trái", typedWord is the same word as it was typed, e.g. "trais"
+	word      string
+	typedWord string
+	// Category filters the candidates, all categories if empty
+	Category string
+	// SkinTone is the skin tone of the candidates which have variants,
+	// from 1 to EmojiSkinTones, the yellow default if 0
+	SkinTone int
 }
 
 func NewEmojiEngine() *EmojiEngine {
@@ -69,43 +233,128 @@ func NewEmojiEngine() *EmojiEngine {
 }
 
 func (be *EmojiEngine) MatchString(s string) bool {
-	var lookup = FindPrefix(emojiTrie, s)
-	return lookup != nil
+	return len(getEmojiIndex().Search(s)) > 0
+}
+
+// MatchShortname returns the emoji whose shortname, with the colons, is s
+func (be *EmojiEngine) MatchShortname(s string) (string, bool) {
+	if glyph, ok := getEmojiIndex().GetShortname(s); ok {
+		return be.withSkinTone(glyph), true
+	}
+	return "", false
+}
+
+func (be *EmojiEngine) withSkinTone(glyph string) string {
+	if emoji := getEmojiIndex().Get(glyph); emoji != nil && be.SkinTone > 0 && be.SkinTone <= len(emoji.Diversities) {
+		return emoji.Diversities[be.SkinTone-1]
+	}
+	return glyph
 }
 
+// Filter returns the emoji matching s, the recent picks first, then in the
+// order of the index
 func (be *EmojiEngine) Filter(s string) []string {
 	var codePoints []string
-	var keys []string
-	var lookup = FindPrefix(emojiTrie, s)
-	for key := range lookup {
-		keys = append(keys, key)
-	}
-	var names = byString(keys)
-	sort.Sort(names)
-	for _, name := range names {
-		var cps = byString(strings.Split(lookup[name], ":"))
-		sort.Sort(cps)
-		for _, cp := range cps {
-			codePoints = append(codePoints, cp)
+	for _, emoji := range getEmojiIndex().Search(s) {
+		if be.Category == "" || emoji.Category == be.Category {
+			codePoints = append(codePoints, be.withSkinTone(emoji.Glyph))
 		}
 	}
+	var recents = make(map[string]int, len(codePoints))
+	for _, codePoint := range codePoints {
+		recents[codePoint] = emojiRecents.Rank(codePoint)
+	}
+	sort.SliceStable(codePoints, func(i, j int) bool {
+		var ri, rj = recents[codePoints[i]], recents[codePoints[j]]
+		if ri < 0 || rj < 0 {
+			return ri > rj
+		}
+		return ri < rj
+	})
 	return codePoints
 }
 
+// NextCategory moves the category filter forward or backward, through all
+// the categories in between the last and the first one
+func (be *EmojiEngine) NextCategory(backward bool) {
+	var categories = append([]string{""}, emojiCategories...)
+	var idx = 0
+	for i, category := range categories {
+		if category == be.Category {
+			idx = i
+		}
+	}
+	if backward {
+		idx += len(categories) - 1
+	} else {
+		idx++
+	}
+	be.Category = categories[idx%len(categories)]
+}
+
+// getEmojiName returns the name of an emoji, or its shortname if it has no name
+func getEmojiName(glyph string) string {
+	if emoji := getEmojiIndex().Get(glyph); emoji != nil {
+		if emoji.Name != "" {
+			return emoji.Name
+		}
+		return emoji.Shortname
+	}
+	return ""
+}
+
+// isShortcodePrefix reports whether s is the beginning of a :shortname:, a
+// colon and at least one of the characters of the shortnames
+func isShortcodePrefix(s string) bool {
+	if len(s) < 2 || s[0] != ':' {
+		return false
+	}
+	var name = strings.TrimSuffix(s[1:], ":")
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_+-", c) {
+			return false
+		}
+	}
+	return true
+}
+
 func (be *EmojiEngine) ProcessKey(key rune) {
 	be.keys = append(be.keys, key)
 }
 
+// SetWord replaces the word in progress
+func (be *EmojiEngine) SetWord(word, typedWord string) {
+	be.word = word
+	be.typedWord = typedWord
+}
+
+// CommitWord moves the word in progress to the keys
+func (be *EmojiEngine) CommitWord() {
+	be.keys = append(be.keys, []rune(be.word)...)
+	be.SetWord("", "")
+}
+
 func (be *EmojiEngine) GetRawString() string {
-	return string(be.keys)
+	return string(be.keys) + be.word
+}
+
+// GetTypedString returns the keys as they were typed, a :shortname: is
+// matched with them
+func (be *EmojiEngine) GetTypedString() string {
+	return string(be.keys) + be.typedWord
 }
 
 func (be *EmojiEngine) Reset() {
 	be.keys = nil
+	be.SetWord("", "")
+	be.Category = ""
 }
 
 func (be *EmojiEngine) Query() []string {
-	return be.Filter(string(be.keys))
+	return be.Filter(be.GetRawString())
 }
 
 func (be *EmojiEngine) RemoveLastKey() {
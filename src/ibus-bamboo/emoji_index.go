@@ -0,0 +1,257 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the kinds of match of a query in a term, the better first
+const (
+	emojiMatchShortname = iota
+	emojiMatchExact
+	emojiMatchPrefix
+	emojiMatchWord
+	emojiMatchSubstring
+	emojiMatchFuzzy
+	emojiMatchNone
+)
+
+// EmojiIndex looks up the emoji by their shortnames, smileys, names and
+// keywords, in English and in Vietnamese. The terms are kept in lower case
+// without the Vietnamese accents, a query matches a term if it is a part of
+// it or if its letters are in it in the same order.
+type EmojiIndex struct {
+	sync.Mutex
+	emojis     map[string]*Emoji
+	shortnames map[string]string
+	entries    []*emojiEntry
+	// the entries by their code points in emojione.json
+	codePoints map[string]*emojiEntry
+	// the matches of the last query, a query which extends it only looks in them
+	lastQuery   string
+	lastMatches []emojiMatch
+}
+
+type emojiEntry struct {
+	emoji      *Emoji
+	shortnames []string
+	terms      []string
+}
+
+type emojiMatch struct {
+	entry *emojiEntry
+	kind  int
+}
+
+func NewEmojiIndex() *EmojiIndex {
+	return &EmojiIndex{emojis: map[string]*Emoji{}, shortnames: map[string]string{}, codePoints: map[string]*emojiEntry{}}
+}
+
+func foldEmojiTerm(s string) string {
+	return removeVnAccents(strings.Join(strings.Fields(strings.ToLower(s)), " "))
+}
+
+// add adds an emoji and the terms of its base emoji, the skin tone variants
+// have no term
+func (idx *EmojiIndex) add(codePoints string, emoji *Emoji, shortnames, terms []string) {
+	idx.emojis[emoji.Glyph] = emoji
+	if len(shortnames) == 0 && len(terms) == 0 {
+		return
+	}
+	var entry = &emojiEntry{emoji: emoji}
+	for _, shortname := range shortnames {
+		idx.shortnames[shortname] = emoji.Glyph
+		entry.shortnames = append(entry.shortnames, strings.Trim(shortname, ":"))
+		terms = append(terms, strings.Trim(shortname, ":"))
+	}
+	var seen = map[string]bool{}
+	for _, term := range terms {
+		if term = foldEmojiTerm(term); term != "" && !seen[term] {
+			seen[term] = true
+			entry.terms = append(entry.terms, term)
+		}
+	}
+	idx.entries = append(idx.entries, entry)
+	idx.codePoints[codePoints] = entry
+}
+
+// sort puts the entries in the order of emojione, which is the order of the
+// results of the same kind of match
+func (idx *EmojiIndex) sort() {
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		return idx.entries[i].emoji.Order < idx.entries[j].emoji.Order
+	})
+	idx.lastQuery, idx.lastMatches = "", nil
+}
+
+// LoadKeywords adds the keywords of a file of "<code points>;<keyword>, ..."
+// lines, the code points are the keys of emojione.json
+func (idx *EmojiIndex) LoadKeywords(dataFile string) error {
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	idx.Lock()
+	defer idx.Unlock()
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var fields = strings.SplitN(line, ";", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		var entry = idx.codePoints[strings.ToLower(strings.TrimSpace(fields[0]))]
+		if entry == nil {
+			continue
+		}
+		for _, keyword := range strings.Split(fields[1], ",") {
+			if keyword = foldEmojiTerm(keyword); keyword != "" && !inStringList(entry.terms, keyword) {
+				entry.terms = append(entry.terms, keyword)
+			}
+		}
+	}
+	idx.lastQuery, idx.lastMatches = "", nil
+	return scanner.Err()
+}
+
+func (idx *EmojiIndex) Len() int {
+	idx.Lock()
+	defer idx.Unlock()
+	return len(idx.entries)
+}
+
+// Get returns an emoji or one of its skin tone variants
+func (idx *EmojiIndex) Get(glyph string) *Emoji {
+	idx.Lock()
+	defer idx.Unlock()
+	return idx.emojis[glyph]
+}
+
+// GetShortname returns the emoji of a shortname, with its colons
+func (idx *EmojiIndex) GetShortname(shortname string) (string, bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	glyph, ok := idx.shortnames[strings.ToLower(shortname)]
+	return glyph, ok
+}
+
+// Search returns the emoji matching the query, the better matches first and
+// the emoji of the same kind of match in the order of emojione. The
+// shortnames and the smileys start with a colon, the other terms are
+// searched without it.
+func (idx *EmojiIndex) Search(query string) []*Emoji {
+	idx.Lock()
+	defer idx.Unlock()
+	var matches = idx.search(foldEmojiTerm(query))
+	var emojis = make([]*Emoji, len(matches))
+	for i, match := range matches {
+		emojis[i] = match.entry.emoji
+	}
+	return emojis
+}
+
+func (idx *EmojiIndex) search(query string) []emojiMatch {
+	if query == idx.lastQuery && idx.lastMatches != nil {
+		return idx.lastMatches
+	}
+	var entries = idx.entries
+	// a query which extends the last one matches a part of its matches, unless
+	// the last one was too short to be matched fuzzily
+	var lastName = strings.Trim(idx.lastQuery, ":")
+	if idx.lastMatches != nil && strings.HasPrefix(query, idx.lastQuery) && (lastName == "" || len(lastName) > 2) {
+		entries = make([]*emojiEntry, len(idx.lastMatches))
+		for i, match := range idx.lastMatches {
+			entries[i] = match.entry
+		}
+		// the entries of the last matches are sorted by their kinds of match
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].emoji.Order < entries[j].emoji.Order
+		})
+	}
+	var name = strings.Trim(query, ":")
+	var matches = make([]emojiMatch, 0, len(entries))
+	for _, entry := range entries {
+		var kind = emojiMatchNone
+		if inStringList(entry.shortnames, name) {
+			kind = emojiMatchShortname
+		}
+		for _, term := range entry.terms {
+			kind = minInt(kind, matchEmojiTerm(term, query))
+			if name != query {
+				kind = minInt(kind, matchEmojiTerm(term, name))
+			}
+		}
+		if kind != emojiMatchNone {
+			matches = append(matches, emojiMatch{entry, kind})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].kind < matches[j].kind
+	})
+	idx.lastQuery, idx.lastMatches = query, matches
+	return matches
+}
+
+func matchEmojiTerm(term, query string) int {
+	switch {
+	case query == "":
+		return emojiMatchPrefix
+	case term == query:
+		return emojiMatchExact
+	case strings.HasPrefix(term, query):
+		return emojiMatchPrefix
+	case strings.Contains(term, " "+query):
+		return emojiMatchWord
+	case strings.Contains(term, query):
+		return emojiMatchSubstring
+	case len(query) > 2 && isFuzzyEmojiMatch(term, query):
+		return emojiMatchFuzzy
+	}
+	return emojiMatchNone
+}
+
+// isFuzzyEmojiMatch reports whether the letters of the query are in the term
+// in the same order, starting at the beginning of one of its words
+func isFuzzyEmojiMatch(term, query string) bool {
+	for start := 0; start < len(term); start++ {
+		if start > 0 && term[start-1] != ' ' || term[start] != query[0] {
+			continue
+		}
+		var i = 0
+		for j := start; j < len(term) && i < len(query); j++ {
+			if term[j] == query[i] {
+				i++
+			}
+		}
+		if i == len(query) {
+			return true
+		}
+	}
+	return false
+}
@@ -22,8 +22,13 @@ import (
 	"testing"
 )
 
+func loadTestEmojiIndex() {
+	var idx, _ = loadEmojiOne("../../" + DictEmojiOne)
+	emojiIndex.Store(idx)
+}
+
 func TestEmojiFindResult(t *testing.T) {
-	emojiTrie, _ = loadEmojiOne("../../" + DictEmojiOne)
+	loadTestEmojiIndex()
 	var be = NewEmojiEngine()
 	if be.MatchString(":'") != true {
 		t.Errorf("Finding result for emoji :', expected true, got %v", be.MatchString(":'"))
@@ -40,7 +45,7 @@ func TestEmojiFindResult(t *testing.T) {
 }
 
 func TestFilterEmoji(t *testing.T) {
-	emojiTrie, _ = loadEmojiOne("../../" + DictEmojiOne)
+	loadTestEmojiIndex()
 	var be = NewEmojiEngine()
 	var grinnings = be.Filter(":')")
 	if !inStringList(grinnings, "😂") {
@@ -55,3 +60,137 @@ func TestFilterEmoji(t *testing.T) {
 		t.Errorf("Filtering emojo `grin`, expected %v got %v", true, inStringList(grinnings3, "😀"))
 	}
 }
+
+func TestEmojiShortname(t *testing.T) {
+	loadTestEmojiIndex()
+	var be = NewEmojiEngine()
+	if glyph, ok := be.MatchShortname(":thumbsup:"); !ok || glyph != "👍" {
+		t.Errorf("Process [:thumbsup:], got [%s %v] expected [👍 true]", glyph, ok)
+	}
+	if glyph, ok := be.MatchShortname(":+1:"); !ok || glyph != "👍" {
+		t.Errorf("Process [:+1:], got [%s %v] expected [👍 true]", glyph, ok)
+	}
+	if _, ok := be.MatchShortname(":thumbsu:"); ok {
+		t.Errorf("Process [:thumbsu:], got [true] expected [false]")
+	}
+	be.SkinTone = 3
+	if glyph, _ := be.MatchShortname(":thumbsup:"); glyph != "👍🏽" {
+		t.Errorf("Process [:thumbsup:] with skin tone 3, got [%s] expected [👍🏽]", glyph)
+	}
+	if name := getEmojiName("👍🏽"); name != "thumbs up: medium skin tone" {
+		t.Errorf("Process [👍🏽], got [%s] expected [thumbs up: medium skin tone]", name)
+	}
+}
+
+func TestEmojiRanking(t *testing.T) {
+	loadTestEmojiIndex()
+	defer func(recents *EmojiRecents) { emojiRecents = recents }(emojiRecents)
+	emojiRecents = NewEmojiRecents()
+	var be = NewEmojiEngine()
+	var candidates = be.Filter(":grin")
+	if len(candidates) < 2 || candidates[0] != "😁" || candidates[1] != "😀" {
+		t.Errorf("Process [:grin], got [%v] expected [😁 😀 ...]", candidates)
+	}
+	for _, candidate := range be.Filter("thumb") {
+		if getEmojiIndex().Get(candidate).Base != "" {
+			t.Errorf("Process [thumb], got [%s] expected no skin tone variant", candidate)
+		}
+	}
+	emojiRecents.Add("😸")
+	if candidates = be.Filter(":grin"); candidates[0] != "😸" {
+		t.Errorf("Process [:grin] after picking 😸, got [%s] expected [😸]", candidates[0])
+	}
+	be.Category = "food"
+	candidates = be.Filter(":")
+	if len(candidates) == 0 {
+		t.Fatalf("Process [:] in category food, got no candidate")
+	}
+	for _, candidate := range candidates {
+		if getEmojiIndex().Get(candidate).Category != "food" {
+			t.Errorf("Process [:] in category food, got [%s] of category [%s]", candidate, getEmojiIndex().Get(candidate).Category)
+		}
+	}
+}
+
+func TestEmojiNextCategory(t *testing.T) {
+	var be = NewEmojiEngine()
+	be.NextCategory(false)
+	if be.Category != emojiCategories[0] {
+		t.Errorf("Process [Tab], got [%s] expected [%s]", be.Category, emojiCategories[0])
+	}
+	be.NextCategory(true)
+	be.NextCategory(true)
+	if be.Category != emojiCategories[len(emojiCategories)-1] {
+		t.Errorf("Process [Shift+Tab Shift+Tab], got [%s] expected [%s]", be.Category, emojiCategories[len(emojiCategories)-1])
+	}
+}
+
+func TestEmojiRecents(t *testing.T) {
+	var recents = NewEmojiRecents()
+	for i := 0; i < EmojiMaxRecents+5; i++ {
+		recents.Add(string(rune(0x1f600 + i)))
+	}
+	recents.Add("😃")
+	if rank := recents.Rank("😃"); rank != 0 {
+		t.Errorf("Process [😃], got [%d] expected [0]", rank)
+	}
+	if rank := recents.Rank("😀"); rank != -1 {
+		t.Errorf("Process [😀], got [%d] expected [-1]", rank)
+	}
+	if len(recents.glyphs) != EmojiMaxRecents {
+		t.Errorf("Process [%d picks], got [%d] expected [%d]", EmojiMaxRecents+6, len(recents.glyphs), EmojiMaxRecents)
+	}
+}
+
+func TestEmojiIndexSearch(t *testing.T) {
+	var idx, _ = loadEmojiOne("../../" + DictEmojiOne)
+	if err := idx.LoadKeywords("../../" + DictEmojiKeywordsVi); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		query, expected string
+		maxRank         int
+	}{
+		{"tim", "❤️", 0},
+		{"trái tim", "❤️", 0},
+		{"trai tim", "❤️", 0},
+		{"TRÁI TIM", "❤️", 0},
+		{":heart", "❤️", 0},
+		{"phở", "🍜", 0},
+		{"umbsu", "👍", 0},
+		{"thmbs", "👍", 3},
+		{":')", "😂", 0},
+	}
+	for _, test := range tests {
+		var rank = -1
+		for i, emoji := range idx.Search(test.query) {
+			if emoji.Glyph == test.expected {
+				rank = i
+				break
+			}
+		}
+		if rank < 0 || rank > test.maxRank {
+			t.Errorf("Process [%s], got [%s] at [%d] expected at most [%d]", test.query, test.expected, rank, test.maxRank)
+		}
+	}
+}
+
+func TestEmojiIndexCache(t *testing.T) {
+	var idx, _ = loadEmojiOne("../../" + DictEmojiOne)
+	var query string
+	for _, c := range ":thumbs up" {
+		query += string(c)
+		var cached = idx.Search(query)
+		idx.lastQuery, idx.lastMatches = "", nil
+		var fresh = idx.Search(query)
+		if len(cached) != len(fresh) {
+			t.Fatalf("Process [%s], got [%d] emoji expected [%d]", query, len(cached), len(fresh))
+		}
+		for i := range fresh {
+			if cached[i] != fresh[i] {
+				t.Fatalf("Process [%s], got [%s] at [%d] expected [%s]", query, cached[i].Glyph, i, fresh[i].Glyph)
+			}
+		}
+		idx.Search(query)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/BambooEngine/bamboo-core"
 	"github.com/BambooEngine/goibus/ibus"
@@ -35,18 +36,29 @@ import (
 type IBusBambooEngine struct {
 	sync.Mutex
 	IEngine
-	preeditor              bamboo.IEngine
+	preeditor              *bamboo.Session
 	engineName             string
 	config                 *Config
 	propList               *ibus.PropList
 	englishMode            bool
 	macroTable             *MacroTable
 	wmClasses              string
+	focusWindow            uint64
 	isInputModeLTOpened    bool
 	isEmojiLTOpened        bool
 	isInHexadecimal        bool
+	isSuggestionLTOpened   bool
+	isDiacriticLTOpened    bool
 	emojiLookupTable       *ibus.LookupTable
+	emojiCandidates        []string
 	inputModeLookupTable   *ibus.LookupTable
+	suggestionLookupTable  *ibus.LookupTable
+	suggestions            []string
+	diacriticLookupTable   *ibus.LookupTable
+	diacriticCandidates    []string
+	unicodeLookupTable     *ibus.LookupTable
+	unicodeCandidates      []rune
+	committedRunes         []rune
 	capabilities           uint32
 	keyPressDelay          int
 	nFakeBackSpace         int32
@@ -59,13 +71,36 @@ type IBusBambooEngine struct {
 	shouldRestoreKeyStrokes bool
 	// enqueue key strokes to process later
 	shouldEnqueuKeyStrokes bool
+	// the code point or the name typed in the Unicode input mode
+	unicodeQuery []rune
+	// the text typed without accents in the diacritic restoration mode
+	diacriticText []rune
+	// the last word corrected by the typo correction, to undo it
+	lastTypoCorrection *typoCorrection
+	// the words in progress of the other windows, by WM_CLASS and window id
+	suspendedCompositions map[string]*suspendedComposition
+	// the word to resume once the surrounding text is known
+	pendingResume *suspendedComposition
+	// the config read after its file changed, it is applied on the next focus
+	pendingConfig atomic.Value
+	// the info of the config file written by the engine, which is not read again
+	savedConfig atomic.Value
+	// the changes of the dictionary files which are applied to the engine
+	dictionaryChanges int32
+	stopConfigWatch   func()
+	// the suggestions are macro keys which are expanded once picked
+	macroSuggestion bool
+	// keyMutex guards the state which the goroutine of the enqueued keys shares
+	// with the IBus one in the backspace modes: committedRunes, the suggestions
+	// and lastTypoCorrection
+	keyMutex sync.Mutex
 }
 
 func NewIbusBambooEngine(name string, cfg *Config, base IEngine, preeditor bamboo.IEngine) *IBusBambooEngine {
 	return &IBusBambooEngine{
 		engineName: name,
 		IEngine:    base,
-		preeditor:  preeditor,
+		preeditor:  bamboo.NewSession(preeditor),
 		config:     cfg,
 	}
 }
@@ -100,6 +135,17 @@ func (e *IBusBambooEngine) ProcessKeyEvent(keyVal uint32, keyCode uint32, state
 		println("shortcut")
 		return retValue, nil
 	}
+	// a correction can only be undone right after it has been made
+	e.updateTypoCorrection(keyVal)
+	e.pendingResume = nil
+	if e.config.IBflags&IBdiacriticRestoration != 0 {
+		if ret, retValue := e.diacriticProcessKeyEvent(keyVal, keyCode, state); ret {
+			e.updateLastKeyWithShift(keyVal, state)
+			return retValue, nil
+		}
+		// the words are gathered in the pre-edit, whatever the input mode
+		return e.preeditProcessKeyEvent(keyVal, keyCode, state)
+	}
 	if e.inBackspaceWhiteList() {
 		return e.bsProcessKeyEvent(keyVal, keyCode, state)
 	}
@@ -109,18 +155,26 @@ func (e *IBusBambooEngine) ProcessKeyEvent(keyVal uint32, keyCode uint32, state
 func (e *IBusBambooEngine) FocusIn() *dbus.Error {
 	log.Print("FocusIn.")
 	var latestWm = e.getLatestWmClass()
-	e.checkWmClass(latestWm)
+	e.checkWmClass(latestWm, e.getLatestFocusWindow())
+	e.applyFileChanges()
 	e.RegisterProperties(e.propList)
 	e.RequireSurroundingText()
-	if e.isShortcutKeyEnable(KSEmojiDialog) && emojiTrie != nil && len(emojiTrie.Children) == 0 {
-		var err error
-		emojiTrie, err = loadEmojiOne(DictEmojiOne)
-		if err != nil {
-			panic(fmt.Sprintf("failed to load emojiTrie from %s: %s", DictEmojiOne, err))
+	if e.isShortcutKeyEnable(KSEmojiDialog) || e.config.IBflags&IBemojiShortcode != 0 {
+		if err := e.loadEmojiData(); err != nil {
+			log.Println(err)
+			e.config.IBflags &= ^IBemojiShortcode
+			e.propList = GetPropListByConfig(e.config)
+			e.RegisterProperties(e.propList)
 		}
 	}
-	if e.config.IBflags&IBspellCheckWithDicts != 0 && len(dictionary) == 0 {
-		dictionary, _ = loadDictionary(DictVietnameseCm)
+	if e.config.IBflags&IBwordSuggestion != 0 {
+		e.loadSuggestionTrie()
+	}
+	if e.config.IBflags&IBdiacriticRestoration != 0 {
+		e.loadDiacriticModel()
+	}
+	if e.config.IBflags&IBtypoCorrection != 0 {
+		e.loadTypoDictionary()
 	}
 	if inStringList(disabledMouseCapturingList, e.getWmClass()) {
 		stopMouseCapturing()
@@ -133,11 +187,18 @@ func (e *IBusBambooEngine) FocusIn() *dbus.Error {
 
 func (e *IBusBambooEngine) FocusOut() *dbus.Error {
 	log.Print("FocusOut.")
+	userFrequency.Save()
 	return nil
 }
 
 func (e *IBusBambooEngine) Reset() *dbus.Error {
 	fmt.Print("Reset.\n")
+	if e.config.IBflags&IBdiacriticRestoration != 0 {
+		// the words are in the pre-edit whatever the input mode
+		if text := e.getDiacriticText(); text != "" {
+			e.commitDiacriticText(text)
+		}
+	}
 	if e.checkInputMode(preeditIM) {
 		e.commitPreeditAndReset(e.getPreeditString())
 	}
@@ -157,6 +218,9 @@ func (e *IBusBambooEngine) Disable() *dbus.Error {
 
 // @method(in_signature="vuu")
 func (e *IBusBambooEngine) SetSurroundingText(text dbus.Variant, cursorPos uint32, anchorPos uint32) *dbus.Error {
+	if e.pendingResume != nil {
+		e.resumeFromSurroundingText(text, cursorPos)
+	}
 	if !e.isSurroundingTextReady {
 		//fmt.Println("Surrounding Text is not ready yet.")
 		return nil
@@ -177,14 +241,17 @@ func (e *IBusBambooEngine) SetSurroundingText(text dbus.Variant, cursorPos uint3
 		}
 		var cs = s[:cursorPos]
 		fmt.Println("Surrounding Text: ", string(cs))
-		e.preeditor.Reset()
-		for i := len(cs) - 1; i >= 0; i-- {
-			// workaround for spell checking
-			if bamboo.IsPunctuationMark(cs[i]) && e.preeditor.CanProcessKey(cs[i]) {
-				cs[i] = ' '
+		// the key press goroutine must not see a half rebuilt composition
+		e.preeditor.Do(func(preeditor bamboo.IEngine) {
+			preeditor.Reset()
+			for i := len(cs) - 1; i >= 0; i-- {
+				// workaround for spell checking
+				if bamboo.IsPunctuationMark(cs[i]) && preeditor.CanProcessKey(cs[i]) {
+					cs[i] = ' '
+				}
+				preeditor.ProcessKey(cs[i], bamboo.EnglishMode|bamboo.InReverseOrder)
 			}
-			e.preeditor.ProcessKey(cs[i], bamboo.EnglishMode|bamboo.InReverseOrder)
-		}
+		})
 	}
 	return nil
 }
@@ -196,6 +263,17 @@ func (e *IBusBambooEngine) PageUp() *dbus.Error {
 	if e.isInputModeLTOpened && e.inputModeLookupTable.PageUp() {
 		e.updateInputModeLT()
 	}
+	e.keyMutex.Lock()
+	if e.isSuggestionLTOpened && e.suggestionLookupTable.PageUp() {
+		e.updateSuggestionLookupTable()
+	}
+	e.keyMutex.Unlock()
+	if e.isDiacriticLTOpened && e.diacriticLookupTable.PageUp() {
+		e.updateDiacriticLookupTable()
+	}
+	if e.isInHexadecimal && e.unicodeLookupTable != nil && e.unicodeLookupTable.PageUp() {
+		e.updateUnicodeLookupTable()
+	}
 	return nil
 }
 
@@ -206,6 +284,17 @@ func (e *IBusBambooEngine) PageDown() *dbus.Error {
 	if e.isInputModeLTOpened && e.inputModeLookupTable.PageDown() {
 		e.updateInputModeLT()
 	}
+	e.keyMutex.Lock()
+	if e.isSuggestionLTOpened && e.suggestionLookupTable.PageDown() {
+		e.updateSuggestionLookupTable()
+	}
+	e.keyMutex.Unlock()
+	if e.isDiacriticLTOpened && e.diacriticLookupTable.PageDown() {
+		e.updateDiacriticLookupTable()
+	}
+	if e.isInHexadecimal && e.unicodeLookupTable != nil && e.unicodeLookupTable.PageDown() {
+		e.updateUnicodeLookupTable()
+	}
 	return nil
 }
 
@@ -216,6 +305,17 @@ func (e *IBusBambooEngine) CursorUp() *dbus.Error {
 	if e.isInputModeLTOpened && e.inputModeLookupTable.CursorUp() {
 		e.updateInputModeLT()
 	}
+	e.keyMutex.Lock()
+	if e.isSuggestionLTOpened && e.suggestionLookupTable.CursorUp() {
+		e.updateSuggestionLookupTable()
+	}
+	e.keyMutex.Unlock()
+	if e.isDiacriticLTOpened && e.diacriticLookupTable.CursorUp() {
+		e.updateDiacriticLookupTable()
+	}
+	if e.isInHexadecimal && e.unicodeLookupTable != nil && e.unicodeLookupTable.CursorUp() {
+		e.updateUnicodeLookupTable()
+	}
 	return nil
 }
 
@@ -226,6 +326,17 @@ func (e *IBusBambooEngine) CursorDown() *dbus.Error {
 	if e.isInputModeLTOpened && e.inputModeLookupTable.CursorDown() {
 		e.updateInputModeLT()
 	}
+	e.keyMutex.Lock()
+	if e.isSuggestionLTOpened && e.suggestionLookupTable.CursorDown() {
+		e.updateSuggestionLookupTable()
+	}
+	e.keyMutex.Unlock()
+	if e.isDiacriticLTOpened && e.diacriticLookupTable.CursorDown() {
+		e.updateDiacriticLookupTable()
+	}
+	if e.isInHexadecimal && e.unicodeLookupTable != nil && e.unicodeLookupTable.CursorDown() {
+		e.updateUnicodeLookupTable()
+	}
 	return nil
 }
 
@@ -238,6 +349,17 @@ func (e *IBusBambooEngine) CandidateClicked(index uint32, button uint32, state u
 		e.commitInputModeCandidate()
 		e.closeInputModeCandidates()
 	}
+	e.keyMutex.Lock()
+	if e.isSuggestionLTOpened && e.suggestionLookupTable.SetCursorPos(index) {
+		e.commitSuggestionCandidate()
+	}
+	e.keyMutex.Unlock()
+	if e.isDiacriticLTOpened && e.diacriticLookupTable.SetCursorPos(index) {
+		e.commitDiacriticCandidate()
+	}
+	if e.isInHexadecimal && e.unicodeLookupTable != nil && e.unicodeLookupTable.SetCursorPos(index) {
+		e.commitUnicodeCandidate()
+	}
 	return nil
 }
 
@@ -261,13 +383,17 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 		return nil
 	}
 	if propName == PropKeyVnCharsetConvert {
-		exec.Command("xdg-open", CharsetConvertPage).Start()
+		e.convertClipboardCharset()
 		return nil
 	}
 	if propName == PropKeyConfiguration {
 		exec.Command("/usr/lib/ibus-bamboo/macro-editor", getConfigPath(e.engineName)).Start()
 		return nil
 	}
+	if propName == PropKeyInputMethodEditor {
+		e.openInputMethodEditor()
+		return nil
+	}
 	if propName == PropKeyInputModeLookupTableShortcut {
 		e.openShortcutsGUI()
 	}
@@ -275,6 +401,18 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 		OpenMactabFile(e.engineName)
 		return nil
 	}
+	if propName == PropKeyMacroAppTable {
+		OpenAppMactabFile(e.engineName, e.getWmClass())
+		return nil
+	}
+	if propName == PropKeyMacroImport {
+		e.importMacroFile()
+		return nil
+	}
+	if propName == PropKeyMacroExport {
+		e.exportMacroFile()
+		return nil
+	}
 
 	turnSpellChecking := func(on bool) {
 		if on {
@@ -322,11 +460,64 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 		if propState == ibus.PROP_STATE_CHECKED {
 			e.config.IBflags |= IBspellCheckWithDicts
 			turnSpellChecking(true)
-			dictionary, _ = loadDictionary(DictVietnameseCm)
 		} else {
 			e.config.IBflags &= ^IBspellCheckWithDicts
 		}
 	}
+	if propName == PropKeyTypoCorrection {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= IBtypoCorrection
+			e.loadTypoDictionary()
+		} else {
+			e.config.IBflags &= ^IBtypoCorrection
+		}
+	}
+	if propName == PropKeyEmojiShortcode {
+		if propState == ibus.PROP_STATE_CHECKED {
+			if err := e.loadEmojiData(); err != nil {
+				log.Println(err)
+			} else {
+				e.config.IBflags |= IBemojiShortcode
+			}
+		} else {
+			e.config.IBflags &= ^IBemojiShortcode
+		}
+	}
+	if propName == PropKeyMacroCandidates {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= IBmacroCandidates
+		} else {
+			e.config.IBflags &= ^IBmacroCandidates
+		}
+	}
+	if propName == PropKeyWordSuggestion {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= IBwordSuggestion
+			e.loadSuggestionTrie()
+		} else {
+			e.config.IBflags &= ^IBwordSuggestion
+		}
+	}
+	if propName == PropKeyDiacriticRestoration {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= IBdiacriticRestoration
+			e.loadDiacriticModel()
+		} else {
+			if text := e.getDiacriticText(); text != "" {
+				e.commitDiacriticText(text)
+			}
+			e.config.IBflags &= ^IBdiacriticRestoration
+		}
+	}
+	if propName == PropKeyFrequencyLearning {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= IBfrequencyLearning
+			userFrequency.Enable(e.engineName)
+		} else {
+			e.config.IBflags &= ^IBfrequencyLearning
+			userFrequency.Disable()
+		}
+	}
 	if propName == PropKeyMouseCapturing {
 		if propState == ibus.PROP_STATE_CHECKED {
 			e.config.IBflags |= IBmouseCapturing
@@ -376,20 +567,39 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 	if foundIm && propState == ibus.PROP_STATE_CHECKED {
 		e.config.DefaultInputMode, _ = strconv.Atoi(im)
 	}
+	var profile, foundProfile = getValueFromPropKey(propName, "SpellingProfile")
+	if foundProfile && bamboo.GetSpellingProfile(profile) != nil && propState == ibus.PROP_STATE_CHECKED {
+		e.config.SpellingProfile = profile
+	}
 	var charset, foundCs = getValueFromPropKey(propName, "OutputCharset")
 	if foundCs && isValidCharset(charset) && propState == ibus.PROP_STATE_CHECKED {
 		e.config.OutputCharset = charset
 	}
+	var unicodeForm, foundUnicodeForm = getValueFromPropKey(propName, "UnicodeOutputForm")
+	if foundUnicodeForm && isValidUnicodeForm(unicodeForm) && propState == ibus.PROP_STATE_CHECKED {
+		e.config.UnicodeOutputForm = unicodeForm
+	}
+	var skinTone, foundSkinTone = getValueFromPropKey(propName, "EmojiSkinTone")
+	if tone, err := strconv.Atoi(skinTone); foundSkinTone && err == nil && tone >= 0 && tone <= EmojiSkinTones && propState == ibus.PROP_STATE_CHECKED {
+		e.config.EmojiSkinTone = tone
+	}
+	var sourceCs, foundSourceCs = getValueFromPropKey(propName, "ClipboardSourceCharset")
+	if foundSourceCs && (isValidCharset(sourceCs) || sourceCs == ClipboardCharsetAuto) && propState == ibus.PROP_STATE_CHECKED {
+		e.config.ClipboardSourceCharset = sourceCs
+	}
+	var targetCs, foundTargetCs = getValueFromPropKey(propName, "ClipboardTargetCharset")
+	if foundTargetCs && isValidCharset(targetCs) && propState == ibus.PROP_STATE_CHECKED {
+		e.config.ClipboardTargetCharset = targetCs
+	}
 	if _, found := e.config.InputMethodDefinitions[propName]; found && propState == ibus.PROP_STATE_CHECKED {
 		e.config.InputMethod = propName
 	}
 	if propName != "-" {
-		saveConfig(e.config, e.engineName)
+		e.saveConfig()
 	}
 	e.propList = GetPropListByConfig(e.config)
 
-	var inputMethod = bamboo.ParseInputMethod(e.config.InputMethodDefinitions, e.config.InputMethod)
-	e.preeditor = bamboo.NewEngine(inputMethod, e.config.Flags)
+	e.preeditor.SetEngine(newPreeditor(e.config))
 	e.RegisterProperties(e.propList)
 	return nil
 }
@@ -24,6 +24,7 @@ import (
 	"log"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/BambooEngine/bamboo-core"
@@ -34,21 +35,28 @@ const BACKSPACE_INTERVAL = 0
 
 func (e *IBusBambooEngine) bsProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) (bool, *dbus.Error) {
 	if isMovementKey(keyVal) {
+		e.keyMutex.Lock()
+		e.committedRunes = nil
 		e.preeditor.Reset()
+		e.closeSuggestionCandidates()
+		e.keyMutex.Unlock()
 		e.resetFakeBackspace()
 		e.isSurroundingTextReady = true
 		return false, nil
 	}
 	var keyRune = rune(keyVal)
-	if e.config.IBflags&IBmacroEnabled == 0 && len(keyPressChan) == 0 && e.getRawKeyLen() == 0 && !inKeyList(e.preeditor.GetInputMethod().AppendingKeys, keyRune) {
+	if !e.shouldKeepPunctuation() && len(keyPressChan) == 0 && e.getRawKeyLen() == 0 && !inKeyList(e.preeditor.GetInputMethod().AppendingKeys, keyRune) {
 		e.updateLastKeyWithShift(keyVal, state)
 		if e.preeditor.CanProcessKey(keyRune) && isValidState(state) {
 			e.isFirstTimeSendingBS = true
 			if state&IBusLockMask != 0 {
-				keyRune = e.toUpper(keyRune)
+				keyRune = e.toUpper(e.preeditor, keyRune)
 			}
 			e.preeditor.ProcessKey(keyRune, bamboo.VietnameseMode)
 			e.commitText(e.getPreeditString())
+			e.keyMutex.Lock()
+			e.trackCommittedRunes([]rune(e.getPreeditString()))
+			e.keyMutex.Unlock()
 			return true, nil
 		}
 		return false, nil
@@ -64,7 +72,7 @@ func (e *IBusBambooEngine) bsProcessKeyEvent(keyVal uint32, keyCode uint32, stat
 				} else {
 					sleep()
 					if e.getRawKeyLen() > 0 {
-						if e.shouldFallbackToEnglish(true) {
+						if e.shouldFallbackToEnglish(e.preeditor, true) {
 							e.preeditor.RestoreLastWord(false)
 						}
 						e.preeditor.RemoveLastChar(false)
@@ -74,15 +82,15 @@ func (e *IBusBambooEngine) bsProcessKeyEvent(keyVal uint32, keyCode uint32, stat
 			}
 			if keyVal == IBusTab {
 				sleep()
-				if ok, _ := e.getMacroText(); !ok {
+				if ok, _ := e.getMacroText(e.preeditor); !ok {
 					e.preeditor.Reset()
 					return false, nil
 				}
 			}
-			isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
+			isValidKey := isValidState(state) && e.isValidKeyVal(e.preeditor, keyVal)
 			if !isValidKey {
 				sleep()
-				return e.keyPressHandler(keyVal, keyCode, state), nil
+				return e.lockedKeyPressHandler(keyVal, keyCode, state), nil
 			}
 		}
 		// if the main thread is busy processing, the keypress events come all mixed up
@@ -90,45 +98,79 @@ func (e *IBusBambooEngine) bsProcessKeyEvent(keyVal uint32, keyCode uint32, stat
 		keyPressChan <- [3]uint32{keyVal, keyCode, state}
 		return true, nil
 	} else {
-		return e.keyPressHandler(keyVal, keyCode, state), nil
+		return e.lockedKeyPressHandler(keyVal, keyCode, state), nil
 	}
 }
 
 func (e *IBusBambooEngine) keyPressForwardHandler(keyVal, keyCode, state uint32) {
-	ret := e.keyPressHandler(keyVal, keyCode, state)
+	ret := e.lockedKeyPressHandler(keyVal, keyCode, state)
 	if !ret {
 		e.ForwardKeyEvent(keyVal, keyCode, state)
 	}
 }
 
+// lockedKeyPressHandler runs keyPressHandler with keyMutex held, the keys are
+// processed on the IBus goroutine and on the one of the enqueued keys
+func (e *IBusBambooEngine) lockedKeyPressHandler(keyVal, keyCode, state uint32) bool {
+	e.keyMutex.Lock()
+	defer e.keyMutex.Unlock()
+	return e.keyPressHandler(keyVal, keyCode, state)
+}
+
 func (e *IBusBambooEngine) keyPressHandler(keyVal, keyCode, state uint32) bool {
 	// log.Printf(">>Backspace:ProcessKeyEvent >  %c | keyCode 0x%04x keyVal 0x%04x | %d\n", rune(keyVal), keyCode, keyVal, len(keyPressChan))
 	defer e.updateLastKeyWithShift(keyVal, state)
+	if e.isSuggestionLTOpened {
+		if ret, retValue := e.suggestionProcessKeyEvent(keyVal, keyCode, state); ret {
+			return retValue
+		}
+	}
+	defer e.updateMacroSuggestions()
 	if e.keyPressDelay > 0 {
 		time.Sleep(time.Duration(e.keyPressDelay) * time.Millisecond)
 		e.keyPressDelay = 0
 	}
-	oldText := e.getPreeditString()
-	_, oldMacText := e.getMacroText()
 	if keyVal == IBusBackSpace {
-		if e.getRawKeyLen() > 0 {
+		var oldText, newText string
+		var changed bool
+		// the text is read, changed and read again in one go so that another
+		// goroutine, e.g. the one of the surrounding text, cannot change the
+		// composition in the middle
+		e.preeditor.Do(func(preeditor bamboo.IEngine) {
+			if preeditor.GetProcessedString(bamboo.EnglishMode|bamboo.FullText) == "" {
+				return
+			}
 			if e.config.IBflags&IBautoNonVnRestore == 0 {
-				e.preeditor.RemoveLastChar(false)
-				return false
+				preeditor.RemoveLastChar(false)
+				return
 			}
-			e.preeditor.RemoveLastChar(true)
-			var newText = e.getPreeditString()
+			oldText = e.getPreeditStringOf(preeditor)
+			preeditor.RemoveLastChar(true)
+			newText = e.getPreeditStringOf(preeditor)
 			var offset = e.getPreeditOffset([]rune(newText), []rune(oldText))
-			if oldText != "" && offset != len([]rune(newText)) {
-				e.updatePreviousText(oldText, newText)
-				return true
-			}
+			changed = oldText != "" && offset != len([]rune(newText))
+		})
+		if changed {
+			e.updatePreviousText(oldText, newText)
+			return true
 		}
 		return false
 	}
 
+	var oldText, oldMacText, newText string
+	var isValidKey, isWordBreakRune bool
+	e.preeditor.Do(func(preeditor bamboo.IEngine) {
+		oldText = e.getPreeditStringOf(preeditor)
+		_, oldMacText = e.getMacroText(preeditor)
+		if keyVal == IBusTab {
+			preeditor.Reset()
+			return
+		}
+		isValidKey = isValidState(state) && e.isValidKeyVal(preeditor, keyVal)
+		newText, isWordBreakRune = e.getCommitText(preeditor, keyVal, keyCode, state)
+	})
+
 	if keyVal == IBusTab {
-		defer e.preeditor.Reset()
 		if oldMacText != "" {
 			e.updatePreviousText(oldText, oldMacText)
 			return true
@@ -136,8 +178,6 @@ func (e *IBusBambooEngine) keyPressHandler(keyVal, keyCode, state uint32) bool {
 		return false
 	}
 
-	isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
-	newText, isWordBreakRune := e.getCommitText(keyVal, keyCode, state)
 	if len(newText) > 0 {
 		if e.shouldAppendDeadKey(newText, oldText) {
 			fmt.Println("Append a deadkey")
@@ -193,31 +233,35 @@ func (e *IBusBambooEngine) updatePreviousTextInBatch(oldText, newText string, is
 		e.SendBackSpace(nBackSpace)
 	}
 	var buffer = []string{string(offsetRunes)}
-	if isWordBreakRune {
-		e.preeditor.Reset()
-		buffer = append(buffer, "")
-	}
 	// isDirty means containing runes that are not committed
 	var isDirty = false
-	for i := 0; i < len(keyPressChan); i++ {
-		var keyEvents = <-keyPressChan
-		var keyVal, keyCode, state = keyEvents[0], keyEvents[1], keyEvents[2]
-		isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
-		if isValidKey {
-			var commitText, isWordBreakRune0 = e.getCommitText(keyVal, keyCode, state)
-			buffer[len(buffer)-1] = commitText
-			if isWordBreakRune0 {
-				buffer = append(buffer, "")
-			}
-			isDirty = true
-		} else {
-			if isDirty {
-				e.batchCommit(oldText, strings.Join(buffer, ""), nBackSpace, isWordBreakRune)
-				buffer = []string{""}
+	// the enqueued keys are processed in one go so that another goroutine, e.g.
+	// the one of the surrounding text, cannot change the composition in between
+	e.preeditor.Do(func(preeditor bamboo.IEngine) {
+		if isWordBreakRune {
+			preeditor.Reset()
+			buffer = append(buffer, "")
+		}
+		for i := 0; i < len(keyPressChan); i++ {
+			var keyEvents = <-keyPressChan
+			var keyVal, keyCode, state = keyEvents[0], keyEvents[1], keyEvents[2]
+			isValidKey := isValidState(state) && e.isValidKeyVal(preeditor, keyVal)
+			if isValidKey {
+				var commitText, isWordBreakRune0 = e.getCommitText(preeditor, keyVal, keyCode, state)
+				buffer[len(buffer)-1] = commitText
+				if isWordBreakRune0 {
+					buffer = append(buffer, "")
+				}
+				isDirty = true
+			} else {
+				if isDirty {
+					e.batchCommit(oldText, strings.Join(buffer, ""), nBackSpace, isWordBreakRune)
+					buffer = []string{""}
+				}
+				e.ForwardKeyEvent(keyVal, keyCode, state)
 			}
-			e.ForwardKeyEvent(keyVal, keyCode, state)
 		}
-	}
+	})
 	if isDirty {
 		e.batchCommit(oldText, strings.Join(buffer, ""), nBackSpace, isWordBreakRune)
 		return
@@ -267,6 +311,11 @@ func (e *IBusBambooEngine) SendBackSpace(n int) {
 	// Gtk/Qt apps have a serious sync issue with fake backspaces
 	// and normal string committing, so we'll not commit right now
 	// but delay until all the sent backspaces got processed.
+	if n < len(e.committedRunes) {
+		e.committedRunes = e.committedRunes[:len(e.committedRunes)-n]
+	} else {
+		e.committedRunes = nil
+	}
 	var now = time.Now()
 	var delta = 50*1000*1000 - (now.UnixNano() - e.lastCommitText)
 	if delta > 0 {
@@ -330,6 +379,12 @@ func (e *IBusBambooEngine) bsCommitText(rs []rune) {
 	if len(rs) == 0 {
 		return
 	}
+	var text, nLeft = splitMacroCursor(string(rs))
+	if nLeft > 0 {
+		defer e.moveCursorLeft(nLeft)
+		rs = []rune(text)
+	}
+	e.trackCommittedRunes(rs)
 	if e.checkInputMode(forwardAsCommitIM) {
 		log.Println("Forward as commit", string(rs))
 		for _, chr := range rs {
@@ -345,3 +400,19 @@ func (e *IBusBambooEngine) bsCommitText(rs []rune) {
 	}
 	e.commitText(string(rs))
 }
+
+// trackCommittedRunes follows the text which has been committed in the backspace modes,
+// the completed words are counted in the user frequency table
+func (e *IBusBambooEngine) trackCommittedRunes(rs []rune) {
+	e.committedRunes = append(e.committedRunes, rs...)
+	var lastBreak = -1
+	for i, chr := range e.committedRunes {
+		if !unicode.IsLetter(chr) && !unicode.IsDigit(chr) {
+			lastBreak = i
+		}
+	}
+	if lastBreak >= 0 {
+		userFrequency.RecordText(string(e.committedRunes[:lastBreak+1]))
+		e.committedRunes = append([]rune{}, e.committedRunes[lastBreak+1:]...)
+	}
+}
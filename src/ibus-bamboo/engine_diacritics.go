@@ -0,0 +1,197 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"log"
+
+	"github.com/BambooEngine/goibus/ibus"
+)
+
+func (e *IBusBambooEngine) loadDiacriticModel() {
+	if getDiacriticModel() != nil {
+		return
+	}
+	model, err := loadDiacriticModel(DictVietnameseNgram, DictVietnameseCm, DictVietnameseCompound)
+	if err != nil {
+		log.Println("Failed to load the diacritic model:", err)
+	}
+	diacriticModel.Store(model)
+}
+
+// diacriticProcessKeyEvent handles the keys of the sentence in the diacritic
+// restoration mode. The words are typed in the pre-edit like in the other
+// modes, only without the tones and the marks, and they are gathered on a word
+// break while the accented sentences are offered. The number keys or Enter
+// commit a candidate, Escape commits the text as it was typed.
+func (e *IBusBambooEngine) diacriticProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) (bool, bool) {
+	var keyRune = rune(keyVal)
+	if len(e.diacriticText) == 0 {
+		return false, false
+	}
+	if !isValidState(state) {
+		e.commitDiacriticCandidate()
+		return true, false
+	}
+	if e.isDiacriticLTOpened && keyRune >= '1' && keyRune <= '9' {
+		if e.updateCursorPosInDiacriticTable(uint32(keyRune - '1')) {
+			e.commitDiacriticCandidate()
+			return true, true
+		}
+	}
+	var rawKeyLen = e.getRawKeyLen()
+	switch {
+	case keyVal == IBusEscape:
+		e.commitDiacriticText(e.getDiacriticText())
+		return true, true
+	case keyVal == IBusReturn || keyVal == 0xff8d:
+		e.commitDiacriticCandidate()
+		return true, true
+	case keyVal == IBusBackSpace && rawKeyLen == 0:
+		e.diacriticText = e.diacriticText[:len(e.diacriticText)-1]
+		e.updatePreedit("")
+		if e.isDiacriticLTOpened {
+			e.updateDiacriticCandidates()
+		}
+		return true, true
+	case keyVal == IBusBackSpace && e.runeCount() == 1:
+		// the gathered words stay in the pre-edit
+		e.preeditor.Reset()
+		e.updatePreedit("")
+		return true, true
+	case keyVal == IBusBackSpace:
+		return false, false
+	case keyVal >= 0x20 && keyVal <= 0x7e:
+		if rawKeyLen == 0 && !e.preeditor.CanProcessKey(keyRune) {
+			// the symbols between the words, e.g. the space after a comma
+			e.diacriticText = append(e.diacriticText, keyRune)
+			e.updatePreedit("")
+			e.updateDiacriticCandidates()
+			return true, true
+		}
+		e.closeDiacriticCandidates()
+		return false, false
+	}
+	// the other keys, e.g. the arrows, take the best sentence
+	e.commitDiacriticCandidate()
+	return true, false
+}
+
+// appendDiacriticText gathers the word typed without accents on a word break, a
+// key which is not part of the text commits the most likely sentence
+func (e *IBusBambooEngine) appendDiacriticText(word string, isValidKey bool) {
+	e.diacriticText = append(e.diacriticText, []rune(word)...)
+	e.preeditor.Reset()
+	if !isValidKey {
+		e.commitDiacriticCandidate()
+		return
+	}
+	e.updatePreedit("")
+	e.updateDiacriticCandidates()
+}
+
+// getDiacriticText returns the words gathered and the word in progress
+func (e *IBusBambooEngine) getDiacriticText() string {
+	return string(e.diacriticText) + e.getPreeditString()
+}
+
+// showDiacriticCandidates offers the accented sentences without waiting for a word break
+func (e *IBusBambooEngine) showDiacriticCandidates() {
+	if e.getDiacriticText() != "" {
+		e.updateDiacriticCandidates()
+	}
+}
+
+func (e *IBusBambooEngine) updateDiacriticCandidates() {
+	var model = getDiacriticModel()
+	if model == nil {
+		return
+	}
+	var sentences = model.Restore(e.getDiacriticText(), DiacriticMaxCandidates)
+	if len(sentences) == 0 {
+		e.closeDiacriticCandidates()
+		return
+	}
+	lt := ibus.NewLookupTable()
+	for _, sentence := range sentences {
+		lt.AppendCandidate(e.encodeText(sentence))
+	}
+	lt.PageSize = uint32(DiacriticMaxCandidates)
+	e.diacriticCandidates = sentences
+	e.diacriticLookupTable = lt
+	e.isDiacriticLTOpened = true
+	e.updateDiacriticLookupTable()
+}
+
+func (e *IBusBambooEngine) updateCursorPosInDiacriticTable(idx uint32) bool {
+	pageSize := e.diacriticLookupTable.PageSize
+	if idx >= pageSize {
+		return false
+	}
+	page := e.diacriticLookupTable.CursorPos / pageSize
+	newPos := page*pageSize + idx
+	if int(newPos) >= len(e.diacriticLookupTable.Candidates) {
+		return false
+	}
+	e.diacriticLookupTable.CursorPos = newPos
+	return true
+}
+
+func (e *IBusBambooEngine) updateDiacriticLookupTable() {
+	var visible = len(e.diacriticLookupTable.Candidates) > 0
+	e.UpdateLookupTable(e.diacriticLookupTable, visible)
+}
+
+// commitDiacriticCandidate commits the highlighted sentence, or the most likely
+// one if the candidates are not shown
+func (e *IBusBambooEngine) commitDiacriticCandidate() {
+	if len(e.diacriticText) == 0 && e.getRawKeyLen() == 0 {
+		return
+	}
+	if e.isDiacriticLTOpened {
+		if pos := e.diacriticLookupTable.CursorPos; pos < uint32(len(e.diacriticCandidates)) {
+			e.commitDiacriticText(e.diacriticCandidates[pos])
+			return
+		}
+	}
+	var text = e.getDiacriticText()
+	if model := getDiacriticModel(); model != nil {
+		if sentences := model.Restore(text, 1); len(sentences) > 0 {
+			text = sentences[0]
+		}
+	}
+	e.commitDiacriticText(text)
+}
+
+func (e *IBusBambooEngine) commitDiacriticText(text string) {
+	e.diacriticText = nil
+	e.closeDiacriticCandidates()
+	e.commitPreeditAndReset(text)
+}
+
+func (e *IBusBambooEngine) closeDiacriticCandidates() {
+	if !e.isDiacriticLTOpened {
+		return
+	}
+	e.diacriticLookupTable = nil
+	e.diacriticCandidates = nil
+	e.isDiacriticLTOpened = false
+	e.HideLookupTable()
+}
@@ -20,7 +20,10 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"strconv"
+	"strings"
 
 	"github.com/BambooEngine/bamboo-core"
 	"github.com/BambooEngine/goibus/ibus"
@@ -28,18 +31,28 @@ import (
 
 const EmojiMaxPageSize = 9
 
+// loadEmojiData loads the emoji once, the emoji features are left without
+// emoji if the dictionary cannot be read
+func (e *IBusBambooEngine) loadEmojiData() error {
+	if getEmojiIndex().Len() > 0 {
+		return nil
+	}
+	var idx, err = loadEmojiOne(DictEmojiOne)
+	if err != nil {
+		return fmt.Errorf("failed to load the emoji from %s: %s", DictEmojiOne, err)
+	}
+	if err = idx.LoadKeywords(DictEmojiKeywordsVi); err != nil {
+		log.Println("Failed to load the Vietnamese keywords of the emoji:", err)
+	}
+	emojiIndex.Store(idx)
+	emojiRecents.Load(e.engineName)
+	return nil
+}
+
 func (e *IBusBambooEngine) openEmojiList() {
+	e.emoji.SkinTone = e.config.EmojiSkinTone
 	e.emoji.ProcessKey(':')
-	e.UpdatePreeditText(ibus.NewText(":"), 1, true)
-	e.UpdateAuxiliaryText(ibus.NewText(":"), true)
-	lt := ibus.NewLookupTable()
-	lt.Orientation = IBusOrientationHorizontal
-	for _, codePoint := range e.emoji.Query() {
-		lt.AppendCandidate(codePoint)
-	}
-	lt.PageSize = uint32(EmojiMaxPageSize)
-	e.emojiLookupTable = lt
-	e.updateEmojiLookupTable()
+	e.updateEmojiCandidates()
 }
 
 func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) bool {
@@ -48,12 +61,18 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 	var keyRune = rune(keyVal)
 	var reset = e.closeEmojiCandidates
 	if keyVal == IBusColon {
+		// the closing colon of a :shortname:
+		if glyph, ok := e.emoji.MatchShortname(e.emoji.GetTypedString() + ":"); ok && rawTextLen > 1 {
+			e.commitEmoji(glyph)
+			reset()
+			return true
+		}
 		reset()
 		return false
 	}
 	if keyVal == IBusReturn {
 		if rawTextLen > 0 {
-			if len(e.emojiLookupTable.Candidates) > 0 {
+			if len(e.emojiCandidates) > 0 {
 				e.commitEmojiCandidate()
 			} else {
 				e.CommitText(ibus.NewText(raw))
@@ -71,6 +90,11 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		}
 		return false
 	}
+	if keyVal == IBusTab || keyVal == IBusISOLeftTab {
+		e.emoji.NextCategory(keyVal == IBusISOLeftTab || state&IBusShiftMask != 0)
+		e.updateEmojiCandidates()
+		return true
+	}
 	if keyVal == IBusLeft || keyVal == IBusUp {
 		e.CursorUp()
 		return true
@@ -85,18 +109,24 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		return true
 	}
 	if keyVal == IBusBackSpace {
-		if rawTextLen > 0 {
+		if e.emoji.word != "" {
+			e.preeditor.RemoveLastChar(true)
+			e.updateEmojiWord()
+		} else if rawTextLen > 0 {
 			e.emoji.RemoveLastKey()
 		} else {
 			reset()
 			return false
 		}
 	} else if (keyRune >= 'a' && keyRune <= 'z') || (keyRune >= 'A' && keyRune <= 'Z') {
-		var testStr = string(append(e.emoji.keys, keyRune))
+		var testStr = raw + string(keyRune)
 		if raw == ":" && !e.emoji.MatchString(testStr) {
-			e.emoji.Reset()
+			e.emoji.keys = nil
 		}
-		e.emoji.ProcessKey(keyRune)
+		// the words of the query are composed like the other words, e.g.
+		// "trais tim" finds the emoji of "trái tim"
+		e.preeditor.ProcessKey(keyRune, e.getBambooInputMode(e.preeditor))
+		e.updateEmojiWord()
 	} else if keyRune >= '1' && keyRune <= '9' {
 		if pos, err := strconv.Atoi(string(keyRune)); err == nil {
 			if e.updateCursorPosInEmojiTable(uint32(pos - 1)) {
@@ -109,9 +139,11 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		}
 		return false
 	} else if (keyRune >= ' ' && keyRune <= '~') || bamboo.IsWordBreakSymbol(keyRune) {
+		e.emoji.CommitWord()
+		e.preeditor.Reset()
 		var testStr = string(append(e.emoji.keys, keyRune))
 		if raw == ":" && !e.emoji.MatchString(testStr) {
-			e.emoji.Reset()
+			e.emoji.keys = nil
 		}
 		e.emoji.ProcessKey(keyRune)
 		if !e.emoji.MatchString(string(e.emoji.keys)) {
@@ -124,35 +156,37 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		e.CommitText(ibus.NewText(raw))
 		return false
 	}
-	raw = e.emoji.GetRawString()
-	rawTextLen = len([]rune(raw))
-	cps := e.emoji.Query()
-	if cps != nil {
-		codePoint0 := cps[0]
-		e.UpdatePreeditTextWithMode(ibus.NewText(codePoint0), uint32(len(codePoint0)), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
-	} else {
-		e.UpdatePreeditTextWithMode(ibus.NewText(raw), uint32(rawTextLen), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
-	}
-	e.UpdateAuxiliaryText(ibus.NewText(raw), true)
+	e.updateEmojiCandidates()
+	return true
+}
+
+// updateEmojiWord takes the word in progress of the preeditor into the query
+func (e *IBusBambooEngine) updateEmojiWord() {
+	e.emoji.SetWord(e.getPreeditString(), e.getProcessedString(bamboo.EnglishMode))
+}
+
+// updateEmojiCandidates looks up the emoji of the keys and the category and
+// shows them with their names
+func (e *IBusBambooEngine) updateEmojiCandidates() {
+	e.emojiCandidates = e.emoji.Query()
 	lt := ibus.NewLookupTable()
-	lt.Orientation = IBusOrientationHorizontal
-	for _, codePoint := range cps {
-		lt.AppendCandidate(codePoint)
+	lt.Orientation = IBusOrientationVertical
+	for _, codePoint := range e.emojiCandidates {
+		lt.AppendCandidate(codePoint + "  " + getEmojiName(codePoint))
 	}
 	lt.PageSize = uint32(EmojiMaxPageSize)
 	e.emojiLookupTable = lt
 	e.updateEmojiLookupTable()
-	return true
 }
 
 func (e *IBusBambooEngine) updateCursorPosInEmojiTable(idx uint32) bool {
 	pageSize := e.emojiLookupTable.PageSize
-	if idx > pageSize {
+	if idx >= pageSize {
 		return false
 	}
 	page := e.emojiLookupTable.CursorPos / pageSize
 	newPos := page*pageSize + idx
-	if int(newPos) > len(e.emojiLookupTable.Candidates) {
+	if int(newPos) >= len(e.emojiCandidates) {
 		return false
 	}
 	e.emojiLookupTable.CursorPos = newPos
@@ -160,39 +194,67 @@ func (e *IBusBambooEngine) updateCursorPosInEmojiTable(idx uint32) bool {
 }
 
 func (e *IBusBambooEngine) updateEmojiLookupTable() {
-	if len(e.emoji.keys) == 0 {
+	if e.emoji.GetRawString() == "" || e.emojiLookupTable == nil {
 		return
 	}
-	var visible = len(e.emojiLookupTable.Candidates) > 0
-	e.UpdateLookupTable(e.emojiLookupTable, visible)
-	var cps = e.emoji.Query()
-	if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(cps)) {
-		var codePoint0 = cps[pos]
-		e.UpdatePreeditTextWithMode(ibus.NewText(codePoint0), uint32(len(codePoint0)), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+	var raw = e.emoji.GetRawString()
+	var preedit = raw
+	if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(e.emojiCandidates)) {
+		preedit = e.emojiCandidates[pos]
+	}
+	e.UpdatePreeditTextWithMode(ibus.NewText(preedit), uint32(len([]rune(preedit))), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+	var auxiliaryText = raw
+	if e.emoji.Category != "" {
+		auxiliaryText = "[" + e.emoji.Category + "] " + raw
 	}
+	e.UpdateAuxiliaryText(ibus.NewText(auxiliaryText), true)
+	var visible = len(e.emojiCandidates) > 0
+	e.UpdateLookupTable(e.emojiLookupTable, visible)
 }
 
 func (e *IBusBambooEngine) commitEmojiCandidate() {
-	var cps = e.emoji.Query()
-	if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(cps)) {
-		e.CommitText(ibus.NewText(cps[pos]))
+	if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(e.emojiCandidates)) {
+		e.commitEmoji(e.emojiCandidates[pos])
 	}
 }
 
+// commitEmoji commits an emoji and moves it to the front of the recent picks
+func (e *IBusBambooEngine) commitEmoji(glyph string) {
+	e.CommitText(ibus.NewText(glyph))
+	emojiRecents.Add(glyph)
+}
+
 func (e *IBusBambooEngine) refreshEmojiCandidate() {
-	var raw = e.emoji.GetRawString()
-	var rawTextLen = len([]rune(raw))
-	e.UpdatePreeditTextWithMode(ibus.NewText(raw), uint32(rawTextLen), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
-	e.UpdateAuxiliaryText(ibus.NewText(raw), true)
 	e.updateEmojiLookupTable()
 }
 
 func (e *IBusBambooEngine) closeEmojiCandidates() {
 	e.emojiLookupTable = nil
+	e.emojiCandidates = nil
 	e.emoji.Reset()
+	e.preeditor.Reset()
 	e.UpdateLookupTable(ibus.NewLookupTable(), true) // workaround for issue #18
 	e.HidePreeditText()
 	e.HideLookupTable()
 	e.HideAuxiliaryText()
 	e.isEmojiLTOpened = false
 }
+
+// getEmojiShortcode returns the emoji of a :shortname: typed inline
+func (e *IBusBambooEngine) getEmojiShortcode(text string) (string, bool) {
+	if e.config.IBflags&IBemojiShortcode == 0 || !isShortcodePrefix(text) {
+		return "", false
+	}
+	var be = &EmojiEngine{SkinTone: e.config.EmojiSkinTone}
+	return be.MatchShortname(strings.ToLower(text))
+}
+
+// getEmojiShortcodeCandidates returns the emoji matching the beginning of a
+// :shortname: typed inline
+func (e *IBusBambooEngine) getEmojiShortcodeCandidates(text string) []string {
+	if e.config.IBflags&IBemojiShortcode == 0 || !isShortcodePrefix(text) {
+		return nil
+	}
+	var be = &EmojiEngine{SkinTone: e.config.EmojiSkinTone}
+	return be.Filter(strings.ToLower(text))
+}
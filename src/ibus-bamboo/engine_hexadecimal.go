@@ -24,91 +24,161 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"unicode"
 
-	"github.com/BambooEngine/bamboo-core"
 	"github.com/BambooEngine/goibus/ibus"
 )
 
+// hexadecimalProcessKeyEvent handles the keys of the Unicode input mode: the
+// query is a hexadecimal code point or words of a character name. The pre-edit
+// shows the highlighted character in the output form, Enter commits it. Space
+// commits it too if the query is a code point prefixed with "u+" or "0x", e.g.
+// "u+1ea1", otherwise Space separates the words of the name, so that "face" or
+// "cafe" are not taken for code points.
 func (e *IBusBambooEngine) hexadecimalProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) bool {
-	var rawKeyLen = e.getRawKeyLen()
 	if keyVal >= 0xffb0 && keyVal <= 0xffb9 {
 		keyVal = keyVal - 0xffb0 + 0x0030
 	}
 	var keyRune = rune(keyVal)
-	var mode = bamboo.EnglishMode | bamboo.FullText
-	var oldText = e.getProcessedString(mode)
+	var query = string(e.unicodeQuery)
+	var hex, isPrefixed = splitCodePointQuery(query)
 	defer e.updateLastKeyWithShift(keyVal, state)
 
-	if rawKeyLen == 0 || oldText[0] != 'u' {
+	switch {
+	case keyVal == IBusEscape:
 		e.closeHexadecimalInput()
-		return false
-	}
-	if keyVal == IBusEscape {
-		e.closeHexadecimalInput()
-		return true
-	}
-	if keyVal == IBusBackSpace {
-		if rawKeyLen > 2 {
-			e.preeditor.RemoveLastChar(true)
-			e.updateHexadecimal(e.getProcessedString(mode))
-		} else {
+	case keyVal == IBusBackSpace:
+		if len(e.unicodeQuery) == 0 {
 			e.closeHexadecimalInput()
+			break
 		}
-		return true
-	}
-	if keyVal == IBusSpace || keyVal == IBusReturn || keyVal == 0xff8d {
-		if rawKeyLen > 1 {
-			value, err := strconv.ParseInt(oldText[1:], 16, 64)
-			if err != nil || value > 0x10ffff {
-				log.Println("Input is out of range")
-			} else {
-				log.Printf("Commit Text [%s]\n", fmt.Sprint(value))
-				e.CommitText(ibus.NewText(fmt.Sprint(value)))
-			}
+		e.unicodeQuery = e.unicodeQuery[:len(e.unicodeQuery)-1]
+		e.updateUnicodeCandidates()
+	case keyVal == IBusReturn || keyVal == 0xff8d:
+		e.commitUnicodeCandidate()
+	case keyVal == IBusSpace && (query == "" || (isPrefixed && isHexString(hex))):
+		e.commitUnicodeCandidate()
+	case keyVal == IBusUp:
+		e.CursorUp()
+	case keyVal == IBusDown:
+		e.CursorDown()
+	case keyVal == IBusPageUp:
+		e.PageUp()
+	case keyVal == IBusPageDown:
+		e.PageDown()
+	case keyRune >= '1' && keyRune <= '9' && query != "" && !isPrefixed && !isHexString(query):
+		// the digits are part of a code point, they pick a character of the names
+		if e.updateCursorPosInUnicodeTable(uint32(keyRune - '1')) {
+			e.commitUnicodeCandidate()
 		}
-		e.closeHexadecimalInput()
-		return true
-	}
-
-	if (keyRune >= '0' && keyRune <= '9') || (keyRune >= 'A' && keyRune <= 'F') || (keyRune >= 'a' && keyRune <= 'f') {
-		if !isValidState(state) || !e.isValidKeyVal(keyVal) {
-			return true
+	case keyVal >= 0x20 && keyVal <= 0x7e && isValidState(state):
+		if keyRune == ' ' && query[len(query)-1] == ' ' {
+			break
 		}
-		e.preeditor.ProcessKey(keyRune, mode)
-		e.updateHexadecimal(e.getProcessedString(mode))
+		e.unicodeQuery = append(e.unicodeQuery, keyRune)
+		e.updateUnicodeCandidates()
 	}
 	return true
 }
 
 func (e *IBusBambooEngine) setupHexadecimalProcessKeyEvent() {
-	var keyVal = uint32(117)
-	var state = uint32(0)
-	var keyRune = rune(keyVal)
-	var mode = bamboo.EnglishMode | bamboo.FullText
-	defer e.updateLastKeyWithShift(keyVal, state)
-
-	if e.isValidKeyVal(keyVal) {
-		e.preeditor.ProcessKey(keyRune, mode)
+	if getUnicodeNames() == nil {
+		names, err := loadUnicodeNames(DictUnicodeNames)
+		if err != nil {
+			log.Println("Failed to load the Unicode names:", err)
+		}
+		unicodeNames.Store(names)
 	}
-	e.updateHexadecimal(e.getProcessedString(mode))
+	e.unicodeQuery = nil
+	e.updateUnicodeCandidates()
 }
 
 func (e *IBusBambooEngine) closeHexadecimalInput() {
+	e.unicodeQuery = nil
+	e.unicodeCandidates = nil
+	e.unicodeLookupTable = nil
 	e.HidePreeditText()
-	e.preeditor.Reset()
+	e.HideLookupTable()
+	e.HideAuxiliaryText()
 	e.isInHexadecimal = false
 }
 
-func (e *IBusBambooEngine) updateHexadecimal(processedStr string) {
-	var encodedStr = e.encodeText(processedStr)
-	var preeditLen = uint32(len([]rune(encodedStr)))
-	if preeditLen == 0 {
-		e.HidePreeditText()
-		e.CommitText(ibus.NewText(""))
-		return
+func (e *IBusBambooEngine) getUnicodeOutputForm() string {
+	if isValidUnicodeForm(e.config.UnicodeOutputForm) {
+		return e.config.UnicodeOutputForm
+	}
+	return UnicodeFormCharacter
+}
+
+func (e *IBusBambooEngine) updateUnicodeCandidates() {
+	var query = string(e.unicodeQuery)
+	e.unicodeCandidates = searchUnicode(getUnicodeNames(), query, UnicodeMaxCandidates)
+	lt := ibus.NewLookupTable()
+	lt.Orientation = IBusOrientationVertical
+	for _, codePoint := range e.unicodeCandidates {
+		lt.AppendCandidate(fmt.Sprintf("%c  U+%04X %s", codePoint, codePoint, getUnicodeNames().Name(codePoint)))
 	}
-	var ibusText = ibus.NewText(encodedStr)
+	lt.PageSize = uint32(UnicodeMaxPageSize)
+	e.unicodeLookupTable = lt
+	e.updateUnicodeLookupTable()
+}
 
+// updateUnicodeLookupTable shows the candidates and the live preview of the
+// highlighted one
+func (e *IBusBambooEngine) updateUnicodeLookupTable() {
+	var preview = "u" + string(e.unicodeQuery)
+	var auxiliaryText = preview
+	if codePoint, ok := e.getUnicodeCandidate(); ok {
+		if form := e.getUnicodeOutputForm(); form != UnicodeFormCharacter || unicode.IsGraphic(codePoint) {
+			preview = formatCodePoint(codePoint, form)
+		}
+		auxiliaryText += fmt.Sprintf(": U+%04X %s", codePoint, getUnicodeNames().Name(codePoint))
+	}
+	var preeditLen = uint32(len([]rune(preview)))
+	var ibusText = ibus.NewText(preview)
 	ibusText.AppendAttr(ibus.IBUS_ATTR_TYPE_UNDERLINE, ibus.IBUS_ATTR_UNDERLINE_SINGLE, 0, preeditLen)
 	e.UpdatePreeditTextWithMode(ibusText, preeditLen, true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+	e.UpdateAuxiliaryText(ibus.NewText(auxiliaryText), true)
+	if len(e.unicodeCandidates) > 0 {
+		e.UpdateLookupTable(e.unicodeLookupTable, true)
+	} else {
+		e.HideLookupTable()
+	}
+}
+
+func (e *IBusBambooEngine) getUnicodeCandidate() (rune, bool) {
+	if e.unicodeLookupTable == nil {
+		return 0, false
+	}
+	if pos := e.unicodeLookupTable.CursorPos; pos < uint32(len(e.unicodeCandidates)) {
+		return e.unicodeCandidates[pos], true
+	}
+	return 0, false
+}
+
+func (e *IBusBambooEngine) updateCursorPosInUnicodeTable(idx uint32) bool {
+	pageSize := e.unicodeLookupTable.PageSize
+	if idx >= pageSize {
+		return false
+	}
+	page := e.unicodeLookupTable.CursorPos / pageSize
+	newPos := page*pageSize + idx
+	if int(newPos) >= len(e.unicodeCandidates) {
+		return false
+	}
+	e.unicodeLookupTable.CursorPos = newPos
+	return true
+}
+
+// commitUnicodeCandidate commits the highlighted character in the output form
+// and leaves the Unicode input mode
+func (e *IBusBambooEngine) commitUnicodeCandidate() {
+	if codePoint, ok := e.getUnicodeCandidate(); ok {
+		var text = formatCodePoint(codePoint, e.getUnicodeOutputForm())
+		log.Printf("Commit Text [%s]\n", text)
+		e.CommitText(ibus.NewText(text))
+	} else if len(e.unicodeQuery) > 0 {
+		log.Println("Input is out of range: " + strconv.Quote(string(e.unicodeQuery)))
+	}
+	e.closeHexadecimalInput()
 }
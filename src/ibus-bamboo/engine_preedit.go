@@ -35,8 +35,13 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 	var oldText = e.getPreeditString()
 	defer e.updateLastKeyWithShift(keyVal, state)
 
+	if e.isSuggestionLTOpened {
+		if ret, retValue := e.suggestionProcessKeyEvent(keyVal, keyCode, state); ret {
+			return retValue, nil
+		}
+	}
 	if !e.shouldRestoreKeyStrokes {
-		if !e.preeditor.CanProcessKey(keyRune) && rawKeyLen == 0 && e.config.IBflags&IBmacroEnabled == 0 {
+		if !e.preeditor.CanProcessKey(keyRune) && rawKeyLen == 0 && !e.shouldKeepPunctuation() {
 			// don't process special characters if rawKeyLen == 0,
 			// workaround for Chrome's address bar and Google SpreadSheets
 			return false, nil
@@ -57,7 +62,7 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 		}
 	}
 	if keyVal == IBusTab {
-		if ok, macText := e.getMacroText(); ok {
+		if ok, macText := e.getMacroText(e.preeditor); ok {
 			e.commitPreeditAndReset(macText)
 		} else {
 			e.commitPreeditAndReset(e.getComposedString(oldText))
@@ -66,9 +71,13 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 		return true, nil
 	}
 
-	newText, isWordBreakRune := e.getCommitText(keyVal, keyCode, state)
-	isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
+	newText, isWordBreakRune := e.getCommitText(e.preeditor, keyVal, keyCode, state)
+	isValidKey := isValidState(state) && e.isValidKeyVal(e.preeditor, keyVal)
 	if isWordBreakRune {
+		if e.config.IBflags&IBdiacriticRestoration != 0 {
+			e.appendDiacriticText(newText, isValidKey)
+			return isValidKey, nil
+		}
 		e.commitPreeditAndReset(newText)
 		return isValidKey, nil
 	}
@@ -81,12 +90,12 @@ func (e *IBusBambooEngine) expandMacro(str string) string {
 	if e.config.IBflags&IBautoCapitalizeMacro != 0 {
 		switch determineMacroCase(str) {
 		case VnCaseAllSmall:
-			return strings.ToLower(macroText)
+			macroText = strings.ToLower(macroText)
 		case VnCaseAllCapital:
-			return strings.ToUpper(macroText)
+			macroText = strings.ToUpper(macroText)
 		}
 	}
-	return macroText
+	return e.macroTable.ExpandPlaceholders(macroText)
 }
 
 func (e *IBusBambooEngine) updatePreedit(processedStr string) {
@@ -95,14 +104,17 @@ func (e *IBusBambooEngine) updatePreedit(processedStr string) {
 			mouseCaptureUnlock()
 		}
 	}()
-	var encodedStr = e.encodeText(processedStr)
+	// the words gathered in the diacritic restoration mode come first
+	var encodedStr = e.encodeText(string(e.diacriticText) + processedStr)
 	var preeditLen = uint32(len([]rune(encodedStr)))
 	if preeditLen == 0 {
 		e.HidePreeditText()
 		e.HideAuxiliaryText()
 		e.CommitText(ibus.NewText(""))
+		e.closeSuggestionCandidates()
 		return
 	}
+	e.updateSuggestions(processedStr)
 	var ibusText = ibus.NewText(encodedStr)
 	if inStringList(enabledAuxiliaryTextList, e.getWmClass()) {
 		e.UpdateAuxiliaryText(ibusText, true)
@@ -117,23 +129,24 @@ func (e *IBusBambooEngine) updatePreedit(processedStr string) {
 	e.UpdatePreeditTextWithMode(ibusText, preeditLen, true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
 }
 
-func (e *IBusBambooEngine) getBambooInputMode() bamboo.Mode {
-	if e.shouldFallbackToEnglish(false) {
+func (e *IBusBambooEngine) getBambooInputMode(preeditor bamboo.IEngine) bamboo.Mode {
+	// the tones and the marks are restored with the sentence
+	if e.config.IBflags&IBdiacriticRestoration != 0 || e.shouldFallbackToEnglish(preeditor, false) {
 		return bamboo.EnglishMode
 	}
 	return bamboo.VietnameseMode
 }
 
-func (e *IBusBambooEngine) shouldFallbackToEnglish(checkVnRune bool) bool {
+func (e *IBusBambooEngine) shouldFallbackToEnglish(preeditor bamboo.IEngine, checkVnRune bool) bool {
 	if e.config.IBflags&IBautoNonVnRestore == 0 {
 		return false
 	}
-	var vnSeq = e.getProcessedString(bamboo.VietnameseMode | bamboo.LowerCase)
+	var vnSeq = preeditor.GetProcessedString(bamboo.VietnameseMode | bamboo.LowerCase)
 	var vnRunes = []rune(vnSeq)
 	if len(vnRunes) == 0 {
 		return false
 	}
-	if ok, _ := e.getMacroText(); ok {
+	if ok, _ := e.getMacroText(preeditor); ok {
 		return false
 	}
 	// we want to allow dd even in non-vn sequence, because dd is used a lot in abbreviation
@@ -144,14 +157,14 @@ func (e *IBusBambooEngine) shouldFallbackToEnglish(checkVnRune bool) bool {
 	if checkVnRune && !bamboo.HasAnyVietnameseRune(vnSeq) {
 		return false
 	}
-	return !e.preeditor.IsValid(false)
+	return !preeditor.IsValid(false)
 }
 
-func (e *IBusBambooEngine) mustFallbackToEnglish() bool {
+func (e *IBusBambooEngine) mustFallbackToEnglish(preeditor bamboo.IEngine) bool {
 	if e.config.IBflags&IBautoNonVnRestore == 0 {
 		return false
 	}
-	var vnSeq = e.getProcessedString(bamboo.VietnameseMode | bamboo.LowerCase)
+	var vnSeq = preeditor.GetProcessedString(bamboo.VietnameseMode | bamboo.LowerCase)
 	var vnRunes = []rune(vnSeq)
 	if len(vnRunes) == 0 {
 		return false
@@ -160,14 +173,11 @@ func (e *IBusBambooEngine) mustFallbackToEnglish() bool {
 	if e.config.IBflags&IBddFreeStyle != 0 && strings.ContainsRune(vnSeq, 'đ') {
 		return false
 	}
-	if e.config.IBflags&IBspellCheckWithDicts != 0 {
-		return !dictionary[vnSeq]
-	}
-	return !e.preeditor.IsValid(true)
+	return !preeditor.IsValid(true)
 }
 
 func (e *IBusBambooEngine) getComposedString(oldText string) string {
-	if bamboo.HasAnyVietnameseRune(oldText) && e.mustFallbackToEnglish() {
+	if bamboo.HasAnyVietnameseRune(oldText) && e.mustFallbackToEnglish(e.preeditor) {
 		return e.getProcessedString(bamboo.EnglishMode)
 	}
 	return oldText
@@ -182,13 +192,31 @@ func (e *IBusBambooEngine) getProcessedString(mode bamboo.Mode) string {
 }
 
 func (e *IBusBambooEngine) getPreeditString() string {
+	return e.getPreeditStringOf(e.preeditor)
+}
+
+// getPreeditStringOf renders the word in progress of the given engine, e.g.
+// the one of the session while its lock is held
+func (e *IBusBambooEngine) getPreeditStringOf(preeditor bamboo.IEngine) string {
+	if e.config.IBflags&IBemojiShortcode != 0 {
+		// the shortnames are typed in English
+		if text := preeditor.GetProcessedString(bamboo.PunctuationMode | bamboo.EnglishMode); strings.HasPrefix(text, ":") {
+			return text
+		}
+	}
 	if e.config.IBflags&IBmacroEnabled != 0 {
-		return e.getProcessedString(bamboo.PunctuationMode)
+		return preeditor.GetProcessedString(bamboo.PunctuationMode)
 	}
-	if e.shouldFallbackToEnglish(true) {
-		return e.getProcessedString(bamboo.EnglishMode)
+	if e.config.IBflags&IBemojiShortcode != 0 {
+		if e.shouldFallbackToEnglish(preeditor, true) {
+			return preeditor.GetProcessedString(bamboo.PunctuationMode | bamboo.EnglishMode)
+		}
+		return preeditor.GetProcessedString(bamboo.PunctuationMode)
+	}
+	if e.shouldFallbackToEnglish(preeditor, true) {
+		return preeditor.GetProcessedString(bamboo.EnglishMode)
 	}
-	return e.getProcessedString(bamboo.VietnameseMode)
+	return preeditor.GetProcessedString(bamboo.VietnameseMode)
 }
 
 func (e *IBusBambooEngine) resetPreedit() {
@@ -199,9 +227,12 @@ func (e *IBusBambooEngine) resetPreedit() {
 
 func (e *IBusBambooEngine) commitPreeditAndReset(s string) {
 	e.commitText(s)
+	var text, _ = splitMacroCursor(s)
+	userFrequency.RecordText(text)
 	e.HidePreeditText()
 	e.HideAuxiliaryText()
 	e.HideLookupTable()
+	e.closeSuggestionCandidates()
 	e.preeditor.Reset()
 }
 
@@ -209,10 +240,21 @@ func (e *IBusBambooEngine) commitText(str string) {
 	if str == "" {
 		return
 	}
-	log.Printf("Commit Text [%s]\n", str)
+	var text, nLeft = splitMacroCursor(str)
+	log.Printf("Commit Text [%s]\n", text)
 	var now = time.Now()
 	e.lastCommitText = now.UnixNano()
-	e.CommitText(ibus.NewText(e.encodeText(str)))
+	e.CommitText(ibus.NewText(e.encodeText(text)))
+	e.moveCursorLeft(nLeft)
+}
+
+// moveCursorLeft moves the cursor back into a committed text, to the place of
+// the {cursor} of a macro
+func (e *IBusBambooEngine) moveCursorLeft(n int) {
+	for i := 0; i < n; i++ {
+		e.ForwardKeyEvent(IBusLeft, XkLeft-8, 0)
+		e.ForwardKeyEvent(IBusLeft, XkLeft-8, IBusReleaseMask)
+	}
 }
 
 func (e *IBusBambooEngine) getVnSeq() string {
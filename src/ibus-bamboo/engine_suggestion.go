@@ -0,0 +1,234 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/BambooEngine/bamboo-core"
+	"github.com/BambooEngine/goibus/ibus"
+)
+
+const (
+	SuggestionMaxPageSize = 9
+	MacroMaxCandidates    = 36
+	MacroPreviewMaxLen    = 40
+)
+
+func (e *IBusBambooEngine) loadSuggestionTrie() {
+	if getSuggestionTrie() != nil {
+		return
+	}
+	trie, err := loadSuggestionTrie(DictVietnameseCm, DictVietnameseCompound)
+	if err != nil {
+		log.Println("Failed to load the word suggestions:", err)
+		return
+	}
+	suggestionTrie.Store(trie)
+}
+
+// updateSuggestions shows the words starting with the pre-edit text, the
+// emoji when it is the beginning of a :shortname:, or the macros starting
+// with it
+func (e *IBusBambooEngine) updateSuggestions(preeditText string) {
+	var words, labels []string
+	if words = e.getEmojiShortcodeCandidates(preeditText); len(words) > 0 {
+		for _, glyph := range words {
+			labels = append(labels, glyph+"  "+getEmojiName(glyph))
+		}
+	} else if words = e.getMacroCandidates(); len(words) > 0 {
+		e.showSuggestions(words, e.getMacroLabels(words), IBusOrientationVertical, true)
+		return
+	} else if e.config.IBflags&IBwordSuggestion != 0 {
+		words = findSuggestions(getSuggestionTrie(), preeditText)
+		for _, word := range words {
+			labels = append(labels, e.encodeText(word))
+		}
+	}
+	if len(words) == 0 {
+		e.closeSuggestionCandidates()
+		return
+	}
+	e.showSuggestions(words, labels, IBusOrientationHorizontal, false)
+}
+
+// updateMacroSuggestions shows the macros starting with the text typed in the
+// backspace forwarding modes, which have no pre-edit
+func (e *IBusBambooEngine) updateMacroSuggestions() {
+	if e.config.IBflags&IBmacroEnabled == 0 || e.config.IBflags&IBmacroCandidates == 0 {
+		return
+	}
+	var keys = e.getMacroCandidates()
+	if len(keys) == 0 {
+		e.closeSuggestionCandidates()
+		return
+	}
+	e.showSuggestions(keys, e.getMacroLabels(keys), IBusOrientationVertical, true)
+}
+
+func (e *IBusBambooEngine) showSuggestions(words, labels []string, orientation int32, isMacro bool) {
+	lt := ibus.NewLookupTable()
+	lt.Orientation = orientation
+	for _, label := range labels {
+		lt.AppendCandidate(label)
+	}
+	lt.PageSize = uint32(SuggestionMaxPageSize)
+	if e.preeditor.CanProcessKey('1') {
+		for i := 1; i <= SuggestionMaxPageSize; i++ {
+			lt.AppendLabel("Alt+" + strconv.Itoa(i))
+		}
+	}
+	e.suggestions = words
+	e.macroSuggestion = isMacro
+	e.suggestionLookupTable = lt
+	e.isSuggestionLTOpened = true
+	e.updateSuggestionLookupTable()
+}
+
+// getMacroCandidates returns the macro keys starting with the text being typed
+// if the macro candidates are enabled
+func (e *IBusBambooEngine) getMacroCandidates() []string {
+	if e.config.IBflags&IBmacroEnabled == 0 || e.config.IBflags&IBmacroCandidates == 0 || e.macroTable == nil {
+		return nil
+	}
+	var text = e.preeditor.GetProcessedString(bamboo.PunctuationMode)
+	if text == "" {
+		return nil
+	}
+	return e.macroTable.FindKeys(text, MacroMaxCandidates)
+}
+
+func (e *IBusBambooEngine) getMacroLabels(keys []string) []string {
+	var labels []string
+	for _, key := range keys {
+		labels = append(labels, key+"  "+formatMacroPreview(e.macroTable.GetText(key)))
+	}
+	return labels
+}
+
+// formatMacroPreview shortens the text of a macro to a line of a lookup table,
+// the placeholders are shown as they are written
+func formatMacroPreview(text string) string {
+	text = strings.NewReplacer("\n", " ⏎ ", "\t", " ").Replace(unescapeMacroBraces(text))
+	var runes = []rune(text)
+	if len(runes) > MacroPreviewMaxLen {
+		return string(runes[:MacroPreviewMaxLen]) + "…"
+	}
+	return text
+}
+
+// getMacroSuggestionKey returns the key of a macro candidate written in the
+// case of the typed text, so the expansion follows that case
+func (e *IBusBambooEngine) getMacroSuggestionKey(key string) string {
+	var typed = []rune(e.preeditor.GetProcessedString(bamboo.PunctuationMode))
+	var runes = []rune(key)
+	if len(typed) > len(runes) {
+		return key
+	}
+	return string(typed) + string(runes[len(typed):])
+}
+
+// continuesMacroKey tells whether a key typed after the text still makes the
+// prefix of a macro key, it is typed rather than picking a candidate
+func (e *IBusBambooEngine) continuesMacroKey(keyRune rune) bool {
+	if !e.macroSuggestion {
+		return false
+	}
+	var text = e.preeditor.GetProcessedString(bamboo.PunctuationMode) + string(keyRune)
+	return len(e.macroTable.FindKeys(text, 1)) > 0
+}
+
+// suggestionProcessKeyEvent handles the keys which are used to pick a candidate,
+// the other keys are left to the pre-edit handler
+func (e *IBusBambooEngine) suggestionProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) (bool, bool) {
+	var keyRune = rune(keyVal)
+	if keyRune < '1' || keyRune > '9' {
+		if keyVal == IBusEscape && isValidState(state) {
+			e.closeSuggestionCandidates()
+			return true, true
+		}
+		return false, false
+	}
+	// the number keys pick a candidate, with Alt if they are typing keys,
+	// e.g. the tones of VNI
+	var isSelectionKey bool
+	if e.preeditor.CanProcessKey(keyRune) || e.continuesMacroKey(keyRune) {
+		isSelectionKey = state&IBusDefaultModMask == IBusMod1Mask
+	} else {
+		isSelectionKey = isValidState(state)
+	}
+	if isSelectionKey && e.updateCursorPosInSuggestionTable(uint32(keyRune-'1')) {
+		e.commitSuggestionCandidate()
+		return true, true
+	}
+	return false, false
+}
+
+func (e *IBusBambooEngine) updateCursorPosInSuggestionTable(idx uint32) bool {
+	pageSize := e.suggestionLookupTable.PageSize
+	if idx >= pageSize {
+		return false
+	}
+	page := e.suggestionLookupTable.CursorPos / pageSize
+	newPos := page*pageSize + idx
+	if int(newPos) >= len(e.suggestionLookupTable.Candidates) {
+		return false
+	}
+	e.suggestionLookupTable.CursorPos = newPos
+	return true
+}
+
+func (e *IBusBambooEngine) updateSuggestionLookupTable() {
+	var visible = len(e.suggestionLookupTable.Candidates) > 0
+	e.UpdateLookupTable(e.suggestionLookupTable, visible)
+}
+
+func (e *IBusBambooEngine) commitSuggestionCandidate() {
+	var pos = e.suggestionLookupTable.CursorPos
+	if pos >= uint32(len(e.suggestions)) {
+		return
+	}
+	if !e.macroSuggestion {
+		e.commitPreeditAndReset(e.suggestions[pos])
+		return
+	}
+	var text = e.expandMacro(e.getMacroSuggestionKey(e.suggestions[pos]))
+	if e.inBackspaceWhiteList() {
+		// the typed key is already in the text of the application
+		e.updatePreviousText(e.getPreeditString(), text)
+		e.preeditor.Reset()
+		e.closeSuggestionCandidates()
+		return
+	}
+	e.commitPreeditAndReset(text)
+}
+
+func (e *IBusBambooEngine) closeSuggestionCandidates() {
+	if !e.isSuggestionLTOpened {
+		return
+	}
+	e.suggestionLookupTable = nil
+	e.suggestions = nil
+	e.macroSuggestion = false
+	e.isSuggestionLTOpened = false
+	e.HideLookupTable()
+}
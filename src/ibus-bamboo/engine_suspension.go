@@ -0,0 +1,113 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BambooEngine/bamboo-core"
+	"github.com/godbus/dbus"
+)
+
+const MaxSuspendedCompositions = 20
+
+// A word in progress is suspended when the focus moves to another window and
+// resumed when the focus comes back, so that its tone placement and its key
+// strokes are not lost. The words are kept by window, two windows of the same
+// application do not share theirs. A word is only resumed if the surrounding
+// text shows that the cursor is still right after it: in the backspace
+// forwarding modes the word is already in the window and the composition is
+// resumed as it is, in the pre-edit mode the word was committed when the focus
+// moved and it is taken back into the pre-edit.
+type suspendedComposition struct {
+	snapshot    *bamboo.Snapshot
+	text        string
+	suspendedAt time.Time
+}
+
+// getInputContext identifies the focused window, the window id is unknown on Wayland
+func (e *IBusBambooEngine) getInputContext() string {
+	return e.getWmClass() + "#" + strconv.FormatUint(e.focusWindow, 16)
+}
+
+func (e *IBusBambooEngine) suspendComposition() {
+	if e.getRawKeyLen() == 0 || e.getWmClass() == "" {
+		return
+	}
+	if e.suspendedCompositions == nil {
+		e.suspendedCompositions = map[string]*suspendedComposition{}
+	}
+	if len(e.suspendedCompositions) >= MaxSuspendedCompositions {
+		var oldest string
+		for inputContext, suspended := range e.suspendedCompositions {
+			if oldest == "" || suspended.suspendedAt.Before(e.suspendedCompositions[oldest].suspendedAt) {
+				oldest = inputContext
+			}
+		}
+		delete(e.suspendedCompositions, oldest)
+	}
+	e.suspendedCompositions[e.getInputContext()] = &suspendedComposition{
+		snapshot:    e.preeditor.Snapshot(),
+		text:        e.getPreeditString(),
+		suspendedAt: time.Now(),
+	}
+}
+
+func (e *IBusBambooEngine) resumeComposition() {
+	var suspended, found = e.suspendedCompositions[e.getInputContext()]
+	if !found {
+		return
+	}
+	delete(e.suspendedCompositions, e.getInputContext())
+	if e.capabilities&IBusCapSurroundingText != 0 {
+		e.pendingResume = suspended
+	}
+}
+
+// resumeFromSurroundingText resumes the suspended word if it is right before
+// the cursor, in the pre-edit mode it is taken back into the pre-edit
+func (e *IBusBambooEngine) resumeFromSurroundingText(text dbus.Variant, cursorPos uint32) {
+	var suspended = e.pendingResume
+	e.pendingResume = nil
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println(err)
+		}
+	}()
+	var str = reflect.ValueOf(reflect.ValueOf(text.Value()).Index(2).Interface()).String()
+	var s = []rune(str)
+	if len(s) < int(cursorPos) || !strings.HasSuffix(string(s[:cursorPos]), suspended.text) {
+		return
+	}
+	if err := e.preeditor.RestoreSnapshot(suspended.snapshot); err != nil {
+		log.Println("Failed to resume the composition:", err)
+		return
+	}
+	if !e.checkInputMode(preeditIM) {
+		return
+	}
+	var n = len([]rune(suspended.text))
+	e.DeleteSurroundingText(-int32(n), uint32(n))
+	e.updatePreedit(e.getPreeditString())
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/BambooEngine/bamboo-core"
+	"github.com/godbus/dbus"
 )
 
 type keyEvent struct {
@@ -284,9 +286,454 @@ func assertEngine(t testing.TB, tc testCase, assertFn func(testing.TB, *fakeEngi
 	}
 	e := NewIbusBambooEngine(engineName, &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
 	if tc.mTable != nil {
-		e.macroTable = &MacroTable{
-			mTable: tc.mTable,
-		}
+		e.macroTable = &MacroTable{}
+		e.macroTable.setTables(0, tc.mTable, nil)
 	}
 	assertFn(t, fe, e)
 }
+
+func TestSuspendComposition(t *testing.T) {
+	for _, inputMode := range []int{backspaceForwardingIM, preeditIM} {
+		assertEngine(t, testCase{inputMode: inputMode}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.capabilities = IBusCapSurroundingText
+			e.checkWmClass("a", 1)
+			for _, c := range "tuoi" {
+				e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+			}
+			e.checkWmClass("b", 2)
+			if e.getRawKeyLen() != 0 {
+				t.Errorf("Switch to another window [%d], got [%s] expected []", inputMode, e.getPreeditString())
+			}
+			e.checkWmClass("a", 1)
+			var text = []interface{}{"IBusText", map[string]dbus.Variant{}, fe.commitText, dbus.MakeVariant("")}
+			e.SetSurroundingText(dbus.MakeVariant(text), uint32(len([]rune(fe.commitText))), 0)
+			if inputMode == preeditIM && (fe.commitText != "" || fe.preeditText != "tuoi") {
+				t.Errorf("Resume [tuoi] in the pre-edit, got commit [%s] pre-edit [%s]", fe.commitText, fe.preeditText)
+			}
+			e.preeditor.ProcessString("wf", bamboo.VietnameseMode)
+			if text := e.getPreeditString(); text != "tười" {
+				t.Errorf("Resume [tuoi] and process [wf] [%d], got [%s] expected [tười]", inputMode, text)
+			}
+		})
+	}
+}
+
+func TestSuspendCompositionSameApplication(t *testing.T) {
+	for _, inputMode := range []int{backspaceForwardingIM, preeditIM} {
+		assertEngine(t, testCase{inputMode: inputMode}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.capabilities = IBusCapSurroundingText
+			e.checkWmClass("a", 1)
+			for _, c := range "tuoi" {
+				e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+			}
+			// another window of the same application
+			e.checkWmClass("a", 2)
+			if e.getRawKeyLen() != 0 || e.pendingResume != nil {
+				t.Errorf("Switch to another window of [a] [%d], got [%s] expected no composition", inputMode, e.getPreeditString())
+			}
+			e.checkWmClass("a", 1)
+			// the text before the cursor has changed in the meantime
+			var text = []interface{}{"IBusText", map[string]dbus.Variant{}, fe.commitText + " ls", dbus.MakeVariant("")}
+			e.SetSurroundingText(dbus.MakeVariant(text), uint32(len([]rune(fe.commitText))+3), 0)
+			if e.getRawKeyLen() != 0 {
+				t.Errorf("Resume [tuoi] after other text [%d], got [%s] expected no composition", inputMode, e.getPreeditString())
+			}
+		})
+	}
+}
+
+func TestUnicodeInput(t *testing.T) {
+	var names, _ = loadUnicodeNames("../../" + DictUnicodeNames)
+	unicodeNames.Store(names)
+	var tests = []struct {
+		keys, form, expected string
+	}{
+		{"u+1ea1 ", UnicodeFormCharacter, "ạ"},
+		{"1EA1\r", UnicodeFormCodePoint, "U+1EA1"},
+		{"0x1f600 ", UnicodeFormHTML, "&#x1F600;"},
+		{"1ea1 \r", UnicodeFormCharacter, "ạ"},
+		{"em dash\r", UnicodeFormCharacter, "—"},
+		{"small a dot bel1", UnicodeFormEscape, `\u1EA1`},
+		{"u+110000 ", UnicodeFormCharacter, ""},
+	}
+	for _, test := range tests {
+		assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.config.UnicodeOutputForm = test.form
+			e.ProcessKeyEvent('u', 'u', IBusControlMask|IBusShiftMask)
+			if !e.isInHexadecimal || fe.preeditText != "u" {
+				t.Fatalf("Open the Unicode input, got pre-edit [%s] expected [u]", fe.preeditText)
+			}
+			for i, c := range test.keys {
+				var keyVal = uint32(c)
+				if c == '\r' {
+					keyVal = IBusReturn
+				}
+				e.ProcessKeyEvent(keyVal, keyVal, 0)
+				// the control characters are not previewed
+				if i == 0 && c == '1' && test.form == UnicodeFormCharacter && fe.preeditText != "u1" {
+					t.Errorf("Process [u1], got pre-edit [%s] expected [u1]", fe.preeditText)
+				}
+				if i == 5 && test.keys == "u+1ea1 " && fe.preeditText != "ạ" {
+					t.Errorf("Process [uu+1ea1], got pre-edit [%s] expected [ạ]", fe.preeditText)
+				}
+			}
+			if fe.commitText != test.expected || e.isInHexadecimal {
+				t.Errorf("Process [u%s] as [%s], got [%s] expected [%s]", test.keys, test.form, fe.commitText, test.expected)
+			}
+		})
+	}
+	// the hexadecimal words are names unless they are prefixed
+	for _, keys := range []string{"a ", "face ", "cafe "} {
+		assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.ProcessKeyEvent('u', 'u', IBusControlMask|IBusShiftMask)
+			for _, c := range keys {
+				e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+			}
+			if fe.commitText != "" || !e.isInHexadecimal || string(e.unicodeQuery) != keys {
+				t.Errorf("Process [u%s], got [%s] query [%s] expected no commit", keys, fe.commitText, string(e.unicodeQuery))
+			}
+		})
+	}
+}
+
+func TestEmojiInput(t *testing.T) {
+	loadTestEmojiIndex()
+	if err := getEmojiIndex().LoadKeywords("../../" + DictEmojiKeywordsVi); err != nil {
+		t.Fatal(err)
+	}
+	defer func(recents *EmojiRecents) { emojiRecents = recents }(emojiRecents)
+	emojiRecents = NewEmojiRecents()
+	var tests = []struct {
+		keys     string
+		skinTone int
+		expected string
+	}{
+		{"thumbsup:", 0, "👍"},
+		{"thumbsup:", 5, "👍🏿"},
+		{"trais tim\r", 0, "❤️"},
+		{"trais tinh\b\bm\r", 0, "❤️"},
+		{"grinning\r", 0, "😀"},
+		{"\t\t\tapple:", 0, "🍎"},
+		{"\tapple\r", 0, ":apple"},
+	}
+	for _, test := range tests {
+		assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.emoji = NewEmojiEngine()
+			e.config.EmojiSkinTone = test.skinTone
+			e.isEmojiLTOpened = true
+			e.openEmojiList()
+			for _, c := range test.keys {
+				var keyVal = uint32(c)
+				switch c {
+				case '\r':
+					keyVal = IBusReturn
+				case '\t':
+					keyVal = IBusTab
+				case '\b':
+					keyVal = IBusBackSpace
+				}
+				e.ProcessKeyEvent(keyVal, keyVal, 0)
+			}
+			if fe.commitText != test.expected || e.isEmojiLTOpened {
+				t.Errorf("Process [:%s], got [%s] expected [%s]", test.keys, fe.commitText, test.expected)
+			}
+		})
+	}
+	if rank := emojiRecents.Rank("😀"); rank != 1 {
+		t.Errorf("Process [recent picks], got [%d] expected [1]", rank)
+	}
+}
+
+func TestEmojiDataMissing(t *testing.T) {
+	emojiIndex.Store(NewEmojiIndex())
+	defer loadTestEmojiIndex()
+	assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		if err := e.loadEmojiData(); err == nil {
+			t.Fatalf("Load the missing emoji, got no error expected an error")
+		}
+		e.config.Shortcuts[KSEmojiDialog], e.config.Shortcuts[KSEmojiDialog+1] = IBusControlMask, '.'
+		e.ProcessKeyEvent('.', '.', IBusControlMask)
+		if e.isEmojiLTOpened {
+			t.Errorf("Process [Ctrl+.] without emoji, got the emoji dialog expected none")
+		}
+	})
+}
+
+func TestEmojiShortcode(t *testing.T) {
+	loadTestEmojiIndex()
+	var tests = []struct {
+		inputMode int
+		keys      string
+		expected  string
+	}{
+		{preeditIM, ":smile: ", "😄 "},
+		{preeditIM, "vieetj :joy: ", "việt 😂 "},
+		{preeditIM, ":smilee ", ":smilee "},
+		{preeditIM, "10:100: ", "10:100: "},
+		{surroundingTextIM, ":+1: ", "👍 "},
+		{surroundingTextIM, "10:100: ", "10:100: "},
+		{surroundingTextIM, "http:100: ", "http:100: "},
+	}
+	for _, test := range tests {
+		assertEngine(t, testCase{inputMode: test.inputMode}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			e.config.IBflags |= IBemojiShortcode
+			for _, c := range test.keys {
+				if ret, _ := e.ProcessKeyEvent(uint32(c), uint32(c), 0); !ret {
+					fe.commitText += string(c)
+				}
+			}
+			if fe.commitText != test.expected {
+				t.Errorf("Process [%s] in mode %d, got [%s] expected [%s]", test.keys, test.inputMode, fe.commitText, test.expected)
+			}
+		})
+	}
+}
+
+func TestEmojiShortcodeCandidates(t *testing.T) {
+	loadTestEmojiIndex()
+	assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.config.IBflags |= IBemojiShortcode
+		for _, c := range ":thumbsu" {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if fe.preeditText != ":thumbsu" || !e.isSuggestionLTOpened || e.suggestions[0] != "👍" {
+			t.Fatalf("Process [:thumbsu], got [%s %v] expected [:thumbsu [👍 ...]]", fe.preeditText, e.suggestions)
+		}
+		e.ProcessKeyEvent('1', '1', 0)
+		if fe.commitText != "👍" || e.isSuggestionLTOpened {
+			t.Errorf("Process [:thumbsu1], got [%s] expected [👍]", fe.commitText)
+		}
+	})
+}
+
+func TestMacroCursor(t *testing.T) {
+	assertEngine(t, testCase{inputMode: preeditIM, mTable: map[string]string{"ng": "({cursor})"}}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.macroTable.version = 2
+		for _, c := range "ng " {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if fe.commitText != "() " || fe.forwardKeyEvent != [3]uint32{IBusLeft, XkLeft - 8, IBusReleaseMask} {
+			t.Errorf("Process [ng ], got [%s %v] expected [() and Left]", fe.commitText, fe.forwardKeyEvent)
+		}
+	})
+}
+
+func TestMacroApplication(t *testing.T) {
+	assertEngine(t, testCase{inputMode: preeditIM, mTable: map[string]string{"vn": "việt nam"}}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.macroTable.setTables(0, e.macroTable.mTable, map[string]map[string]string{"ehr": {"ha": "huyết áp", "vn": ""}})
+		var tests = []struct {
+			wmClass  string
+			keys     string
+			expected string
+		}{
+			{"ehr:Ehr", "ha vn ", "huyết áp vn "},
+			{"gnome-terminal-server:Gnome-terminal", "ha vn ", "ha việt nam "},
+		}
+		for _, test := range tests {
+			e.checkWmClass(test.wmClass, 0)
+			fe.commitText = ""
+			for _, c := range test.keys {
+				e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+			}
+			if fe.commitText != test.expected {
+				t.Errorf("Process [%s] in [%s], got [%s] expected [%s]", test.keys, test.wmClass, fe.commitText, test.expected)
+			}
+		}
+	})
+}
+
+func TestApplyConfig(t *testing.T) {
+	assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		var cfg = *e.config
+		e.pendingConfig.Store(&cfg)
+		e.applyFileChanges()
+		if e.config == &cfg {
+			t.Errorf("Process [same config], got [%p] expected [%p]", e.config, &cfg)
+		}
+		var vni = *e.config
+		vni.InputMethod = "VNI"
+		e.pendingConfig.Store(&vni)
+		e.applyFileChanges()
+		if e.config != &vni {
+			t.Errorf("Process [VNI], got [%s] expected [VNI]", e.config.InputMethod)
+		}
+		e.applyFileChanges()
+		for _, c := range "a1 " {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if fe.commitText != "á " {
+			t.Errorf("Process [a1 ], got [%s] expected [á ]", fe.commitText)
+		}
+	})
+}
+
+func TestMacroCandidates(t *testing.T) {
+	var mTable = map[string]string{"vn": "Việt Nam", "vnm": "Việt Nam mến yêu", "v1": "một", "hn": "Hà Nội\n{date}"}
+	assertEngine(t, testCase{inputMode: preeditIM, mTable: mTable}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.config.IBflags |= IBmacroCandidates
+		e.config.IBflags &= ^IBautoCapitalizeMacro
+		e.macroTable.version = 2
+		e.ProcessKeyEvent('v', 'v', 0)
+		if !e.isSuggestionLTOpened || !e.macroSuggestion || strings.Join(e.suggestions, " ") != "v1 vn vnm" {
+			t.Errorf("Process [v], got [%v] expected [v1 vn vnm]", e.suggestions)
+		}
+		if label := e.getMacroLabels(e.suggestions)[2]; label != "vnm  Việt Nam mến yêu" {
+			t.Errorf("Process [label], got [%s] expected [vnm  Việt Nam mến yêu]", label)
+		}
+		// the digit continues the key v1 rather than picking a candidate
+		e.ProcessKeyEvent('1', '1', 0)
+		if fe.commitText != "" || strings.Join(e.suggestions, " ") != "v1" {
+			t.Errorf("Process [v1], got [%s %v] expected [v1]", fe.commitText, e.suggestions)
+		}
+		e.ProcessKeyEvent(IBusBackSpace, XkBackspace-8, 0)
+		e.ProcessKeyEvent('3', '3', 0)
+		if fe.commitText != "Việt Nam mến yêu" || e.isSuggestionLTOpened {
+			t.Errorf("Process [v3], got [%s] expected [Việt Nam mến yêu]", fe.commitText)
+		}
+		fe.commitText = ""
+		e.ProcessKeyEvent('h', 'h', 0)
+		if label := e.getMacroLabels(e.suggestions)[0]; label != "hn  Hà Nội ⏎ {date}" {
+			t.Errorf("Process [label], got [%s] expected [hn  Hà Nội ⏎ {date}]", label)
+		}
+		e.CandidateClicked(0, 1, 0)
+		if !strings.HasPrefix(fe.commitText, "Hà Nội\n") || strings.Contains(fe.commitText, "{date}") {
+			t.Errorf("Process [click], got [%s] expected [Hà Nội and the date]", fe.commitText)
+		}
+		fe.commitText = ""
+		e.config.IBflags &= ^IBmacroCandidates
+		e.ProcessKeyEvent('v', 'v', 0)
+		if e.isSuggestionLTOpened {
+			t.Errorf("Process [disabled], got [%v] expected no candidate", e.suggestions)
+		}
+	})
+	assertEngine(t, testCase{inputMode: backspaceForwardingIM, mTable: mTable}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.config.IBflags |= IBmacroCandidates
+		e.config.IBflags &= ^IBautoCapitalizeMacro
+		for _, c := range "vn" {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if fe.commitText != "vn" || strings.Join(e.suggestions, " ") != "vn vnm" {
+			t.Errorf("Process [vn], got [%s %v] expected [vn vn vnm]", fe.commitText, e.suggestions)
+		}
+		e.ProcessKeyEvent('2', '2', 0)
+		if fe.commitText != "vnViệt Nam mến yêu" || fe.forwardKeyEvent[0] != IBusBackSpace || e.isSuggestionLTOpened {
+			t.Errorf("Process [vn2], got [%s %v] expected [2 backspaces and Việt Nam mến yêu]", fe.commitText, fe.forwardKeyEvent)
+		}
+	})
+}
+
+func TestDiacriticRestorationMode(t *testing.T) {
+	var model, _ = loadDiacriticModel("../../"+DictVietnameseNgram, "../../"+DictVietnameseCm)
+	diacriticModel.Store(model)
+	var tests = []struct {
+		keys, expected string
+	}{
+		{"toi di hoc\r", "tôi đi học"},
+		{"toi di hoc, ko di\r", "tôi đi học, không đi"},
+		{"toi di\x1b", "toi di"},
+		{"toi dix\b hoc\r", "tôi đi học"},
+	}
+	for _, inputMode := range []int{preeditIM, backspaceForwardingIM} {
+		for _, test := range tests {
+			assertEngine(t, testCase{inputMode: inputMode, mTable: map[string]string{"ko": "không"}}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+				var e = ie.(*IBusBambooEngine)
+				e.config.IBflags |= IBdiacriticRestoration
+				for _, c := range test.keys {
+					var keyVal = uint32(c)
+					switch c {
+					case '\r':
+						keyVal = IBusReturn
+					case '\x1b':
+						keyVal = IBusEscape
+					case '\b':
+						keyVal = IBusBackSpace
+					}
+					e.ProcessKeyEvent(keyVal, keyVal, 0)
+				}
+				// the macros are expanded and the keys are not transformed
+				if fe.commitText != test.expected {
+					t.Errorf("Process [%q] in mode [%d], got [%s] expected [%s]", test.keys, inputMode, fe.commitText, test.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestWordSuggestionsVNI(t *testing.T) {
+	var trie, _ = loadSuggestionTrie("../../"+DictVietnameseCm, "../../"+DictVietnameseCompound)
+	suggestionTrie.Store(trie)
+	assertEngine(t, testCase{inputMode: preeditIM}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.config.InputMethod = "VNI"
+		e.config.IBflags |= IBwordSuggestion
+		e.preeditor.SetEngine(newPreeditor(e.config))
+		for _, c := range "vie" {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if !e.isSuggestionLTOpened {
+			t.Fatalf("Process [vie], got no suggestions expected some")
+		}
+		// the number keys are the tones of VNI
+		e.ProcessKeyEvent('6', '6', 0)
+		if fe.preeditText != "viê" || fe.commitText != "" {
+			t.Fatalf("Process [vie6], got [%s] expected [viê]", fe.preeditText)
+		}
+		var first = e.suggestions[0]
+		e.ProcessKeyEvent('1', '1', IBusMod1Mask)
+		if fe.commitText != first || e.isSuggestionLTOpened {
+			t.Errorf("Process [vie6 Alt+1], got [%s] expected [%s]", fe.commitText, first)
+		}
+	})
+}
+
+func TestRejectTypoCorrection(t *testing.T) {
+	var saved = userFrequency
+	defer func() { userFrequency = saved }()
+	for _, inputMode := range []int{backspaceForwardingIM, preeditIM} {
+		assertEngine(t, testCase{inputMode: inputMode}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+			var e = ie.(*IBusBambooEngine)
+			userFrequency, _ = newTestFrequencyTable()
+			for _, c := range "the " {
+				e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+			}
+			e.lastTypoCorrection = &typoCorrection{typed: "teh ", corrected: "the ", nBreak: 1}
+			e.ProcessKeyEvent(IBusBackSpace, XkBackspace-8, 0)
+			if e.lastTypoCorrection == nil || userFrequency.entries["teh"] != nil {
+				t.Errorf("Backspace over the word break [%d], got [%v] expected the correction to be kept", inputMode, e.lastTypoCorrection)
+			}
+			e.ProcessKeyEvent(IBusBackSpace, XkBackspace-8, 0)
+			if e.lastTypoCorrection != nil || userFrequency.entries["teh"] == nil || userFrequency.entries["teh"].Rejects != 1 {
+				t.Errorf("Backspace over the correction [%d], got [%v] expected [teh] to be rejected", inputMode, userFrequency.entries["teh"])
+			}
+		})
+	}
+}
+
+func TestMacroCursorFrequency(t *testing.T) {
+	var saved = userFrequency
+	defer func() { userFrequency = saved }()
+	userFrequency, _ = newTestFrequencyTable()
+	assertEngine(t, testCase{inputMode: preeditIM, mTable: map[string]string{"ng": "(a{cursor}b)"}}, func(t testing.TB, fe *fakeEngine, ie IEngine) {
+		var e = ie.(*IBusBambooEngine)
+		e.macroTable.version = 2
+		for _, c := range "ng " {
+			e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+		}
+		if userFrequency.entries["ab"] == nil {
+			t.Errorf("Process [ng ], got [%v] expected [ab] to be recorded", userFrequency.entries)
+		}
+	})
+}
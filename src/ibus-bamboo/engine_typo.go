@@ -0,0 +1,118 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"log"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+type typoCorrection struct {
+	typed     string
+	corrected string
+	// nBreak is the length of the key which broke the word, nBackSpace counts
+	// the backspaces typed since the correction
+	nBreak     int
+	nBackSpace int
+}
+
+// backspace counts a backspace over the corrected text, it returns true once
+// the corrected word itself is being deleted
+func (c *typoCorrection) backspace() bool {
+	c.nBackSpace++
+	return c.nBackSpace > c.nBreak
+}
+
+func (e *IBusBambooEngine) loadTypoDictionary() {
+	if getTypoDictionary() != nil {
+		return
+	}
+	dictionary, err := loadTypoDictionary(DictVietnameseCm)
+	if err != nil {
+		log.Println("Failed to load the typo dictionary:", err)
+	}
+	typoDictionary.Store(dictionary)
+}
+
+// correctTypo corrects the word being typed if neither the dictionary nor the
+// words learned from the user know it
+func (e *IBusBambooEngine) correctTypo(preeditor bamboo.IEngine) (string, bool) {
+	var word = preeditor.GetProcessedString(bamboo.VietnameseMode)
+	if userFrequency.IsWhitelisted(word) {
+		return "", false
+	}
+	var profile = bamboo.GetSpellingProfile(e.config.SpellingProfile)
+	if profile == nil {
+		profile = bamboo.StrictSpellingProfile
+	}
+	return correctTypo(getTypoDictionary(), profile, word)
+}
+
+// updateTypoCorrection keeps the last correction while the backspaces delete
+// the key which broke the word, the correction is rejected once they reach the
+// corrected word. Any other key forgets it.
+func (e *IBusBambooEngine) updateTypoCorrection(keyVal uint32) {
+	e.keyMutex.Lock()
+	defer e.keyMutex.Unlock()
+	var correction = e.lastTypoCorrection
+	if correction == nil {
+		return
+	}
+	if keyVal == IBusBackSpace && !correction.backspace() {
+		return
+	}
+	e.lastTypoCorrection = nil
+	if keyVal == IBusBackSpace {
+		rejectTypoCorrection(correction)
+	}
+}
+
+// rejectTypoCorrection counts the words which were typed, they are whitelisted
+// once they have been restored a few times
+func rejectTypoCorrection(correction *typoCorrection) {
+	for _, word := range splitWords(correction.typed) {
+		userFrequency.RecordRejection(word)
+	}
+}
+
+// undoTypoCorrection replaces the corrected or restored word with the text
+// which was typed
+func (e *IBusBambooEngine) undoTypoCorrection() {
+	var correction = e.lastTypoCorrection
+	e.lastTypoCorrection = nil
+	rejectTypoCorrection(correction)
+	e.preeditor.Reset()
+	var n = len([]rune(correction.corrected))
+	if e.inBackspaceWhiteList() {
+		e.SendBackSpace(n)
+		e.bsCommitText([]rune(correction.typed))
+		return
+	}
+	if e.capabilities&IBusCapSurroundingText != 0 {
+		e.DeleteSurroundingText(-int32(n), uint32(n))
+	} else {
+		for i := 0; i < n; i++ {
+			e.ForwardKeyEvent(IBusBackSpace, XkBackspace-8, 0)
+			e.ForwardKeyEvent(IBusBackSpace, XkBackspace-8, IBusReleaseMask)
+		}
+	}
+	e.commitText(correction.typed)
+}
@@ -21,6 +21,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"strconv"
@@ -36,23 +37,42 @@ import (
 	"github.com/godbus/dbus"
 )
 
-var dictionary = map[string]bool{}
-var emojiTrie = NewTrie()
+// dictSpellChecker and emojiIndex hold the *bamboo.DictionarySpellChecker
+// and the *EmojiIndex in use, they are replaced when the dictionaries are
+// reloaded while the keys are being processed
+var dictSpellChecker atomic.Value
+var emojiIndex atomic.Value
+var emptyEmojiIndex = NewEmojiIndex()
+
+func getDictSpellChecker() *bamboo.DictionarySpellChecker {
+	checker, _ := dictSpellChecker.Load().(*bamboo.DictionarySpellChecker)
+	return checker
+}
+
+// getEmojiIndex returns the emoji index in use, it is empty until the emoji
+// are loaded
+func getEmojiIndex() *EmojiIndex {
+	if idx, _ := emojiIndex.Load().(*EmojiIndex); idx != nil {
+		return idx
+	}
+	return emptyEmojiIndex
+}
 
 func GetIBusEngineCreator() func(*dbus.Conn, string) dbus.ObjectPath {
 	go keyPressCapturing()
+	watchDictionaries()
 
 	return func(conn *dbus.Conn, ngName string) dbus.ObjectPath {
 		var ngGroupName = strings.Split(ngName, "::")[0]
 		var engineName = strings.ToLower(ngGroupName)
 		var config = loadConfig(engineName)
 		var objectPath = dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/IBus/Engine/%s/%d", engineName, time.Now().UnixNano()))
-		var inputMethod = bamboo.ParseInputMethod(config.InputMethodDefinitions, config.InputMethod)
 		baseEngine := ibus.BaseEngine(conn, objectPath)
-		var engine = NewIbusBambooEngine(engineName, loadConfig(engineName), &baseEngine, bamboo.NewEngine(inputMethod, config.Flags))
+		var engine = NewIbusBambooEngine(engineName, loadConfig(engineName), &baseEngine, newPreeditor(config))
 		engine.propList = GetPropListByConfig(config)
 		engine.shouldEnqueuKeyStrokes = true
 		ibus.PublishEngine(conn, objectPath, engine)
+		engine.watchConfig()
 		if *gui {
 			engine.openShortcutsGUI()
 			saveConfig(engine.config, engine.engineName)
@@ -66,6 +86,35 @@ func GetIBusEngineCreator() func(*dbus.Conn, string) dbus.ObjectPath {
 
 const KeypressDelayMs = 10
 
+func newPreeditor(cfg *Config) bamboo.IEngine {
+	var inputMethod = bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	var preeditor = bamboo.NewEngineWithSpellChecker(inputMethod, cfg.Flags, getSpellChecker(cfg))
+	preeditor.SetSpellingProfile(bamboo.GetSpellingProfile(cfg.SpellingProfile))
+	return preeditor
+}
+
+// getSpellChecker returns the dictionary checker if it is enabled, otherwise the rules-based one,
+// the words which are whitelisted by the user are always accepted
+func getSpellChecker(cfg *Config) bamboo.SpellChecker {
+	var checker = bamboo.NewRulesSpellCheckerWithProfile(bamboo.GetSpellingProfile(cfg.SpellingProfile))
+	if cfg.IBflags&IBspellCheckWithDicts != 0 {
+		var dictChecker = getDictSpellChecker()
+		if dictChecker == nil {
+			words, err := loadDictionary(DictVietnameseCm)
+			if err != nil {
+				log.Println(err)
+			}
+			dictChecker = bamboo.NewDictionarySpellChecker(words)
+			dictSpellChecker.Store(dictChecker)
+		}
+		checker = dictChecker
+	}
+	var userChecker = bamboo.SpellCheckerFunc(func(composition []*bamboo.Transformation, inputIsFullComplete bool) bool {
+		return userFrequency.IsWhitelisted(bamboo.Flatten(composition, bamboo.VietnameseMode|bamboo.LowerCase))
+	})
+	return bamboo.ChainSpellCheckers(checker, userChecker)
+}
+
 func (e *IBusBambooEngine) isShortcutKeyEnable(ski uint) bool {
 	if int(ski+2) > len(e.config.Shortcuts) {
 		return false
@@ -78,10 +127,15 @@ func (e *IBusBambooEngine) init() {
 	e.emoji = NewEmojiEngine()
 	if e.macroTable == nil {
 		e.macroTable = NewMacroTable(e.config.IBflags&IBautoCapitalizeMacro != 0)
+		e.macroTable.teamFile = e.config.MacroTeamFile
+		e.macroTable.SetApplication(e.getWmClass())
 		if e.config.IBflags&IBmacroEnabled != 0 {
 			e.macroTable.Enable(e.engineName)
 		}
 	}
+	if e.config.IBflags&IBfrequencyLearning != 0 {
+		userFrequency.Enable(e.engineName)
+	}
 	keyPressHandler = e.keyPressForwardHandler
 
 	if e.config.IBflags&IBmouseCapturing != 0 {
@@ -142,6 +196,15 @@ var sleep = func() {
 }
 
 func (e *IBusBambooEngine) resetBuffer() {
+	e.keyMutex.Lock()
+	e.lastTypoCorrection = nil
+	e.keyMutex.Unlock()
+	if e.config.IBflags&IBdiacriticRestoration != 0 {
+		// the words are in the pre-edit whatever the input mode
+		if text := e.getDiacriticText(); text != "" {
+			e.commitDiacriticText(text)
+		}
+	}
 	if e.getRawKeyLen() == 0 {
 		return
 	}
@@ -152,11 +215,17 @@ func (e *IBusBambooEngine) resetBuffer() {
 	}
 }
 
-func (e *IBusBambooEngine) checkWmClass(newId string) {
-	if e.wmClasses != newId {
+func (e *IBusBambooEngine) checkWmClass(newId string, window uint64) {
+	if e.wmClasses != newId || e.focusWindow != window {
+		e.suspendComposition()
 		e.wmClasses = newId
+		e.focusWindow = window
+		if e.macroTable != nil {
+			e.macroTable.SetApplication(newId)
+		}
 		e.resetBuffer()
 		e.resetFakeBackspace()
+		e.resumeComposition()
 	}
 }
 
@@ -180,8 +249,9 @@ func (e *IBusBambooEngine) processShortcutKey(keyVal, keyCode, state uint32) (bo
 		return true, false
 	}
 	// fmt.Println("===Process shortcut for emoji selector")
+	// the emoji dialog is not opened without emoji
 	if e.isShortcutKeyPressed(keyVal, state, KSEmojiDialog) &&
-		!e.isEmojiLTOpened {
+		!e.isEmojiLTOpened && getEmojiIndex().Len() > 0 {
 		e.resetBuffer()
 		e.isEmojiLTOpened = true
 		e.lastKeyWithShift = true
@@ -211,6 +281,14 @@ func (e *IBusBambooEngine) processShortcutKey(keyVal, keyCode, state uint32) (bo
 		return true, false
 	}
 	if e.isShortcutKeyPressed(keyVal, state, KSRestoreKeyStrokes) {
+		e.keyMutex.Lock()
+		defer e.keyMutex.Unlock()
+		// the correction is forgotten by the next key, so the composition may
+		// only hold the key which broke the word
+		if e.lastTypoCorrection != nil && e.lastTypoCorrection.nBackSpace == 0 {
+			e.undoTypoCorrection()
+			return true, true
+		}
 		// fmt.Println("===== Process restoring key strokes")
 		e.shouldRestoreKeyStrokes = true
 		return false, false
@@ -247,10 +325,14 @@ func (e *IBusBambooEngine) processShortcutKey(keyVal, keyCode, state uint32) (bo
 		e.updateLastKeyWithShift(keyVal, state)
 		return true, false
 	}
+	if e.config.IBflags&IBdiacriticRestoration != 0 && e.isShortcutKeyPressed(keyVal, state, KSDiacriticRestoration) {
+		e.showDiacriticCandidates()
+		return true, true
+	}
 	return false, false
 }
 
-func (e *IBusBambooEngine) toUpper(keyRune rune) rune {
+func (e *IBusBambooEngine) toUpper(preeditor bamboo.IEngine, keyRune rune) rune {
 	var keyMapping = map[rune]rune{
 		'[': '{',
 		']': '}',
@@ -258,7 +340,7 @@ func (e *IBusBambooEngine) toUpper(keyRune rune) rune {
 		'}': ']',
 	}
 
-	if upperSpecialKey, found := keyMapping[keyRune]; found && inKeyList(e.preeditor.GetInputMethod().AppendingKeys, keyRune) {
+	if upperSpecialKey, found := keyMapping[keyRune]; found && inKeyList(preeditor.GetInputMethod().AppendingKeys, keyRune) {
 		keyRune = upperSpecialKey
 	}
 	return keyRune
@@ -372,7 +454,7 @@ func (e *IBusBambooEngine) commitInputModeCandidate() {
 	var im = e.inputModeLookupTable.CursorPos + 1
 	e.config.InputModeMapping[e.getWmClass()] = int(im)
 
-	saveConfig(e.config, e.engineName)
+	e.saveConfig()
 	e.propList = GetPropListByConfig(e.config)
 	e.RegisterProperties(e.propList)
 }
@@ -403,104 +485,122 @@ func isValidState(state uint32) bool {
 	return true
 }
 
-func (e *IBusBambooEngine) getCommitText(keyVal, keyCode, state uint32) (newText string, IsWordBreakSymbol bool) {
+func (e *IBusBambooEngine) getCommitText(preeditor bamboo.IEngine, keyVal, keyCode, state uint32) (newText string, IsWordBreakSymbol bool) {
 	var keyRune = rune(keyVal)
-	isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
-	oldText := e.getPreeditString()
+	isValidKey := isValidState(state) && e.isValidKeyVal(preeditor, keyVal)
+	oldText := e.getPreeditStringOf(preeditor)
 	// restore key strokes by pressing Shift + Space
 	if e.shouldRestoreKeyStrokes {
 		e.shouldRestoreKeyStrokes = false
-		e.preeditor.RestoreLastWord(!bamboo.HasAnyVietnameseRune(oldText))
-		return e.getPreeditString(), false
+		preeditor.RestoreLastWord(!bamboo.HasAnyVietnameseRune(oldText))
+		return e.getPreeditStringOf(preeditor), false
 	}
 	var keyS string
 	if isValidKey {
 		keyS = string(keyRune)
 	}
-	if isValidKey && e.preeditor.CanProcessKey(keyRune) {
+	if isValidKey && preeditor.CanProcessKey(keyRune) {
 		if state&IBusLockMask != 0 {
-			keyRune = e.toUpper(keyRune)
+			keyRune = e.toUpper(preeditor, keyRune)
 		}
-		e.preeditor.ProcessKey(keyRune, e.getBambooInputMode())
-		if inKeyList(e.preeditor.GetInputMethod().AppendingKeys, keyRune) {
+		preeditor.ProcessKey(keyRune, e.getBambooInputMode(preeditor))
+		if inKeyList(preeditor.GetInputMethod().AppendingKeys, keyRune) {
 			var newText string
-			if e.shouldFallbackToEnglish(true) {
-				newText = e.getProcessedString(bamboo.EnglishMode)
+			if e.shouldFallbackToEnglish(preeditor, true) {
+				newText = preeditor.GetProcessedString(bamboo.EnglishMode)
 			} else {
-				newText = e.getProcessedString(bamboo.VietnameseMode)
+				newText = preeditor.GetProcessedString(bamboo.VietnameseMode)
 			}
-			if fullSeq := e.preeditor.GetProcessedString(bamboo.VietnameseMode); len(fullSeq) > 0 && rune(fullSeq[len(fullSeq)-1]) == keyRune {
+			if fullSeq := preeditor.GetProcessedString(bamboo.VietnameseMode); len(fullSeq) > 0 && rune(fullSeq[len(fullSeq)-1]) == keyRune {
 				// [[ => [
-				var ret = e.getPreeditString()
+				var ret = e.getPreeditStringOf(preeditor)
 				var lastRune = rune(ret[len(ret)-1])
 				var isWordBreakRune = bamboo.IsWordBreakSymbol(lastRune)
 				// TODO: THIS IS A HACK
 				if isWordBreakRune {
-					e.preeditor.RemoveLastChar(false)
-					e.preeditor.ProcessKey(' ', bamboo.EnglishMode)
+					preeditor.RemoveLastChar(false)
+					preeditor.ProcessKey(' ', bamboo.EnglishMode)
 				}
 				return ret, isWordBreakRune
 			} else if l := []rune(newText); len(l) > 0 && keyRune == l[len(l)-1] {
 				// f] => f]
 				var isWordBreakRune = bamboo.IsWordBreakSymbol(keyRune)
 				if isWordBreakRune {
-					e.preeditor.RemoveLastChar(false)
-					e.preeditor.ProcessKey(' ', bamboo.EnglishMode)
+					preeditor.RemoveLastChar(false)
+					preeditor.ProcessKey(' ', bamboo.EnglishMode)
 				}
 				return oldText + string(keyRune), isWordBreakRune
 			} else {
 				// ] => o?
-				return e.getPreeditString(), false
+				return e.getPreeditStringOf(preeditor), false
 			}
 		} else if e.config.IBflags&IBmacroEnabled != 0 {
-			return e.getProcessedString(bamboo.PunctuationMode), false
+			return preeditor.GetProcessedString(bamboo.PunctuationMode), false
 		} else {
-			return e.getPreeditString(), false
+			return e.getPreeditStringOf(preeditor), false
 		}
-	} else if e.config.IBflags&IBmacroEnabled != 0 {
+	} else if e.shouldKeepPunctuation() {
 		// macro processing
-		if e.macroTable.HasKey(oldText) {
+		if e.config.IBflags&IBmacroEnabled != 0 && e.macroTable.HasKey(oldText) {
 			return e.expandMacro(oldText) + keyS, true
 		}
 		// in macro, special characters except space are still treated as not WBS
 		// in order to support the macro ( -->:arrow )
 		isWordBreakSymbol := !isValidKey || keyVal == IBusSpace
-		return e.handleNonVnWord(keyVal, keyCode, state, isWordBreakSymbol)
+		if glyph, ok := e.getEmojiShortcode(oldText); ok && isWordBreakSymbol {
+			return glyph + keyS, true
+		}
+		return e.handleNonVnWord(preeditor, keyVal, keyCode, state, isWordBreakSymbol)
 	}
-	return e.handleNonVnWord(keyVal, keyCode, state, true)
+	return e.handleNonVnWord(preeditor, keyVal, keyCode, state, true)
 }
 
-func (e *IBusBambooEngine) handleNonVnWord(keyVal, keyCode, state uint32, isWordBreakSymbol bool) (string, bool) {
+func (e *IBusBambooEngine) handleNonVnWord(preeditor bamboo.IEngine, keyVal, keyCode, state uint32, isWordBreakSymbol bool) (string, bool) {
 	var (
 		keyS       string
 		keyRune    = rune(keyVal)
-		isValidKey = isValidState(state) && e.isValidKeyVal(keyVal)
-		oldText    = e.getPreeditString()
+		isValidKey = isValidState(state) && e.isValidKeyVal(preeditor, keyVal)
+		oldText    = e.getPreeditStringOf(preeditor)
 	)
 	if isValidKey {
 		keyS = string(keyRune)
 	}
-	if isWordBreakSymbol && bamboo.HasAnyVietnameseRune(oldText) && e.mustFallbackToEnglish() {
-		e.preeditor.RestoreLastWord(false)
-		newText := e.preeditor.GetProcessedString(bamboo.PunctuationMode|bamboo.EnglishMode) + keyS
+	if isWordBreakSymbol && bamboo.HasAnyVietnameseRune(oldText) && e.mustFallbackToEnglish(preeditor) {
+		preeditor.RestoreLastWord(false)
+		newText := preeditor.GetProcessedString(bamboo.PunctuationMode|bamboo.EnglishMode) + keyS
 		if isValidKey {
-			e.preeditor.ProcessKey(keyRune, bamboo.EnglishMode)
+			preeditor.ProcessKey(keyRune, bamboo.EnglishMode)
 		}
+		// the restore can be undone like a typo correction
+		e.lastTypoCorrection = &typoCorrection{typed: oldText + keyS, corrected: newText, nBreak: len([]rune(keyS))}
 		return newText, true
 	}
+	if isWordBreakSymbol && e.config.IBflags&IBtypoCorrection != 0 {
+		if corrected, ok := e.correctTypo(preeditor); ok {
+			e.lastTypoCorrection = &typoCorrection{typed: oldText + keyS, corrected: corrected + keyS, nBreak: len([]rune(keyS))}
+			return corrected + keyS, true
+		}
+	}
 	if isValidKey {
-		e.preeditor.ProcessKey(keyRune, bamboo.EnglishMode)
+		preeditor.ProcessKey(keyRune, bamboo.EnglishMode)
 		return oldText + keyS, isWordBreakSymbol
 	}
 	// Ctrl + A is treasted as a WBS
 	return oldText + keyS, true
 }
 
-func (e *IBusBambooEngine) getMacroText() (bool, string) {
+// shouldKeepPunctuation reports whether the special characters are kept in the
+// word in progress, the macros and the emoji shortnames may contain them and
+// a colon must not start a shortname in the middle of a time or a URL
+func (e *IBusBambooEngine) shouldKeepPunctuation() bool {
+	return e.config.IBflags&(IBmacroEnabled|IBemojiShortcode) != 0
+}
+
+func (e *IBusBambooEngine) getMacroText(preeditor bamboo.IEngine) (bool, string) {
 	if e.config.IBflags&IBmacroEnabled == 0 {
 		return false, ""
 	}
-	var text = e.preeditor.GetProcessedString(bamboo.PunctuationMode)
+	var text = preeditor.GetProcessedString(bamboo.PunctuationMode)
 	if e.macroTable.HasKey(text) {
 		return true, e.expandMacro(text)
 	}
@@ -519,15 +619,15 @@ func (e *IBusBambooEngine) addFakeBackspace(n int32) {
 	atomic.AddInt32(&e.nFakeBackSpace, n)
 }
 
-func (e *IBusBambooEngine) isValidKeyVal(keyVal uint32) bool {
+func (e *IBusBambooEngine) isValidKeyVal(preeditor bamboo.IEngine, keyVal uint32) bool {
 	var keyRune = rune(keyVal)
 	if keyVal == IBusBackSpace || bamboo.IsWordBreakSymbol(keyRune) {
 		return true
 	}
-	if ok, _ := e.getMacroText(); ok && keyVal == IBusTab {
+	if ok, _ := e.getMacroText(preeditor); ok && keyVal == IBusTab {
 		return true
 	}
-	return e.preeditor.CanProcessKey(keyRune)
+	return preeditor.CanProcessKey(keyRune)
 }
 
 func (e *IBusBambooEngine) inBackspaceWhiteList() bool {
@@ -562,6 +662,13 @@ func (e *IBusBambooEngine) getLatestWmClass() string {
 	return wmClass
 }
 
+func (e *IBusBambooEngine) getLatestFocusWindow() uint64 {
+	if isWayland {
+		return 0
+	}
+	return x11GetFocusWindow()
+}
+
 func (e *IBusBambooEngine) checkInputMode(im int) bool {
 	return e.getInputMode() == im
 }
@@ -573,6 +680,10 @@ func notify(enMode bool) {
 		title = "English"
 		msg = "Press Shortcut keys to switch input language"
 	}
+	notifyMessage(title, msg)
+}
+
+func notifyMessage(title, msg string) {
 	conn, err := dbus.SessionBus()
 	if err != nil {
 		fmt.Println(err)
@@ -586,6 +697,25 @@ func notify(enMode bool) {
 	}
 }
 
+// convertClipboardCharset rewrites the text of the clipboard in the target charset,
+// the clipboard is read in the background since its owner may be slow to answer
+func (e *IBusBambooEngine) convertClipboardCharset() {
+	var from, to = e.config.ClipboardSourceCharset, e.config.ClipboardTargetCharset
+	go func() {
+		var text = x11GetClipboardText()
+		if text == "" {
+			log.Println("Clipboard is empty or cannot be read")
+			return
+		}
+		var output, ok = convertCharset(from, to, text)
+		if !ok {
+			log.Println("Clipboard is in the target charset already")
+			return
+		}
+		x11Copy(output)
+	}()
+}
+
 func (e *IBusBambooEngine) openShortcutsGUI() {
 	cmd := exec.Command("/usr/lib/ibus-bamboo/keyboard-shortcut-editor", e.getShortcutString(), strconv.Itoa(e.config.DefaultInputMode))
 	cmd.Env = os.Environ()
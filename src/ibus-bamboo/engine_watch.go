@@ -0,0 +1,185 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/BambooEngine/bamboo-core"
+	"github.com/godbus/dbus"
+)
+
+// dictionaryChanges counts the changes of the dictionary files, each engine
+// picks up the new dictionaries on its next focus
+var dictionaryChanges int32
+var loadedDictionaryChanges int32
+var dictionaryMutex sync.Mutex
+
+// watchDictionaries watches the dictionary files for the life of the process
+func watchDictionaries() {
+	var files = []string{DictVietnameseCm, DictVietnameseCompound, DictVietnameseNgram,
+		DictEmojiOne, DictEmojiKeywordsVi, DictUnicodeNames}
+	for _, fileName := range files {
+		fileWatcher.Watch(getDataFile(fileName), func() {
+			atomic.AddInt32(&dictionaryChanges, 1)
+		})
+	}
+}
+
+// reloadDictionaries replaces the dictionaries which are in use by the ones
+// read again from their files, the old ones stay in use until the new ones
+// are ready or if they cannot be read
+func reloadDictionaries(changes int32) {
+	dictionaryMutex.Lock()
+	defer dictionaryMutex.Unlock()
+	if loadedDictionaryChanges == changes {
+		return
+	}
+	loadedDictionaryChanges = changes
+	log.Println("Reload the dictionaries")
+	if getSuggestionTrie() != nil {
+		if trie, err := loadSuggestionTrie(getDataFile(DictVietnameseCm), getDataFile(DictVietnameseCompound)); err == nil {
+			suggestionTrie.Store(trie)
+		}
+	}
+	if getTypoDictionary() != nil {
+		if words, err := loadTypoDictionary(getDataFile(DictVietnameseCm)); err == nil {
+			typoDictionary.Store(words)
+		}
+	}
+	if getDiacriticModel() != nil {
+		if model, err := loadDiacriticModel(getDataFile(DictVietnameseNgram), getDataFile(DictVietnameseCm), getDataFile(DictVietnameseCompound)); err == nil {
+			diacriticModel.Store(model)
+		}
+	}
+	if getDictSpellChecker() != nil {
+		if words, err := loadDictionary(getDataFile(DictVietnameseCm)); err == nil {
+			dictSpellChecker.Store(bamboo.NewDictionarySpellChecker(words))
+		}
+	}
+	if getUnicodeNames() != nil {
+		if names, err := loadUnicodeNames(getDataFile(DictUnicodeNames)); err == nil {
+			unicodeNames.Store(names)
+		}
+	}
+	if getEmojiIndex().Len() > 0 {
+		if idx, err := loadEmojiOne(getDataFile(DictEmojiOne)); err == nil {
+			if err = idx.LoadKeywords(getDataFile(DictEmojiKeywordsVi)); err != nil {
+				log.Println("Failed to load the Vietnamese keywords of the emoji:", err)
+			}
+			emojiIndex.Store(idx)
+		}
+	}
+}
+
+// watchConfig reads the config file again when it changes, the new config is
+// applied on the next focus so a word is never typed with two configs
+func (e *IBusBambooEngine) watchConfig() {
+	var configPath = getConfigPath(e.engineName)
+	e.stopConfigWatch = fileWatcher.Watch(configPath, func() {
+		if saved, _ := e.savedConfig.Load().(os.FileInfo); saved != nil {
+			if info, err := os.Stat(configPath); err == nil && isSameFileVersion(info, saved) {
+				return
+			}
+		}
+		cfg, err := readConfig(e.engineName)
+		if err != nil {
+			log.Println("Failed to reload the config:", err)
+			return
+		}
+		e.pendingConfig.Store(cfg)
+	})
+}
+
+// saveConfig saves the config of the engine, a config which was read from the
+// file before is dropped so that it does not undo the change on the next focus.
+// The written file is remembered so that the watcher skips it
+func (e *IBusBambooEngine) saveConfig() {
+	e.pendingConfig.Store((*Config)(nil))
+	if info := saveConfig(e.config, e.engineName); info != nil {
+		e.savedConfig.Store(info)
+	}
+}
+
+// isSameFileVersion tells if two infos are of the same file, which was not
+// written in between: saveConfig replaces the file, so its inode changes
+func isSameFileVersion(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// applyFileChanges applies the config and the dictionaries which were changed
+// since the last focus
+func (e *IBusBambooEngine) applyFileChanges() {
+	if cfg, _ := e.pendingConfig.Load().(*Config); cfg != nil && e.pendingConfig.CompareAndSwap(cfg, (*Config)(nil)) {
+		e.applyConfig(cfg)
+	}
+	if changes := atomic.LoadInt32(&dictionaryChanges); changes != e.dictionaryChanges {
+		e.dictionaryChanges = changes
+		reloadDictionaries(changes)
+		e.preeditor.SetEngine(newPreeditor(e.config))
+	}
+}
+
+// applyConfig replaces the config of the engine, an unchanged config is skipped
+func (e *IBusBambooEngine) applyConfig(cfg *Config) {
+	if reflect.DeepEqual(cfg, e.config) {
+		return
+	}
+	log.Println("Apply the config of", getConfigPath(e.engineName))
+	var old = e.config
+	var changed = func(flags uint) bool {
+		return (old.IBflags^cfg.IBflags)&flags != 0
+	}
+	e.config = cfg
+	if e.macroTable != nil {
+		if cfg.IBflags&IBmacroEnabled == 0 {
+			if changed(IBmacroEnabled) {
+				e.macroTable.Disable()
+			}
+		} else if changed(IBmacroEnabled|IBautoCapitalizeMacro) || old.MacroTeamFile != cfg.MacroTeamFile {
+			e.macroTable.teamFile = cfg.MacroTeamFile
+			e.macroTable.Reload(e.engineName, cfg.IBflags&IBautoCapitalizeMacro != 0)
+		}
+	}
+	if changed(IBfrequencyLearning) {
+		if cfg.IBflags&IBfrequencyLearning != 0 {
+			userFrequency.Enable(e.engineName)
+		} else {
+			userFrequency.Disable()
+		}
+	}
+	e.propList = GetPropListByConfig(cfg)
+	e.preeditor.SetEngine(newPreeditor(cfg))
+}
+
+// Destroy stops the watches of the engine before it is removed from the bus
+func (e *IBusBambooEngine) Destroy() *dbus.Error {
+	if e.stopConfigWatch != nil {
+		e.stopConfigWatch()
+	}
+	if e.macroTable != nil {
+		e.macroTable.Disable()
+	}
+	return e.IEngine.Destroy()
+}
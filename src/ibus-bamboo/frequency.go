@@ -0,0 +1,230 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	frequencyFile = "%s/ibus-%s.frequency.json"
+
+	FrequencyMaxEntries       = 10000
+	FrequencyHalfLife         = 30 * 24 * time.Hour
+	FrequencyWhitelistRejects = 3
+	frequencySaveInterval     = 20
+)
+
+var userFrequency = NewFrequencyTable()
+
+type FrequencyEntry struct {
+	Count     float64 `json:"c"`
+	Rejects   int     `json:"r,omitempty"`
+	UpdatedAt int64   `json:"t"`
+}
+
+// FrequencyTable remembers how often the user commits each word. The counts
+// decay over time so the words that have not been typed for a long time fade
+// out, and the table never keeps more than FrequencyMaxEntries words.
+type FrequencyTable struct {
+	sync.RWMutex
+	enable   bool
+	fileName string
+	nChanges int
+	entries  map[string]*FrequencyEntry
+	now      func() time.Time
+}
+
+func NewFrequencyTable() *FrequencyTable {
+	return &FrequencyTable{entries: map[string]*FrequencyEntry{}, now: time.Now}
+}
+
+func getFrequencyFile(engineName string) string {
+	return fmt.Sprintf(frequencyFile, getConfigDir(engineName), engineName)
+}
+
+func (e *FrequencyTable) Enable(engineName string) {
+	e.Lock()
+	defer e.Unlock()
+	e.enable = true
+	e.fileName = getFrequencyFile(engineName)
+	if err := e.loadFromFile(e.fileName); err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+// Disable stops the learning and removes all the collected data
+func (e *FrequencyTable) Disable() {
+	e.Lock()
+	defer e.Unlock()
+	e.enable = false
+	e.entries = map[string]*FrequencyEntry{}
+	e.nChanges = 0
+	if e.fileName != "" {
+		os.Remove(e.fileName)
+	}
+}
+
+func (e *FrequencyTable) loadFromFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var entries = map[string]*FrequencyEntry{}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	e.entries = entries
+	return nil
+}
+
+func (e *FrequencyTable) Save() {
+	e.Lock()
+	defer e.Unlock()
+	e.save()
+}
+
+func (e *FrequencyTable) save() {
+	if !e.enable || e.fileName == "" || e.nChanges == 0 {
+		return
+	}
+	data, err := json.Marshal(e.entries)
+	if err != nil {
+		return
+	}
+	var tmpFile = e.fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		log.Println(err)
+		return
+	}
+	if err = os.Rename(tmpFile, e.fileName); err != nil {
+		log.Println(err)
+		return
+	}
+	e.nChanges = 0
+}
+
+func (e *FrequencyTable) decayedCount(entry *FrequencyEntry, now time.Time) float64 {
+	var age = now.Sub(time.Unix(entry.UpdatedAt, 0))
+	if age <= 0 {
+		return entry.Count
+	}
+	return entry.Count * math.Pow(0.5, float64(age)/float64(FrequencyHalfLife))
+}
+
+func (e *FrequencyTable) record(word string, rejected bool) {
+	word = strings.ToLower(word)
+	var now = e.now()
+	var entry = e.entries[word]
+	if entry == nil {
+		entry = &FrequencyEntry{}
+		e.entries[word] = entry
+	}
+	if rejected {
+		entry.Rejects++
+	} else {
+		entry.Count = e.decayedCount(entry, now) + 1
+	}
+	entry.UpdatedAt = now.Unix()
+	if len(e.entries) > FrequencyMaxEntries {
+		e.prune(now)
+	}
+	e.nChanges++
+	if e.nChanges >= frequencySaveInterval {
+		e.save()
+	}
+}
+
+// prune drops the least used words until the table is 10% under its capacity
+func (e *FrequencyTable) prune(now time.Time) {
+	var words = make([]string, 0, len(e.entries))
+	var scores = make(map[string]float64, len(e.entries))
+	for word, entry := range e.entries {
+		words = append(words, word)
+		scores[word] = e.decayedCount(entry, now) + float64(entry.Rejects)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		return scores[words[i]] < scores[words[j]]
+	})
+	for _, word := range words[:len(words)-FrequencyMaxEntries*9/10] {
+		delete(e.entries, word)
+	}
+}
+
+// RecordText counts all the words in a committed text
+func (e *FrequencyTable) RecordText(text string) {
+	e.Lock()
+	defer e.Unlock()
+	if !e.enable {
+		return
+	}
+	for _, word := range splitWords(text) {
+		e.record(word, false)
+	}
+}
+
+// RecordRejection counts a word which is rejected by the spell checker
+func (e *FrequencyTable) RecordRejection(word string) {
+	e.Lock()
+	defer e.Unlock()
+	if !e.enable || word == "" {
+		return
+	}
+	e.record(word, true)
+}
+
+func (e *FrequencyTable) Score(word string) float64 {
+	e.RLock()
+	defer e.RUnlock()
+	if entry, ok := e.entries[strings.ToLower(word)]; ok {
+		return e.decayedCount(entry, e.now())
+	}
+	return 0
+}
+
+// IsWhitelisted reports whether the user has insisted on typing the word
+// although the spell checker did not accept it
+func (e *FrequencyTable) IsWhitelisted(word string) bool {
+	e.RLock()
+	defer e.RUnlock()
+	if !e.enable {
+		return false
+	}
+	if entry, ok := e.entries[strings.ToLower(word)]; ok {
+		return entry.Rejects >= FrequencyWhitelistRejects
+	}
+	return false
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFrequencyTable() (*FrequencyTable, *time.Time) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ft = NewFrequencyTable()
+	ft.enable = true
+	ft.now = func() time.Time {
+		return now
+	}
+	return ft, &now
+}
+
+func TestFrequencyRecordText(t *testing.T) {
+	var ft, _ = newTestFrequencyTable()
+	ft.RecordText("Việt Nam, việt nam!")
+	if ft.Score("việt") != 2 {
+		t.Errorf("Score of việt, expected 2, got %v", ft.Score("việt"))
+	}
+	if ft.Score("Nam") != 2 {
+		t.Errorf("Score of Nam, expected 2, got %v", ft.Score("Nam"))
+	}
+	if ft.Score(",") != 0 {
+		t.Errorf("Score of `,`, expected 0, got %v", ft.Score(","))
+	}
+}
+
+func TestFrequencyDecay(t *testing.T) {
+	var ft, now = newTestFrequencyTable()
+	ft.RecordText("tôi tôi")
+	*now = now.Add(FrequencyHalfLife)
+	if ft.Score("tôi") != 1 {
+		t.Errorf("Score of tôi after a half-life, expected 1, got %v", ft.Score("tôi"))
+	}
+	ft.RecordText("tôi")
+	if ft.Score("tôi") != 2 {
+		t.Errorf("Score of tôi, expected 2, got %v", ft.Score("tôi"))
+	}
+}
+
+func TestFrequencyCapacity(t *testing.T) {
+	var ft, _ = newTestFrequencyTable()
+	for i := 0; i < 3; i++ {
+		ft.RecordText("thường")
+	}
+	for i := 0; i < FrequencyMaxEntries; i++ {
+		ft.RecordText(string([]rune{'a' + rune(i%26), 'a' + rune(i/26%26), 'a' + rune(i/676)}))
+	}
+	if len(ft.entries) > FrequencyMaxEntries {
+		t.Errorf("Frequency table size, expected <= %d, got %d", FrequencyMaxEntries, len(ft.entries))
+	}
+	if ft.Score("thường") != 3 {
+		t.Errorf("Score of thường, expected 3, got %v", ft.Score("thường"))
+	}
+}
+
+func TestFrequencyWhitelist(t *testing.T) {
+	var ft, _ = newTestFrequencyTable()
+	for i := 0; i < FrequencyWhitelistRejects; i++ {
+		if ft.IsWhitelisted("đắk") {
+			t.Errorf("IsWhitelisted(đắk) after %d rejections, expected false", i)
+		}
+		ft.RecordRejection("Đắk")
+	}
+	if !ft.IsWhitelisted("đắk") {
+		t.Errorf("IsWhitelisted(đắk), expected true")
+	}
+}
+
+func TestFrequencySaveAndDisable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibus-bamboo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var ft, _ = newTestFrequencyTable()
+	ft.fileName = filepath.Join(dir, "frequency.json")
+	ft.RecordText("xin chào")
+	ft.Save()
+
+	var ft2, _ = newTestFrequencyTable()
+	if err = ft2.loadFromFile(ft.fileName); err != nil {
+		t.Fatal(err)
+	}
+	if ft2.Score("chào") != 1 {
+		t.Errorf("Score of chào after loading, expected 1, got %v", ft2.Score("chào"))
+	}
+	ft.Disable()
+	if _, err = os.Stat(ft.fileName); !os.IsNotExist(err) {
+		t.Errorf("Frequency file after disabling, expected removed, got %v", err)
+	}
+	ft.RecordText("xin chào")
+	if ft.Score("chào") != 0 {
+		t.Errorf("Score of chào after disabling, expected 0, got %v", ft.Score("chào"))
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+const (
+	imEditorPath      = "/usr/lib/ibus-bamboo/input-method-editor"
+	imEditorLocalPath = "./input-method-editor"
+)
+
+// The input method editor exchanges the definitions as text, one section per
+// input method:
+//
+//	[Telex]
+//	a	A_Â
+//	s	DauSac
+//
+// A key and its rule are separated by white spaces, lines starting with "//"
+// are comments ("#" is a key of some input methods).
+func formatInputMethodDefinitions(imDefs map[string]bamboo.InputMethodDefinition) string {
+	var names []string
+	for name := range imDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "[%s]\n", name)
+		var keys []string
+		for key := range imDefs[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&sb, "%s\t%s\n", key, imDefs[name][key])
+		}
+	}
+	return sb.String()
+}
+
+func parseInputMethodDefinitions(text string) (map[string]bamboo.InputMethodDefinition, error) {
+	var imDefs = map[string]bamboo.InputMethodDefinition{}
+	var current bamboo.InputMethodDefinition
+	var scanner = bufio.NewScanner(strings.NewReader(text))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			var name = strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: the input method has no name", lineNo)
+			}
+			current = bamboo.InputMethodDefinition{}
+			imDefs[name] = current
+			continue
+		}
+		var fields = strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a key and a rule, got %q", lineNo, line)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: the rule is not in an input method section", lineNo)
+		}
+		current[fields[0]] = fields[1]
+	}
+	return imDefs, scanner.Err()
+}
+
+// runInputMethodValidation is used by the input method editor to test the
+// definition it is editing: it reads one input method from r, prints the text
+// produced by the keys and then the problems found in the definition.
+func runInputMethodValidation(r io.Reader, w io.Writer, keys string) int {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR\t%s\n", err)
+		return 1
+	}
+	imDefs, err := parseInputMethodDefinitions(string(data))
+	if err != nil {
+		fmt.Fprintf(w, "ERROR\t%s\n", err)
+		return 1
+	}
+	if len(imDefs) != 1 {
+		fmt.Fprintf(w, "ERROR\texpected one input method, got %d\n", len(imDefs))
+		return 1
+	}
+	for name, imDef := range imDefs {
+		var errs = bamboo.ValidateInputMethodDefinition(imDef)
+		var preeditor = bamboo.NewEngine(bamboo.ParseInputMethod(imDefs, name), bamboo.EstdFlags)
+		preeditor.ProcessString(keys, bamboo.VietnameseMode)
+		fmt.Fprintf(w, "OUTPUT\t%s\n", preeditor.GetProcessedString(bamboo.VietnameseMode|bamboo.FullText))
+		for _, err := range errs {
+			if err.Conflict {
+				fmt.Fprintf(w, "CONFLICT\t%s\n", err)
+			} else {
+				fmt.Fprintf(w, "ERROR\t%s\n", err)
+			}
+		}
+		if len(errs) > 0 {
+			return 2
+		}
+	}
+	return 0
+}
+
+func logInputMethodErrors(imDefs map[string]bamboo.InputMethodDefinition) {
+	for name, imDef := range imDefs {
+		for _, err := range bamboo.ValidateInputMethodDefinition(imDef) {
+			if !err.Conflict {
+				log.Printf("Input method %s: %s", name, err)
+			}
+		}
+	}
+}
+
+// openInputMethodEditor starts the editor and returns at once, the definitions
+// are saved to the config file when the editor exits and the config watcher
+// applies them to the engine
+func (e *IBusBambooEngine) openInputMethodEditor() {
+	var enginePath, _ = os.Executable()
+	var cmd = exec.Command(imEditorPath, enginePath)
+	if _, err := os.Stat(imEditorPath); err != nil {
+		cmd = exec.Command(imEditorLocalPath, enginePath)
+	}
+	var out bytes.Buffer
+	cmd.Env = append(os.Environ(), "GTK_IM_MODULE=gtk-im-context-simple")
+	cmd.Stdin = strings.NewReader(formatInputMethodDefinitions(e.config.InputMethodDefinitions))
+	cmd.Stdout = &out
+	var cfg = *e.config
+	if err := cmd.Start(); err != nil {
+		log.Println("execute input-method-editor: ", err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Println("execute input-method-editor: ", err)
+			return
+		}
+		if out.Len() == 0 {
+			// cancelled
+			return
+		}
+		imDefs, err := parseInputMethodDefinitions(out.String())
+		if err != nil || len(imDefs) == 0 {
+			log.Println("input-method-editor: ", err)
+			return
+		}
+		logInputMethodErrors(imDefs)
+		cfg.InputMethodDefinitions = imDefs
+		if _, found := imDefs[cfg.InputMethod]; !found {
+			var names []string
+			for name := range imDefs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			cfg.InputMethod = names[0]
+		}
+		saveConfig(&cfg, e.engineName)
+	}()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+func TestInputMethodDefinitionsText(t *testing.T) {
+	var imDefs = bamboo.GetInputMethodDefinitions()
+	var text = formatInputMethodDefinitions(imDefs)
+	parsed, err := parseInputMethodDefinitions(text)
+	if err != nil {
+		t.Fatalf("Parse input methods, got error [%v]", err)
+	}
+	if formatInputMethodDefinitions(parsed) != text || len(parsed) != len(imDefs) {
+		t.Errorf("Parse input methods, got [%s] expected [%s]", formatInputMethodDefinitions(parsed), text)
+	}
+	for _, text := range []string{"a A_Â", "[]\na A_Â", "[Telex]\na"} {
+		if _, err := parseInputMethodDefinitions(text); err == nil {
+			t.Errorf("Parse input methods [%s], got no error", text)
+		}
+	}
+}
+
+func TestRunInputMethodValidation(t *testing.T) {
+	var out bytes.Buffer
+	var input = "// my own Telex\n[Custom]\na A_Â\nd D_Đ\ns DauSac\nj Dausac\n"
+	if ret := runInputMethodValidation(strings.NewReader(input), &out, "ddaas"); ret != 2 {
+		t.Errorf("Validate input method, got exit code [%d] expected [2]", ret)
+	}
+	var lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != "OUTPUT\tđấ" || !strings.HasPrefix(lines[1], "ERROR\tkey \"j\"") {
+		t.Errorf("Validate input method, got [%s]", out.String())
+	}
+}
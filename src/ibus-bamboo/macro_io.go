@@ -0,0 +1,487 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+const (
+	// MacroFormatBamboo is the format of the mactab file
+	MacroFormatBamboo = "bamboo"
+	// MacroFormatUniKey is the text format of UniKey, a key and its text are
+	// separated by the first colon and the comments start with ';'
+	MacroFormatUniKey = "unikey"
+	// MacroFormatEVKey is the format of EVKey, which reads and writes the
+	// macro files of UniKey
+	MacroFormatEVKey = "evkey"
+	// MacroFormatCSV is a spreadsheet of two columns, the key and the text
+	MacroFormatCSV = "csv"
+
+	uniKeyMacroHeader = ";DO NOT DELETE THIS LINE*** version=1 ***"
+	csvMacroKey       = "key"
+	csvMacroValue     = "value"
+)
+
+var macroFormats = []string{MacroFormatUniKey, MacroFormatEVKey, MacroFormatCSV, MacroFormatBamboo}
+
+// cp1252Runes are the runes of the bytes from 0x80 to 0x9F in Windows-1252,
+// the files of UniKey in TCVN3 or VNI are written in this codepage. The
+// undefined bytes are kept as the C1 controls like the charset definitions do.
+var cp1252Runes = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// MacroConflict is a key of an imported file whose text differs from the one
+// of the mactab file, the text of the mactab file is kept
+type MacroConflict struct {
+	Key      string
+	Current  string
+	Imported string
+}
+
+type MacroImportResult struct {
+	Charset   string
+	Added     int
+	Unchanged int
+	Conflicts []MacroConflict
+}
+
+func isValidMacroFormat(format string) bool {
+	for _, f := range macroFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// guessMacroFormat returns the format of a macro file by its extension or
+// by the header of its text
+func guessMacroFormat(fileName, text string) string {
+	if strings.EqualFold(filepath.Ext(fileName), "."+MacroFormatCSV) {
+		return MacroFormatCSV
+	}
+	var header = strings.SplitN(text, "\n", 2)[0]
+	if strings.HasPrefix(strings.TrimSpace(header), "#") && macroVersionRegexp.MatchString(header) {
+		return MacroFormatBamboo
+	}
+	return MacroFormatUniKey
+}
+
+// decodeMacroData converts the content of a macro file to Unicode, the files
+// may be written in UTF-16 or in a legacy charset like TCVN3 or VNI. A file
+// with a BOM, in UTF-16 or in UTF-8 with Vietnamese letters which no legacy
+// charset read as Latin-1 can give is kept as it is.
+func decodeMacroData(data []byte) (string, string) {
+	var text string
+	var isUnicode bool
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		text = string(data[3:])
+		isUnicode = true
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		var units = make([]uint16, (len(data)-2)/2)
+		for i := range units {
+			var lo, hi = data[2+2*i], data[3+2*i]
+			if data[0] == 0xFE {
+				lo, hi = hi, lo
+			}
+			units[i] = uint16(hi)<<8 | uint16(lo)
+		}
+		text = string(utf16.Decode(units))
+		isUnicode = true
+	case utf8.Valid(data):
+		text = string(data)
+		isUnicode = hasVietnameseLetterBeyondLatin1(text)
+	default:
+		var runes = make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+			if b >= 0x80 && b < 0xA0 {
+				runes[i] = cp1252Runes[b-0x80]
+			}
+		}
+		text = string(runes)
+	}
+	if isUnicode {
+		return text, bamboo.UNICODE
+	}
+	var charset = bamboo.DetectCharset(text)
+	return bamboo.Decode(charset, text), charset
+}
+
+// hasVietnameseLetterBeyondLatin1 tells if a text has a letter like ư or ạ,
+// a text of a legacy charset which was saved as UTF-8 has only the letters of
+// Latin-1 like â or ô
+func hasVietnameseLetterBeyondLatin1(text string) bool {
+	for _, chr := range text {
+		if chr > unicode.MaxLatin1 && bamboo.IsVietnameseRune(unicode.ToLower(chr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeMacroBraces turns a text of a format without placeholders into the
+// text of a macro, its braces are never expanded and its backslashes are kept
+func escapeMacroBraces(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `{`, `\{`, `}`, `\}`).Replace(text)
+}
+
+func unescapeMacroBraces(text string) string {
+	return strings.NewReplacer(`\\`, `\`, `\{`, `{`, `\}`, `}`).Replace(text)
+}
+
+// formatMacroLine writes a macro as a line of version 2, it is read back by
+// parseMacroEntries as the same key and text
+func formatMacroLine(key, value string) string {
+	var escape = func(s string, isKey bool) string {
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				// the escapes of a text are kept as they are
+				if !isKey && i+1 < len(s) && (s[i+1] == '{' || s[i+1] == '}' || s[i+1] == '\\') {
+					sb.WriteByte('\\')
+					i++
+					sb.WriteByte(s[i])
+				} else {
+					sb.WriteString(`\\`)
+				}
+			case '\n':
+				sb.WriteString(`\n`)
+			case '\t':
+				sb.WriteString(`\t`)
+			case '\r':
+			case ':':
+				if isKey {
+					sb.WriteString(`\:`)
+				} else {
+					sb.WriteByte(':')
+				}
+			default:
+				sb.WriteByte(s[i])
+			}
+		}
+		return sb.String()
+	}
+	return escape(key, true) + ":" + escape(value, false)
+}
+
+func parseUniKeyMacros(text string) []macroEntry {
+	var entries []macroEntry
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		var s = strings.TrimSpace(line)
+		if len(s) == 0 || strings.HasPrefix(s, ";") {
+			continue
+		}
+		var idx = strings.IndexByte(s, ':')
+		if idx <= 0 {
+			continue
+		}
+		entries = append(entries, macroEntry{s[:idx], escapeMacroBraces(s[idx+1:])})
+	}
+	return entries
+}
+
+func parseCSVMacros(text string) ([]macroEntry, error) {
+	var reader = csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	var entries []macroEntry
+	for i := 0; ; i++ {
+		var record, err = reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if i == 0 && strings.EqualFold(record[0], csvMacroKey) && strings.EqualFold(record[1], csvMacroValue) {
+			continue
+		}
+		var value = strings.ReplaceAll(record[1], "\r\n", "\n")
+		entries = append(entries, macroEntry{strings.TrimSpace(record[0]), escapeMacroBraces(value)})
+	}
+	return entries, nil
+}
+
+// readMacroFile reads the macros of a file in one of the macroFormats, the
+// format is guessed if it is empty
+func readMacroFile(fileName, format string) ([]macroEntry, string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	var text, charset = decodeMacroData(data)
+	if format == "" {
+		format = guessMacroFormat(fileName, text)
+	}
+	switch format {
+	case MacroFormatUniKey, MacroFormatEVKey:
+		return parseUniKeyMacros(text), charset, nil
+	case MacroFormatCSV:
+		entries, err := parseCSVMacros(text)
+		return entries, charset, err
+	case MacroFormatBamboo:
+		var _, entries = parseMacroEntries(text, false)
+		return entries, charset, nil
+	}
+	return nil, "", fmt.Errorf("unknown macro format: %s", format)
+}
+
+// upgradeMacroText rewrites a mactab file of version 1 in version 2 so the
+// imported macros can be appended with escapes, the comments are kept
+func upgradeMacroText(text string) string {
+	var lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) == 0 || getMacroFormatVersion(lines[0]) >= MacroFormatVersion {
+		return text
+	}
+	var header = fmt.Sprintf("# DO NOT DELETE THIS LINE*** version=%d ***", MacroFormatVersion)
+	if macroVersionRegexp.MatchString(lines[0]) {
+		lines[0] = header
+	} else {
+		lines = append([]string{header}, lines...)
+	}
+	for i := 1; i < len(lines); i++ {
+		var s = strings.TrimSpace(lines[i])
+		if len(s) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+			continue
+		}
+		var list = strings.Split(s, ":")
+		if len(list) != 2 {
+			// the line is not read in version 1 so it is kept as a comment
+			lines[i] = "#" + lines[i]
+			continue
+		}
+		lines[i] = formatMacroLine(list[0], escapeMacroBraces(list[1]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ImportMacroFile merges the macros of a file into a mactab file. The new keys
+// are appended, the keys with another text are kept and reported as conflicts.
+func ImportMacroFile(mactabFileName, fileName, format string) (*MacroImportResult, error) {
+	entries, charset, err := readMacroFile(fileName, format)
+	if err != nil {
+		return nil, err
+	}
+	var text = fmt.Sprintf("# DO NOT DELETE THIS LINE*** version=%d ***\n", MacroFormatVersion)
+	if data, err := ioutil.ReadFile(mactabFileName); err == nil {
+		text = upgradeMacroText(string(data))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var _, current = parseMacroText(text, false)
+	var result = &MacroImportResult{Charset: charset}
+	var sb strings.Builder
+	sb.WriteString(text)
+	if !strings.HasSuffix(text, "\n") {
+		sb.WriteByte('\n')
+	}
+	for _, entry := range entries {
+		if value, found := current[entry.key]; found {
+			if value == entry.value {
+				result.Unchanged++
+			} else {
+				result.Conflicts = append(result.Conflicts, MacroConflict{entry.key, value, entry.value})
+			}
+			continue
+		}
+		current[entry.key] = entry.value
+		sb.WriteString(formatMacroLine(entry.key, entry.value))
+		sb.WriteByte('\n')
+		result.Added++
+	}
+	if result.Added == 0 {
+		return result, nil
+	}
+	var tmpFile = mactabFileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, []byte(sb.String()), 0644); err != nil {
+		return nil, err
+	}
+	return result, os.Rename(tmpFile, mactabFileName)
+}
+
+// ExportMacroFile writes the macros of a mactab file in a format of another
+// input method, the format is guessed from the extension if it is empty. The
+// placeholders are written as they are and the keys which cannot be written
+// in the format are returned.
+func ExportMacroFile(mactabFileName, fileName, format string) ([]string, error) {
+	data, err := ioutil.ReadFile(mactabFileName)
+	if err != nil {
+		return nil, err
+	}
+	var _, entries = parseMacroEntries(string(data), false)
+	if format == "" {
+		format = MacroFormatUniKey
+		if strings.EqualFold(filepath.Ext(fileName), "."+MacroFormatCSV) {
+			format = MacroFormatCSV
+		}
+	}
+	var skipped []string
+	var buf bytes.Buffer
+	switch format {
+	case MacroFormatUniKey, MacroFormatEVKey:
+		buf.WriteString("\uFEFF" + uniKeyMacroHeader + "\r\n")
+		for _, entry := range entries {
+			var value = unescapeMacroBraces(entry.value)
+			if strings.Contains(entry.key, ":") || strings.HasPrefix(entry.key, ";") || strings.ContainsAny(value, "\r\n") {
+				skipped = append(skipped, entry.key)
+				continue
+			}
+			buf.WriteString(entry.key + ":" + value + "\r\n")
+		}
+	case MacroFormatCSV:
+		var writer = csv.NewWriter(&buf)
+		writer.Write([]string{csvMacroKey, csvMacroValue})
+		for _, entry := range entries {
+			writer.Write([]string{entry.key, unescapeMacroBraces(entry.value)})
+		}
+		writer.Flush()
+		if err = writer.Error(); err != nil {
+			return nil, err
+		}
+	case MacroFormatBamboo:
+		buf.Write(data)
+	default:
+		return nil, fmt.Errorf("unknown macro format: %s", format)
+	}
+	return skipped, ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// runMacroImport imports a macro file into the mactab file of an engine and
+// prints the result with the conflicts, it returns the exit code
+func runMacroImport(w io.Writer, engineName, fileName, format string) int {
+	if format != "" && !isValidMacroFormat(format) {
+		fmt.Fprintf(w, "ERROR\tunknown macro format %s, expected one of %s\n", format, strings.Join(macroFormats, ", "))
+		return 1
+	}
+	setupConfigDir(engineName)
+	result, err := ImportMacroFile(getMactabFile(engineName), fileName, format)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR\t%s\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "CHARSET\t%s\n", result.Charset)
+	fmt.Fprintf(w, "ADDED\t%d\n", result.Added)
+	fmt.Fprintf(w, "UNCHANGED\t%d\n", result.Unchanged)
+	for _, conflict := range result.Conflicts {
+		fmt.Fprintf(w, "CONFLICT\t%s\t%s\t%s\n", conflict.Key, conflict.Current, conflict.Imported)
+	}
+	if len(result.Conflicts) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// runMacroExport exports the mactab file of an engine, it returns the exit code
+func runMacroExport(w io.Writer, engineName, fileName, format string) int {
+	if format != "" && !isValidMacroFormat(format) {
+		fmt.Fprintf(w, "ERROR\tunknown macro format %s, expected one of %s\n", format, strings.Join(macroFormats, ", "))
+		return 1
+	}
+	skipped, err := ExportMacroFile(getMactabFile(engineName), fileName, format)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR\t%s\n", err)
+		return 1
+	}
+	for _, key := range skipped {
+		fmt.Fprintf(w, "SKIPPED\t%s\n", key)
+	}
+	return 0
+}
+
+// chooseMacroFile asks for a file with the dialog of zenity, an empty name is
+// returned if the dialog is canceled or cannot be shown
+func chooseMacroFile(title string, save bool) string {
+	var args = []string{"--file-selection", "--title=" + title,
+		"--file-filter=UniKey, EVKey (*.txt) | *.txt *.TXT", "--file-filter=CSV (*.csv) | *.csv *.CSV", "--file-filter=* | *"}
+	if save {
+		args = append(args, "--save", "--confirm-overwrite")
+	}
+	out, err := exec.Command("zenity", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (e *IBusBambooEngine) importMacroFile() {
+	go func() {
+		var fileName = chooseMacroFile("Nhập từ gõ tắt", false)
+		if fileName == "" {
+			return
+		}
+		setupConfigDir(e.engineName)
+		result, err := ImportMacroFile(getMactabFile(e.engineName), fileName, "")
+		if err != nil {
+			notifyMessage("Nhập từ gõ tắt", err.Error())
+			return
+		}
+		e.macroTable.ReloadLayers()
+		var msg = fmt.Sprintf("Đã thêm %d từ gõ tắt (%s)", result.Added, result.Charset)
+		if len(result.Conflicts) > 0 {
+			var keys []string
+			for _, conflict := range result.Conflicts {
+				keys = append(keys, conflict.Key)
+			}
+			msg += fmt.Sprintf(", %d từ bị trùng được giữ nguyên: %s", len(keys), strings.Join(keys, ", "))
+		}
+		notifyMessage("Nhập từ gõ tắt", msg)
+	}()
+}
+
+func (e *IBusBambooEngine) exportMacroFile() {
+	go func() {
+		var fileName = chooseMacroFile("Xuất từ gõ tắt", true)
+		if fileName == "" {
+			return
+		}
+		skipped, err := ExportMacroFile(getMactabFile(e.engineName), fileName, "")
+		if err != nil {
+			notifyMessage("Xuất từ gõ tắt", err.Error())
+			return
+		}
+		var msg = fmt.Sprintf("Đã lưu vào %s", fileName)
+		if len(skipped) > 0 {
+			msg += fmt.Sprintf(", bỏ qua %d từ không ghi được trong định dạng này: %s", len(skipped), strings.Join(skipped, ", "))
+		}
+		notifyMessage("Xuất từ gõ tắt", msg)
+	}()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+func encodeCP1252(t *testing.T, text string) []byte {
+	var data []byte
+	for _, chr := range text {
+		if chr < 0x80 || (chr >= 0xA0 && chr < 0x100) {
+			data = append(data, byte(chr))
+			continue
+		}
+		var found = false
+		for i, r := range cp1252Runes {
+			if r == chr {
+				data = append(data, byte(0x80+i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Process [%s], got [%c] expected a rune of Windows-1252", text, chr)
+		}
+	}
+	return data
+}
+
+func TestDecodeMacroData(t *testing.T) {
+	var text = "vn:Việt Nam\nhn:Hà Nội, thủ đô"
+	var tests = []struct {
+		name    string
+		data    []byte
+		charset string
+	}{
+		{"utf-8", []byte(text), bamboo.UNICODE},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, text...), bamboo.UNICODE},
+		{"tcvn3", encodeCP1252(t, bamboo.Encode("TCVN3 (ABC)", text)), "TCVN3 (ABC)"},
+		{"vni", encodeCP1252(t, bamboo.Encode("VNI Windows", text)), "VNI Windows"},
+		{"vni in utf-8", []byte(bamboo.Encode("VNI Windows", text)), "VNI Windows"},
+	}
+	var utf16 = []byte{0xFF, 0xFE}
+	for _, chr := range text {
+		utf16 = append(utf16, byte(chr), byte(chr>>8))
+	}
+	tests = append(tests, struct {
+		name    string
+		data    []byte
+		charset string
+	}{"utf-16", utf16, bamboo.UNICODE})
+	for _, test := range tests {
+		var decoded, charset = decodeMacroData(test.data)
+		if decoded != text || charset != test.charset {
+			t.Errorf("Process [%s], got [%s, %s] expected [%s, %s]", test.name, decoded, charset, text, test.charset)
+		}
+	}
+}
+
+func TestParseMacroFormats(t *testing.T) {
+	var entries = parseUniKeyMacros(uniKeyMacroHeader + "\r\nvn:Việt Nam\r\n;cm:comment\r\nt:10:30 {x}\r\n")
+	var expected = []macroEntry{{"vn", "Việt Nam"}, {"t", `10:30 \{x\}`}}
+	if len(entries) != len(expected) || entries[0] != expected[0] || entries[1] != expected[1] {
+		t.Errorf("Process [unikey], got [%v] expected [%v]", entries, expected)
+	}
+	entries, err := parseCSVMacros("key,value\nvn,Việt Nam\n\"ck\",\"Trân trọng,\nA\"\nx\n")
+	expected = []macroEntry{{"vn", "Việt Nam"}, {"ck", "Trân trọng,\nA"}}
+	if err != nil || len(entries) != len(expected) || entries[0] != expected[0] || entries[1] != expected[1] {
+		t.Errorf("Process [csv], got [%v, %v] expected [%v]", entries, err, expected)
+	}
+	for _, test := range []struct{ fileName, text, format string }{
+		{"a.CSV", "vn:Việt Nam", MacroFormatCSV},
+		{"a.txt", uniKeyMacroHeader + "\nvn:Việt Nam", MacroFormatUniKey},
+		{"a.txt", "# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam", MacroFormatBamboo},
+	} {
+		if format := guessMacroFormat(test.fileName, test.text); format != test.format {
+			t.Errorf("Process [%s], got [%s] expected [%s]", test.fileName, format, test.format)
+		}
+	}
+}
+
+func TestFormatMacroLine(t *testing.T) {
+	var tests = []macroEntry{
+		{"10:30", "mười giờ: rưỡi"},
+		{"ck", "Trân trọng,\n\tA\\\\B"},
+		{"hn", `Hà Nội {date} \{x\}`},
+		{`c:\`, `C:\\{date}`},
+	}
+	for _, test := range tests {
+		var line = "# DO NOT DELETE THIS LINE*** version=2 ***\n" + formatMacroLine(test.key, test.value)
+		var _, entries = parseMacroEntries(line, false)
+		if len(entries) != 1 || entries[0] != test {
+			t.Errorf("Process [%s], got [%v] expected [%v]", line, entries, test)
+		}
+	}
+}
+
+func TestImportMacroFile(t *testing.T) {
+	var dir = t.TempDir()
+	var mactab = filepath.Join(dir, "ibus-Bamboo.macro.text")
+	var imported = filepath.Join(dir, "unikey.txt")
+	ioutil.WriteFile(mactab, []byte("# DO NOT DELETE THIS LINE*** version=1 ***\n# comment\nvn:Việt Nam\nhn:Hà Nội\nbr:{date}\n"), 0644)
+	var unikey = uniKeyMacroHeader + "\r\nvn:Việt Nam\r\nhn:Hải Phòng\r\nt:10:30\r\nd:{date}\r\n"
+	ioutil.WriteFile(imported, encodeCP1252(t, bamboo.Encode("TCVN3 (ABC)", unikey)), 0644)
+
+	result, err := ImportMacroFile(mactab, imported, "")
+	if err != nil {
+		t.Fatalf("Process [import], got [%v] expected no error", err)
+	}
+	if result.Charset != "TCVN3 (ABC)" || result.Added != 2 || result.Unchanged != 1 {
+		t.Errorf("Process [import], got [%+v] expected [2 added, 1 unchanged]", result)
+	}
+	var conflict = MacroConflict{"hn", "Hà Nội", "Hải Phòng"}
+	if len(result.Conflicts) != 1 || result.Conflicts[0] != conflict {
+		t.Errorf("Process [conflicts], got [%v] expected [%v]", result.Conflicts, conflict)
+	}
+	data, _ := ioutil.ReadFile(mactab)
+	if !strings.Contains(string(data), "# comment\n") {
+		t.Errorf("Process [comment], got [%s] expected the comment to be kept", data)
+	}
+	var table = NewMacroTable(false)
+	table.LoadFromFile(mactab)
+	var expected = map[string]string{"vn": "Việt Nam", "hn": "Hà Nội", "br": "{date}", "t": "10:30", "d": "{date}"}
+	for key, value := range expected {
+		if text := table.ExpandPlaceholders(table.GetText(key)); text != value {
+			t.Errorf("Process [%s], got [%s] expected [%s]", key, text, value)
+		}
+	}
+
+	result, err = ImportMacroFile(mactab, imported, MacroFormatUniKey)
+	if err != nil || result.Added != 0 || result.Unchanged != 3 || len(result.Conflicts) != 1 {
+		t.Errorf("Process [import again], got [%+v, %v] expected [3 unchanged, 1 conflict]", result, err)
+	}
+}
+
+func TestImportUnicodeMacroFile(t *testing.T) {
+	var dir = t.TempDir()
+	var expected = map[string]string{
+		"dn":  "Đà Nẵng — 100€",
+		"cty": "Công ty TNHH • chi nhánh",
+		"tm":  "™ thương hiệu",
+	}
+	var files = map[string]string{
+		"evkey.txt": "dn:Đà Nẵng — 100€\r\ncty:Công ty TNHH • chi nhánh\r\ntm:™ thương hiệu\r\n",
+		"macro.csv": "key,value\ndn,Đà Nẵng — 100€\ncty,Công ty TNHH • chi nhánh\ntm,™ thương hiệu\n",
+		"bom.txt":   "\uFEFFdn:Đà Nẵng — 100€\r\ncty:Công ty TNHH • chi nhánh\r\ntm:™ thương hiệu\r\n",
+	}
+	for name, data := range files {
+		var fileName = filepath.Join(dir, name)
+		var mactab = filepath.Join(dir, name+".text")
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+		result, err := ImportMacroFile(mactab, fileName, "")
+		if err != nil || result.Added != 3 || result.Charset != bamboo.UNICODE {
+			t.Errorf("Process [%s], got [%+v, %v] expected [3 added, %s]", name, result, err, bamboo.UNICODE)
+			continue
+		}
+		var table = NewMacroTable(false)
+		table.LoadFromFile(mactab)
+		for key, value := range expected {
+			if text := table.ExpandPlaceholders(table.GetText(key)); text != value {
+				t.Errorf("Process [%s %s], got [%s] expected [%s]", name, key, text, value)
+			}
+		}
+	}
+}
+
+func TestExportMacroFile(t *testing.T) {
+	var dir = t.TempDir()
+	var mactab = filepath.Join(dir, "ibus-Bamboo.macro.text")
+	ioutil.WriteFile(mactab, []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam\nck:Trân trọng,\\nA\n10\\:30:mười giờ\nbr:\\{x}\n"), 0644)
+
+	var unikey = filepath.Join(dir, "unikey.txt")
+	skipped, err := ExportMacroFile(mactab, unikey, "")
+	if err != nil || len(skipped) != 2 || skipped[0] != "ck" || skipped[1] != "10:30" {
+		t.Errorf("Process [unikey], got [%v, %v] expected [ck 10:30]", skipped, err)
+	}
+	data, _ := ioutil.ReadFile(unikey)
+	var expected = "\uFEFF" + uniKeyMacroHeader + "\r\nvn:Việt Nam\r\nbr:{x}\r\n"
+	if string(data) != expected {
+		t.Errorf("Process [unikey], got [%q] expected [%q]", data, expected)
+	}
+
+	var csvFile = filepath.Join(dir, "macro.csv")
+	if skipped, err = ExportMacroFile(mactab, csvFile, ""); err != nil || len(skipped) != 0 {
+		t.Errorf("Process [csv], got [%v, %v] expected no skipped key", skipped, err)
+	}
+	var copied = filepath.Join(dir, "copy.text")
+	result, err := ImportMacroFile(copied, csvFile, "")
+	if err != nil || result.Added != 4 {
+		t.Fatalf("Process [csv], got [%+v, %v] expected [4 added]", result, err)
+	}
+	var table = NewMacroTable(false)
+	table.LoadFromFile(copied)
+	var original = NewMacroTable(false)
+	original.LoadFromFile(mactab)
+	for key, value := range original.mTable {
+		var text, expected = table.ExpandPlaceholders(table.GetText(key)), original.ExpandPlaceholders(value)
+		if text != expected {
+			t.Errorf("Process [%s], got [%s] expected [%s]", key, text, expected)
+		}
+	}
+}
+
+func TestRunMacroImport(t *testing.T) {
+	var w bytes.Buffer
+	if code := runMacroImport(&w, "Bamboo", "a.txt", "word"); code != 1 || !strings.HasPrefix(w.String(), "ERROR\t") {
+		t.Errorf("Process [word], got [%d, %s] expected [1, ERROR]", code, w.String())
+	}
+}
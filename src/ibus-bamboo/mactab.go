@@ -20,117 +20,530 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+const (
+	// MacroFormatVersion is the version of the macro files written by the
+	// template, the files of version 1 are still read as they were
+	MacroFormatVersion = 2
+
+	macroDateLayout = "02/01/2006"
+	macroTimeLayout = "15:04"
+	// macroCursorMarker stands for the {cursor} placeholder in an expanded
+	// text, it is a noncharacter so it never comes from the user
+	macroCursorMarker = '\uFDD0'
+	macroBlockQuote   = `"""`
+	// macroClipboardTimeout bounds the wait for the owner of the clipboard on
+	// the key path, the placeholder is left empty if it does not answer in time
+	macroClipboardTimeout = 200 * time.Millisecond
+)
+
+var macroVersionRegexp = regexp.MustCompile(`version=(\d+)`)
+
+// MacroTable holds the macros of the layers of an engine: the system-wide
+// file, the shared team file, the file of the user and the sets of the
+// applications. A later layer overrides the former ones.
 type MacroTable struct {
 	sync.RWMutex
-	enable              bool
 	autoCapitalizeMacro bool
+	version             int
 	mTable              map[string]string
+	teamFile            string
+	appTables           map[string]map[string]string
+	sortedKeys          []string
+	application         []string
+	generation          int
+	stopWatches         []func()
+	reloadLayers        func()
+	now                 func() time.Time
+	clipboard           func() string
 }
 
 func NewMacroTable(autoCapitalizeMacro bool) *MacroTable {
-	return &MacroTable{autoCapitalizeMacro: autoCapitalizeMacro}
+	return &MacroTable{autoCapitalizeMacro: autoCapitalizeMacro, now: time.Now, clipboard: x11GetClipboardText}
 }
 
+// LoadFromFile reads a single macro file as the only layer of the table
 func (e *MacroTable) LoadFromFile(macroFileName string) error {
-	f, err := os.Open(macroFileName)
+	data, err := ioutil.ReadFile(macroFileName)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	e.mTable = map[string]string{}
-	rd := bufio.NewReader(f)
-	for {
-		line, _, err := rd.ReadLine()
+	var version, mTable = parseMacroText(string(data), e.autoCapitalizeMacro)
+	e.Lock()
+	e.setTables(version, mTable, nil)
+	e.Unlock()
+	return nil
+}
+
+// getMacroFormatVersion reads the version from the header of a macro file
+func getMacroFormatVersion(header string) int {
+	if m := macroVersionRegexp.FindStringSubmatch(header); m != nil {
+		if version, err := strconv.Atoi(m[1]); err == nil {
+			return version
+		}
+	}
+	return 1
+}
+
+type macroEntry struct {
+	key   string
+	value string
+}
+
+func parseMacroText(text string, lowerCaseKeys bool) (int, map[string]string) {
+	var version, entries = parseMacroEntries(text, lowerCaseKeys)
+	var mTable = map[string]string{}
+	for _, entry := range entries {
+		mTable[entry.key] = entry.value
+	}
+	return version, mTable
+}
+
+// parseMacroEntries reads the macros of a file in their order, the later ones
+// override the former in a table
+func parseMacroEntries(text string, lowerCaseKeys bool) (int, []macroEntry) {
+	var lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var version = getMacroFormatVersion(lines[0])
+	var entries []macroEntry
+	for i := 0; i < len(lines); i++ {
+		var s = strings.TrimSpace(lines[i])
+		if len(s) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+			continue
+		}
+		var key, value string
+		if version < 2 {
+			var list = strings.Split(s, ":")
+			if len(list) != 2 {
+				continue
+			}
+			key, value = list[0], list[1]
+		} else {
+			var ok bool
+			if key, value, ok = splitMacroLine(s); !ok {
+				continue
+			}
+			// a multi-line value is written between two lines of """
+			if value == macroBlockQuote {
+				var block []string
+				for i++; i < len(lines) && strings.TrimSpace(lines[i]) != macroBlockQuote; i++ {
+					block = append(block, lines[i])
+				}
+				value = strings.Join(block, "\n")
+			} else {
+				value = unescapeMacroText(value, false)
+			}
+			key = unescapeMacroText(key, true)
+		}
+		if lowerCaseKeys {
+			key = strings.ToLower(key)
+		}
+		if key != "" {
+			entries = append(entries, macroEntry{key, value})
+		}
+	}
+	return version, entries
+}
+
+// splitMacroLine splits a line of version 2 at its first colon which is not
+// escaped by a backslash
+func splitMacroLine(line string) (string, string, bool) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ':':
+			return line[:i], line[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// unescapeMacroText replaces \: \n and \t. In a text, the escaped braces and
+// backslashes are kept until the placeholders are expanded, they are all
+// replaced in a key.
+func unescapeMacroText(s string, isKey bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case '{', '}', '\\':
+			if !isKey {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// ExpandPlaceholders replaces {date}, {time} and {clipboard} in a text of a
+// macro file of version 2, and {cursor} by macroCursorMarker. The other
+// braces and the texts of version 1 are left as they are, \{, \} and \\ are
+// replaced by the character they escape.
+func (e *MacroTable) ExpandPlaceholders(text string) string {
+	e.RLock()
+	var version, now, clipboard = e.version, e.now, e.clipboard
+	e.RUnlock()
+	if version < 2 {
+		return text
+	}
+	if now == nil {
+		now = time.Now
+	}
+	var sb strings.Builder
+	var hasCursor = false
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && (text[i+1] == '{' || text[i+1] == '}' || text[i+1] == '\\') {
+			i++
+			sb.WriteByte(text[i])
+			continue
+		}
+		var end = strings.IndexByte(text[i:], '}')
+		if text[i] != '{' || end < 0 {
+			sb.WriteByte(text[i])
+			continue
+		}
+		// the names are matched in any case since the macros may be capitalized
+		switch strings.ToLower(text[i+1 : i+end]) {
+		case "date":
+			sb.WriteString(now().Format(macroDateLayout))
+		case "time":
+			sb.WriteString(now().Format(macroTimeLayout))
+		case "clipboard":
+			if clipboard != nil {
+				sb.WriteString(readClipboard(clipboard, macroClipboardTimeout))
+			}
+		case "cursor":
+			if !hasCursor {
+				sb.WriteRune(macroCursorMarker)
+				hasCursor = true
+			}
+		default:
+			sb.WriteByte(text[i])
+			continue
+		}
+		i += end
+	}
+	return sb.String()
+}
+
+var clipboardMutex sync.Mutex
+
+// readClipboard reads the clipboard on another goroutine and gives up after a
+// timeout, a read which has not returned yet is not started again
+func readClipboard(read func() string, timeout time.Duration) string {
+	if !clipboardMutex.TryLock() {
+		log.Println("The clipboard is still being read")
+		return ""
+	}
+	var result = make(chan string, 1)
+	go func() {
+		defer clipboardMutex.Unlock()
+		result <- read()
+	}()
+	select {
+	case text := <-result:
+		return text
+	case <-time.After(timeout):
+		log.Println("The clipboard is not read in time")
+		return ""
+	}
+}
+
+// splitMacroCursor removes the cursor marker from a text and returns the
+// number of runes after it
+func splitMacroCursor(text string) (string, int) {
+	var idx = strings.IndexRune(text, macroCursorMarker)
+	if idx < 0 {
+		return text, 0
+	}
+	var after = text[idx+utf8.RuneLen(macroCursorMarker):]
+	return text[:idx] + after, utf8.RuneCountInString(after)
+}
+
+// loadMacroLayer reads a macro file as a table of version 2, the braces of
+// version 1 are escaped since they are not placeholders there
+func loadMacroLayer(fileName string, lowerCaseKeys bool) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var version, mTable = parseMacroText(string(data), lowerCaseKeys)
+	if version < 2 {
+		for key, value := range mTable {
+			mTable[key] = escapeMacroBraces(value)
+		}
+	}
+	return mTable, nil
+}
+
+// getLayerFiles returns the macro files of an engine from the lowest precedence,
+// the missing files are skipped when they are loaded
+func (e *MacroTable) getLayerFiles(engineName string) []string {
+	var files = []string{systemMactabFile}
+	if e.teamFile != "" {
+		files = append(files, e.teamFile)
+	}
+	return append(files, getMactabFile(engineName))
+}
+
+// getAppFiles returns the macro sets of the applications keyed by their
+// lowercased WM_CLASS
+func getAppFiles(appDir string) map[string]string {
+	var appFiles = map[string]string{}
+	files, _ := ioutil.ReadDir(appDir)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != mactabAppExt {
+			continue
+		}
+		appFiles[strings.ToLower(strings.TrimSuffix(f.Name(), mactabAppExt))] = filepath.Join(appDir, f.Name())
+	}
+	return appFiles
+}
+
+// readMacroLayers reads the macro files from the lowest precedence and the
+// sets of the applications
+func readMacroLayers(files []string, appFiles map[string]string, lowerCaseKeys bool) (map[string]string, map[string]map[string]string) {
+	var mTable = map[string]string{}
+	for _, fileName := range files {
+		layer, err := loadMacroLayer(fileName, lowerCaseKeys)
 		if err != nil {
-			break
+			if !os.IsNotExist(err) {
+				log.Println(err)
+			}
+			continue
 		}
-		var s = strings.TrimSpace(string(line))
-		if len(line) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+		for key, value := range layer {
+			mTable[key] = value
+		}
+	}
+	var appTables = map[string]map[string]string{}
+	for name, fileName := range appFiles {
+		layer, err := loadMacroLayer(fileName, lowerCaseKeys)
+		if err != nil {
+			log.Println(err)
 			continue
 		}
-		var list = strings.Split(s, ":")
-		if len(list) == 2 {
-			key := list[0]
-			if e.autoCapitalizeMacro {
-				key = strings.ToLower(key)
+		appTables[name] = layer
+	}
+	return mTable, appTables
+}
+
+func (e *MacroTable) loadLayers(files []string, appFiles map[string]string) {
+	e.RLock()
+	var lowerCaseKeys = e.autoCapitalizeMacro
+	e.RUnlock()
+	var mTable, appTables = readMacroLayers(files, appFiles, lowerCaseKeys)
+	e.Lock()
+	e.setTables(MacroFormatVersion, mTable, appTables)
+	e.Unlock()
+}
+
+// setTables swaps the macro tables and indexes their keys in order so that
+// the keys of a prefix are found by a binary search, the caller holds the lock
+func (e *MacroTable) setTables(version int, mTable map[string]string, appTables map[string]map[string]string) {
+	var found = map[string]bool{}
+	var keys []string
+	var tables = []map[string]string{mTable}
+	for _, appTable := range appTables {
+		tables = append(tables, appTable)
+	}
+	for _, table := range tables {
+		for key := range table {
+			if !found[key] {
+				found[key] = true
+				keys = append(keys, key)
 			}
-			e.mTable[key] = list[1]
 		}
 	}
-	return nil
+	sort.Strings(keys)
+	e.version = version
+	e.mTable = mTable
+	e.appTables = appTables
+	e.sortedKeys = keys
+}
+
+// watchLayers loads the macro layers and reloads them whenever one of their
+// files changes, the tables are swapped at once so a lookup never sees a
+// half loaded table. A reload which was started before the table was
+// enabled again or disabled is dropped.
+func (e *MacroTable) watchLayers(files []string, appDir string) {
+	e.Lock()
+	e.stopWatching()
+	e.generation++
+	var generation = e.generation
+	var lowerCaseKeys = e.autoCapitalizeMacro
+	var reload = func() {
+		var mTable, appTables = readMacroLayers(files, getAppFiles(appDir), lowerCaseKeys)
+		e.Lock()
+		defer e.Unlock()
+		if e.generation != generation {
+			return
+		}
+		e.setTables(MacroFormatVersion, mTable, appTables)
+	}
+	for _, path := range files {
+		e.stopWatches = append(e.stopWatches, fileWatcher.Watch(path, reload))
+	}
+	e.stopWatches = append(e.stopWatches, fileWatcher.Watch(appDir, reload))
+	e.reloadLayers = reload
+	e.Unlock()
+	reload()
+}
+
+// stopWatching stops the watches of the layers, the caller holds the lock
+func (e *MacroTable) stopWatching() {
+	for _, stop := range e.stopWatches {
+		stop()
+	}
+	e.stopWatches = nil
+	e.reloadLayers = nil
+}
+
+// ReloadLayers reads the watched layers again at once, for the files written
+// by the engine itself. Nothing is loaded if the table is disabled.
+func (e *MacroTable) ReloadLayers() {
+	e.RLock()
+	var reload = e.reloadLayers
+	e.RUnlock()
+	if reload != nil {
+		reload()
+	}
+}
+
+// SetApplication selects the macro sets of the focused window, a WM_CLASS like
+// "gnome-terminal-server:Gnome-terminal" matches a set by any of its names
+func (e *MacroTable) SetApplication(wmClass string) {
+	e.Lock()
+	e.application = nil
+	if wmClass != "" {
+		e.application = strings.Split(strings.ToLower(wmClass), ":")
+	}
+	e.Unlock()
+}
+
+// lookup returns the text of a key in the set of the focused application or
+// in the other layers, an empty text in the set hides the key of the others
+func (e *MacroTable) lookup(key string) string {
+	for _, name := range e.application {
+		if text, found := e.appTables[name][key]; found {
+			return text
+		}
+	}
+	return e.mTable[key]
 }
 
 func (e *MacroTable) Reload(engineName string, autoCapitalizeMacro bool) {
+	e.Lock()
 	e.autoCapitalizeMacro = autoCapitalizeMacro
+	e.Unlock()
 	e.Enable(engineName)
 }
 
 func (e *MacroTable) GetText(key string) string {
+	e.RLock()
+	defer e.RUnlock()
 	if e.autoCapitalizeMacro {
 		key = strings.ToLower(key)
 	}
-	return e.mTable[key]
+	return e.lookup(key)
 }
 
 func (e *MacroTable) HasKey(key string) bool {
-	if e.autoCapitalizeMacro {
-		key = strings.ToLower(key)
-	}
-	return e.mTable[key] != ""
+	return e.GetText(key) != ""
 }
 
-func (e *MacroTable) IncludeKey(key string) bool {
-	if e.mTable[key] != "" {
-		return true
-	}
-	for k := range e.mTable {
-		if strings.Contains(k, key) {
+// IncludeKey tells if a key of the layers seen by the focused application
+// starts with a prefix
+func (e *MacroTable) IncludeKey(prefix string) bool {
+	e.RLock()
+	defer e.RUnlock()
+	for i := sort.SearchStrings(e.sortedKeys, prefix); i < len(e.sortedKeys) && strings.HasPrefix(e.sortedKeys[i], prefix); i++ {
+		if e.lookup(e.sortedKeys[i]) != "" {
 			return true
 		}
 	}
 	return false
 }
 
-func (e *MacroTable) Enable(engineName string) {
-	e.enable = true
-
-	go func() {
-		modTime := time.Now()
-
-		efPath := getMactabFile(engineName)
-
-		for e.enable {
-			if sta, _ := os.Stat(efPath); sta != nil {
-				if newModeTime := sta.ModTime(); !newModeTime.Equal(modTime) {
-					modTime = newModeTime
-					e.LoadFromFile(efPath)
-				}
-			}
-			time.Sleep(3 * time.Second)
+// FindKeys returns the keys which start with a prefix in the layers seen by
+// the focused application, the shortest keys first
+func (e *MacroTable) FindKeys(prefix string, limit int) []string {
+	e.RLock()
+	defer e.RUnlock()
+	if e.autoCapitalizeMacro {
+		prefix = strings.ToLower(prefix)
+	}
+	var keys []string
+	for i := sort.SearchStrings(e.sortedKeys, prefix); i < len(e.sortedKeys) && strings.HasPrefix(e.sortedKeys[i], prefix); i++ {
+		// the keys of the sets of the other applications are looked up empty
+		if e.lookup(e.sortedKeys[i]) != "" {
+			keys = append(keys, e.sortedKeys[i])
 		}
-	}()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (e *MacroTable) Enable(engineName string) {
+	e.watchLayers(e.getLayerFiles(engineName), getMactabAppDir(engineName))
 }
 
 func (e *MacroTable) Disable() {
-	e.enable = false
-	e.mTable = map[string]string{}
+	e.Lock()
+	e.stopWatching()
+	e.generation++
+	e.setTables(e.version, map[string]string{}, map[string]map[string]string{})
+	e.Unlock()
 }
 
 func getMactabFile(engineName string) string {
 	return fmt.Sprintf(mactabFile, getConfigDir(engineName), engineName)
 }
 
+func getMactabAppDir(engineName string) string {
+	return fmt.Sprintf(mactabAppDir, getConfigDir(engineName), engineName)
+}
+
+// getMactabAppFile returns the macro set of an application, it is named after
+// the class of its WM_CLASS which is shared by all of its windows
+func getMactabAppFile(engineName, wmClass string) string {
+	var names = strings.Split(strings.ToLower(wmClass), ":")
+	return filepath.Join(getMactabAppDir(engineName), names[len(names)-1]+mactabAppExt)
+}
+
 func OpenMactabFile(engineName string) {
 	efPath := getMactabFile(engineName)
 	if _, err := os.Stat(efPath); os.IsNotExist(err) {
@@ -139,7 +552,25 @@ func OpenMactabFile(engineName string) {
 		log.Println(err)
 		ioutil.WriteFile(efPath, sample, 0644)
 	}
+	openMacroEditor(efPath)
+}
+
+// OpenAppMactabFile opens the macro set of an application, the file is
+// created with the header of the current version
+func OpenAppMactabFile(engineName, wmClass string) {
+	if wmClass == "" {
+		return
+	}
+	efPath := getMactabAppFile(engineName, wmClass)
+	if _, err := os.Stat(efPath); os.IsNotExist(err) {
+		os.MkdirAll(filepath.Dir(efPath), 0777)
+		var header = fmt.Sprintf("# DO NOT DELETE THIS LINE*** version=%d ***\n# Các từ gõ tắt chỉ dùng trong %s\n", MacroFormatVersion, wmClass)
+		ioutil.WriteFile(efPath, []byte(header), 0644)
+	}
+	openMacroEditor(efPath)
+}
 
+func openMacroEditor(efPath string) {
 	err := exec.Command("/usr/lib/ibus-bamboo/macro-editor", efPath).Start()
 	if err != nil {
 		_ = exec.Command("./macro-editor", efPath).Start()
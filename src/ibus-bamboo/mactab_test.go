@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMacroTextVersion1(t *testing.T) {
+	var version, mTable = parseMacroText("# DO NOT DELETE THIS LINE*** version=1 ***\nvn:Việt Nam\nweb:https://a.b\nhn:{date}\n", false)
+	if version != 1 {
+		t.Errorf("Process [version=1], got [%d] expected [1]", version)
+	}
+	var expected = map[string]string{"vn": "Việt Nam", "hn": "{date}"}
+	if len(mTable) != len(expected) {
+		t.Errorf("Process [version 1], got [%v] expected [%v]", mTable, expected)
+	}
+	for key, value := range expected {
+		if mTable[key] != value {
+			t.Errorf("Process [%s], got [%s] expected [%s]", key, mTable[key], value)
+		}
+	}
+	var table = &MacroTable{version: version, mTable: mTable}
+	if text := table.ExpandPlaceholders(mTable["hn"]); text != "{date}" {
+		t.Errorf("Process [{date}] in version 1, got [%s] expected [{date}]", text)
+	}
+}
+
+func TestParseMacroTextVersion2(t *testing.T) {
+	var text = `# DO NOT DELETE THIS LINE*** version=2 ***
+# comment
+web:https://github.com
+10\:30:mười giờ rưỡi
+tab:a\tb\nc\\d
+VN:Việt Nam
+ck:"""
+Trân trọng,
+  Nguyễn Văn A
+"""
+br:\{date}
+bs:\\{date}
+`
+	var version, mTable = parseMacroText(text, true)
+	if version != 2 {
+		t.Errorf("Process [version=2], got [%d] expected [2]", version)
+	}
+	var expected = map[string]string{
+		"web":   "https://github.com",
+		"10:30": "mười giờ rưỡi",
+		"tab":   "a\tb\nc\\\\d",
+		"vn":    "Việt Nam",
+		"ck":    "Trân trọng,\n  Nguyễn Văn A",
+		"br":    `\{date}`,
+		"bs":    `\\{date}`,
+	}
+	if len(mTable) != len(expected) {
+		t.Errorf("Process [version 2], got [%v] expected [%v]", mTable, expected)
+	}
+	for key, value := range expected {
+		if mTable[key] != value {
+			t.Errorf("Process [%s], got [%q] expected [%q]", key, mTable[key], value)
+		}
+	}
+}
+
+func TestExpandMacroPlaceholders(t *testing.T) {
+	var table = &MacroTable{
+		version:   2,
+		now:       func() time.Time { return time.Date(2021, 9, 2, 8, 5, 0, 0, time.UTC) },
+		clipboard: func() string { return "clip" },
+	}
+	var tests = []struct {
+		text, expected string
+		nLeft          int
+	}{
+		{"Hà Nội, {date}", "Hà Nội, 02/09/2021", 0},
+		{"{TIME}", "08:05", 0},
+		{"[{clipboard}]", "[clip]", 0},
+		{"({cursor})", "()", 1},
+		{"{cursor}{cursor}ab", "ab", 2},
+		{`\{date} {x} {`, "{date} {x} {", 0},
+		{`\\{date} \\\{x}`, `\02/09/2021 \{x}`, 0},
+		{`C:\dir\\`, `C:\dir\`, 0},
+	}
+	for _, test := range tests {
+		var text, nLeft = splitMacroCursor(table.ExpandPlaceholders(test.text))
+		if text != test.expected || nLeft != test.nLeft {
+			t.Errorf("Process [%s], got [%s %d] expected [%s %d]", test.text, text, nLeft, test.expected, test.nLeft)
+		}
+	}
+}
+
+func TestExpandSlowClipboard(t *testing.T) {
+	var release = make(chan bool)
+	var table = &MacroTable{
+		version:   2,
+		clipboard: func() string { <-release; return "clip" },
+	}
+	var start = time.Now()
+	for i := 0; i < 2; i++ {
+		if text := table.ExpandPlaceholders("[{clipboard}]"); text != "[]" {
+			t.Errorf("Process [slow clipboard %d], got [%s] expected [[]]", i, text)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 4*macroClipboardTimeout {
+		t.Errorf("Process [slow clipboard], got [%v] expected at most [%v]", elapsed, 4*macroClipboardTimeout)
+	}
+	close(release)
+	for !clipboardMutex.TryLock() {
+		time.Sleep(time.Millisecond)
+	}
+	clipboardMutex.Unlock()
+}
+
+func TestMacroTableLoadFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bamboo-macro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fileName = filepath.Join(dir, "macro.text")
+	ioutil.WriteFile(fileName, []byte("# version=2\r\nurl:http\\://x:y\r\n"), 0644)
+	var table = NewMacroTable(false)
+	if err = table.LoadFromFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if text := table.GetText("url"); text != "http://x:y" {
+		t.Errorf("Process [url], got [%s] expected [http://x:y]", text)
+	}
+}
+
+func TestMacroTableLayers(t *testing.T) {
+	var dir = t.TempDir()
+	var write = func(name, text string) string {
+		var fileName = filepath.Join(dir, name)
+		ioutil.WriteFile(fileName, []byte(text), 0644)
+		return fileName
+	}
+	var files = []string{
+		write("system.text", "# DO NOT DELETE THIS LINE*** version=1 ***\nvn:Việt Nam\nbr:{x}\nbs:bác sĩ\n"),
+		write("team.text", "# DO NOT DELETE THIS LINE*** version=2 ***\nbs:bệnh sử\nhn:Hà Nội\n"),
+		filepath.Join(dir, "missing.text"),
+		write("user.text", "# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam!\n"),
+	}
+	os.Mkdir(filepath.Join(dir, "apps"), 0755)
+	write("apps/ehr-client.text", "# DO NOT DELETE THIS LINE*** version=2 ***\nha:huyết áp\nhn:\n")
+	write("apps/notes.txt", "# DO NOT DELETE THIS LINE*** version=2 ***\nha:hà\n")
+	var appFiles = getAppFiles(filepath.Join(dir, "apps"))
+	if len(appFiles) != 1 || appFiles["ehr-client"] == "" {
+		t.Errorf("Process [apps], got [%v] expected [ehr-client]", appFiles)
+	}
+	var table = NewMacroTable(false)
+	table.loadLayers(files, appFiles)
+
+	var tests = []struct {
+		wmClass  string
+		key      string
+		expected string
+	}{
+		{"", "vn", "Việt Nam!"},
+		{"", "bs", "bệnh sử"},
+		{"", "br", "{x}"},
+		{"", "hn", "Hà Nội"},
+		{"", "ha", ""},
+		{"ehr-client:Ehr-client", "ha", "huyết áp"},
+		{"ehr-client:Ehr-client", "hn", ""},
+		{"ehr-client:Ehr-client", "vn", "Việt Nam!"},
+		{"Navigator:Ehr-Client", "ha", "huyết áp"},
+		{"gnome-terminal-server:Gnome-terminal", "ha", ""},
+		{"gnome-terminal-server:Gnome-terminal", "hn", "Hà Nội"},
+	}
+	for _, test := range tests {
+		table.SetApplication(test.wmClass)
+		if text := table.ExpandPlaceholders(table.GetText(test.key)); text != test.expected {
+			t.Errorf("Process [%s] in [%s], got [%s] expected [%s]", test.key, test.wmClass, text, test.expected)
+		}
+	}
+	table.SetApplication("ehr-client:Ehr-client")
+	if table.IncludeKey("hn") || !table.IncludeKey("h") || !table.IncludeKey("ha") || table.IncludeKey("n") {
+		t.Errorf("Process [IncludeKey] in [ehr-client], got [%v %v %v %v] expected [false true true false]", table.IncludeKey("hn"), table.IncludeKey("h"), table.IncludeKey("ha"), table.IncludeKey("n"))
+	}
+	if fileName := getMactabAppFile("Bamboo", "Navigator:Ehr-Client"); filepath.Base(fileName) != "ehr-client.text" {
+		t.Errorf("Process [getMactabAppFile], got [%s] expected [ehr-client.text]", fileName)
+	}
+}
+
+func TestMacroTableFindKeys(t *testing.T) {
+	var table = NewMacroTable(true)
+	table.setTables(MacroFormatVersion,
+		map[string]string{"vn": "việt nam", "vnm": "việt nam mến yêu", "vna": "vietnam airlines", "hn": "hà nội", "ha": "hà"},
+		map[string]map[string]string{"ehr": {"ha": "huyết áp", "hn": "", "hatt": "huyết áp tâm thu"}, "other": {"vnx": "khác"}})
+	var tests = []struct {
+		wmClass  string
+		prefix   string
+		limit    int
+		expected string
+	}{
+		{"", "v", 9, "vn vna vnm"},
+		{"", "VN", 2, "vn vna"},
+		{"", "x", 9, ""},
+		{"", "h", 9, "ha hn"},
+		{"ehr:Ehr", "h", 9, "ha hatt"},
+	}
+	for _, test := range tests {
+		table.SetApplication(test.wmClass)
+		if keys := strings.Join(table.FindKeys(test.prefix, test.limit), " "); keys != test.expected {
+			t.Errorf("Process [%s] in [%s], got [%s] expected [%s]", test.prefix, test.wmClass, keys, test.expected)
+		}
+	}
+}
@@ -37,6 +37,11 @@ const (
 var embedded = flag.Bool("ibus", false, "Run the embedded ibus component")
 var version = flag.Bool("version", false, "Show version")
 var gui = flag.Bool("gui", false, "Show GUI")
+var validateIM = flag.Bool("validate-im", false, "Validate the input method read from stdin and print the text typed with the keys given as arguments")
+var importMacro = flag.String("import-macro", "", "Merge the macros of a UniKey, EVKey, CSV or Bamboo file into the macro table")
+var exportMacro = flag.String("export-macro", "", "Write the macro table to a UniKey, EVKey or CSV file")
+var macroFormat = flag.String("macro-format", "", "Format of the imported or exported macro file: unikey, evkey, csv or bamboo, guessed from the file if empty")
+var macroEngine = flag.String("macro-engine", EngineName, "Engine whose macro table is imported or exported")
 var isWayland = false
 var isGnome = false
 
@@ -60,6 +65,12 @@ func main() {
 	}
 	if *version {
 		fmt.Println(Version)
+	} else if *validateIM {
+		os.Exit(runInputMethodValidation(os.Stdin, os.Stdout, strings.Join(flag.Args(), " ")))
+	} else if *importMacro != "" {
+		os.Exit(runMacroImport(os.Stdout, *macroEngine, *importMacro, *macroFormat))
+	} else if *exportMacro != "" {
+		os.Exit(runMacroExport(os.Stdout, *macroEngine, *exportMacro, *macroFormat))
 	} else if *embedded {
 		engine := GetIBusEngineCreator()
 		bus := ibus.NewBus()
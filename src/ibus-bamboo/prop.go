@@ -35,18 +35,28 @@ const (
 	PropKeySpellCheckByRules            = "spell_check_by_rules"
 	PropKeySpellCheckByDicts            = "spell_check_by_dicts"
 	PropKeyPreeditInvisibility          = "preedit_invisibility"
-	PropKeyVnCharsetConvert             = "charset_convert_page"
+	PropKeyVnCharsetConvert             = "clipboard_charset_convert"
 	PropKeyMouseCapturing               = "mouse_capturing"
 	PropKeyMacroEnabled                 = "macro_enabled"
 	PropKeyMacroTable                   = "open_macro_table"
+	PropKeyMacroAppTable                = "open_app_macro_table"
+	PropKeyMacroCandidates              = "macro_candidates"
+	PropKeyMacroImport                  = "import_macro_table"
+	PropKeyMacroExport                  = "export_macro_table"
 	PropKeyEmojiEnabled                 = "emoji_enabled"
 	PropKeyConfiguration                = "configuration"
+	PropKeyInputMethodEditor            = "input_method_editor"
 	PropKeyPreeditElimination           = "preedit_elimination"
 	PropKeyInputModeLookupTable         = "input_mode_lookup_table"
 	PropKeyInputModeLookupTableShortcut = "input_mode_lookup_table_shortcut"
 	PropKeyAutoCapitalizeMacro          = "auto_capitalize_macro"
 	PropKeyIMQuickSwitchEnabled         = "im_quick_switch"
 	PropKeyRestoreKeyStrokes            = "restore_key_strokes"
+	PropKeyWordSuggestion               = "word_suggestion"
+	PropKeyFrequencyLearning            = "frequency_learning"
+	PropKeyDiacriticRestoration         = "diacritic_restoration"
+	PropKeyTypoCorrection               = "typo_correction"
+	PropKeyEmojiShortcode               = "emoji_shortcode"
 )
 
 var IBusSeparator = &ibus.Property{
@@ -202,13 +212,37 @@ func GetCharsetPropListByConfig(c *Config) *ibus.PropList {
 			Name:      "IBusProperty",
 			Key:       PropKeyVnCharsetConvert,
 			Type:      ibus.PROP_TYPE_NORMAL,
-			Label:     dbus.MakeVariant(ibus.NewText("Chuyển mã online")),
-			Tooltip:   dbus.MakeVariant(ibus.NewText("")),
+			Label:     dbus.MakeVariant(ibus.NewText("Chuyển mã clipboard")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText(c.ClipboardSourceCharset + " → " + c.ClipboardTargetCharset)),
 			Sensitive: true,
 			Visible:   true,
 			Symbol:    dbus.MakeVariant(ibus.NewText("C")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "-",
+			Type:      ibus.PROP_TYPE_MENU,
+			Label:     dbus.MakeVariant(ibus.NewText("Bảng mã nguồn")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Bảng mã của clipboard")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("")),
+			SubProps: dbus.MakeVariant(getClipboardCharsetPropList("ClipboardSourceCharset",
+				append([]string{ClipboardCharsetAuto}, bamboo.GetCharsetNames()...), c.ClipboardSourceCharset)),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "-",
+			Type:      ibus.PROP_TYPE_MENU,
+			Label:     dbus.MakeVariant(ibus.NewText("Bảng mã đích")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Bảng mã sau khi chuyển")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("")),
+			SubProps: dbus.MakeVariant(getClipboardCharsetPropList("ClipboardTargetCharset",
+				bamboo.GetCharsetNames(), c.ClipboardTargetCharset)),
+		},
 		IBusSeparator)
 	for _, charset := range bamboo.GetCharsetNames() {
 		var state = ibus.PROP_STATE_UNCHECKED
@@ -232,6 +266,29 @@ func GetCharsetPropListByConfig(c *Config) *ibus.PropList {
 	return ibus.NewPropList(charsetProperties...)
 }
 
+func getClipboardCharsetPropList(keyPrefix string, charsets []string, selected string) *ibus.PropList {
+	var charsetProperties []*ibus.Property
+	for _, charset := range charsets {
+		var state = ibus.PROP_STATE_UNCHECKED
+		if charset == selected {
+			state = ibus.PROP_STATE_CHECKED
+		}
+		charsetProperties = append(charsetProperties, &ibus.Property{
+			Name:      "IBusProperty",
+			Key:       keyPrefix + "::" + charset,
+			Type:      ibus.PROP_TYPE_RADIO,
+			Label:     dbus.MakeVariant(ibus.NewText(charset)),
+			Tooltip:   dbus.MakeVariant(ibus.NewText(keyPrefix + ": " + charset)),
+			Sensitive: true,
+			Visible:   true,
+			State:     state,
+			Symbol:    dbus.MakeVariant(ibus.NewText("U")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		})
+	}
+	return ibus.NewPropList(charsetProperties...)
+}
+
 func GetIMPropListByConfig(c *Config) *ibus.PropList {
 	var imProperties []*ibus.Property
 	imProperties = append(imProperties,
@@ -246,6 +303,17 @@ func GetIMPropListByConfig(c *Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("BC")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyInputMethodEditor,
+			Type:      ibus.PROP_TYPE_NORMAL,
+			Label:     dbus.MakeVariant(ibus.NewText("Trình soạn kiểu gõ")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Soạn và kiểm tra các kiểu gõ")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("BE")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 		IBusSeparator,
 	)
 	for im := range c.InputMethodDefinitions {
@@ -273,6 +341,7 @@ func GetIMPropListByConfig(c *Config) *ibus.PropList {
 func GetMacroPropListByConfig(c *Config) *ibus.PropList {
 	macroChecked := ibus.PROP_STATE_UNCHECKED
 	autoCapitalizeMacro := ibus.PROP_STATE_UNCHECKED
+	macroCandidates := ibus.PROP_STATE_UNCHECKED
 
 	if c.IBflags&IBmacroEnabled != 0 {
 		macroChecked = ibus.PROP_STATE_CHECKED
@@ -280,6 +349,9 @@ func GetMacroPropListByConfig(c *Config) *ibus.PropList {
 	if c.IBflags&IBautoCapitalizeMacro != 0 {
 		autoCapitalizeMacro = ibus.PROP_STATE_CHECKED
 	}
+	if c.IBflags&IBmacroCandidates != 0 {
+		macroCandidates = ibus.PROP_STATE_CHECKED
+	}
 	return ibus.NewPropList(
 		&ibus.Property{
 			Name:      "IBusProperty",
@@ -305,6 +377,18 @@ func GetMacroPropListByConfig(c *Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("C")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyMacroCandidates,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gợi ý từ gõ tắt")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Show the macros starting with the typed text")),
+			Sensitive: true,
+			Visible:   true,
+			State:     macroCandidates,
+			Symbol:    dbus.MakeVariant(ibus.NewText("G")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 		&ibus.Property{
 			Name:      "IBusProperty",
 			Key:       PropKeyMacroTable,
@@ -316,6 +400,39 @@ func GetMacroPropListByConfig(c *Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("O")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyMacroAppTable,
+			Type:      ibus.PROP_TYPE_NORMAL,
+			Label:     dbus.MakeVariant(ibus.NewText("Mở bảng gõ tắt của ứng dụng")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Macros used only in the focused application")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("A")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyMacroImport,
+			Type:      ibus.PROP_TYPE_NORMAL,
+			Label:     dbus.MakeVariant(ibus.NewText("Nhập từ UniKey, EVKey, CSV...")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Import macros from UniKey, EVKey or CSV")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("I")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyMacroExport,
+			Type:      ibus.PROP_TYPE_NORMAL,
+			Label:     dbus.MakeVariant(ibus.NewText("Xuất ra UniKey, EVKey, CSV...")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Export macros to UniKey, EVKey or CSV")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("E")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 	)
 }
 
@@ -334,7 +451,51 @@ func GetSpellCheckingPropListByConfig(c *Config) *ibus.PropList {
 	if c.IBflags&IBspellCheckWithDicts != 0 {
 		spellCheckByDicts = ibus.PROP_STATE_CHECKED
 	}
-	return ibus.NewPropList(
+	typoCorrectionChecked := ibus.PROP_STATE_UNCHECKED
+	if c.IBflags&IBtypoCorrection != 0 {
+		typoCorrectionChecked = ibus.PROP_STATE_CHECKED
+	}
+	wordSuggestionChecked := ibus.PROP_STATE_UNCHECKED
+	if c.IBflags&IBwordSuggestion != 0 {
+		wordSuggestionChecked = ibus.PROP_STATE_CHECKED
+	}
+	diacriticRestorationChecked := ibus.PROP_STATE_UNCHECKED
+	if c.IBflags&IBdiacriticRestoration != 0 {
+		diacriticRestorationChecked = ibus.PROP_STATE_CHECKED
+	}
+	frequencyLearningChecked := ibus.PROP_STATE_UNCHECKED
+	if c.IBflags&IBfrequencyLearning != 0 {
+		frequencyLearningChecked = ibus.PROP_STATE_CHECKED
+	}
+	var profileLabels = map[string]string{
+		bamboo.StrictSpellingProfile.Name:     "Luật ghép vần: chuẩn",
+		bamboo.PermissiveSpellingProfile.Name: "Luật ghép vần: chấp nhận từ mượn, phương ngữ",
+		bamboo.MinoritySpellingProfile.Name:   "Luật ghép vần: thêm địa danh, tên dân tộc thiểu số",
+	}
+	var profileProperties []*ibus.Property
+	for _, profile := range bamboo.GetSpellingProfileNames() {
+		var state = ibus.PROP_STATE_UNCHECKED
+		if profile == c.SpellingProfile || (c.SpellingProfile == "" && profile == bamboo.StrictSpellingProfile.Name) {
+			state = ibus.PROP_STATE_CHECKED
+		}
+		var label = profileLabels[profile]
+		if label == "" {
+			label = profile
+		}
+		profileProperties = append(profileProperties, &ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "SpellingProfile::" + profile,
+			Type:      ibus.PROP_TYPE_RADIO,
+			Label:     dbus.MakeVariant(ibus.NewText(label)),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("SpellingProfile: " + profile)),
+			Sensitive: true,
+			Visible:   true,
+			State:     state,
+			Symbol:    dbus.MakeVariant(ibus.NewText("R")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		})
+	}
+	var properties = []*ibus.Property{
 		&ibus.Property{
 			Name:      "IBusProperty",
 			Key:       PropKeyEnableSpellCheck,
@@ -372,7 +533,59 @@ func GetSpellCheckingPropListByConfig(c *Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("O")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
-	)
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyTypoCorrection,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Tự sửa lỗi gõ sai")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Correct the mistyped words on a word break")),
+			Sensitive: true,
+			Visible:   true,
+			State:     typoCorrectionChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("T")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		IBusSeparator,
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyWordSuggestion,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gợi ý từ")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Word suggestion")),
+			Sensitive: true,
+			Visible:   true,
+			State:     wordSuggestionChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("G")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyDiacriticRestoration,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Thêm dấu cho câu gõ không dấu")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Type without accents, pick the accented sentence on a word break")),
+			Sensitive: true,
+			Visible:   true,
+			State:     diacriticRestorationChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("D")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyFrequencyLearning,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Ghi nhớ từ hay dùng")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Learn from the words you type (uncheck to erase the data)")),
+			Sensitive: true,
+			Visible:   true,
+			State:     frequencyLearningChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("L")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+	}
+	properties = append(properties, IBusSeparator)
+	properties = append(properties, profileProperties...)
+	return ibus.NewPropList(properties...)
 }
 
 func GetOptionsPropListByConfig(c *Config) *ibus.PropList {
@@ -398,6 +611,10 @@ func GetOptionsPropListByConfig(c *Config) *ibus.PropList {
 	if c.IBflags&IBpreeditElimination != 0 {
 		x11FakeBackspaceChecked = ibus.PROP_STATE_CHECKED
 	}
+	emojiShortcodeChecked := ibus.PROP_STATE_UNCHECKED
+	if c.IBflags&IBemojiShortcode != 0 {
+		emojiShortcodeChecked = ibus.PROP_STATE_CHECKED
+	}
 
 	return ibus.NewPropList(
 		&ibus.Property{
@@ -448,6 +665,40 @@ func GetOptionsPropListByConfig(c *Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("F")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "-",
+			Type:      ibus.PROP_TYPE_MENU,
+			Label:     dbus.MakeVariant(ibus.NewText("Nhập ký tự Unicode")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Dạng của ký tự được nhập theo mã hoặc tên")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("")),
+			SubProps:  dbus.MakeVariant(getUnicodeFormPropList(c)),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyEmojiShortcode,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gõ emoji bằng :tên:")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Complete the emoji shortnames such as :smile: while typing")),
+			Sensitive: true,
+			Visible:   true,
+			State:     emojiShortcodeChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("E")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "-",
+			Type:      ibus.PROP_TYPE_MENU,
+			Label:     dbus.MakeVariant(ibus.NewText("Màu da của emoji")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Emoji skin tone")),
+			Sensitive: true,
+			Visible:   true,
+			Symbol:    dbus.MakeVariant(ibus.NewText("")),
+			SubProps:  dbus.MakeVariant(getEmojiSkinTonePropList(c)),
+		},
 		&ibus.Property{
 			Name:      "IBusProperty",
 			Key:       PropKeyPreeditElimination,
@@ -463,6 +714,59 @@ func GetOptionsPropListByConfig(c *Config) *ibus.PropList {
 	)
 }
 
+func getUnicodeFormPropList(c *Config) *ibus.PropList {
+	var formLabels = map[string]string{
+		UnicodeFormCharacter: "Ký tự (ạ)",
+		UnicodeFormCodePoint: "Mã (U+1EA1)",
+		UnicodeFormHTML:      "HTML (&#x1EA1;)",
+		UnicodeFormEscape:    "Escape (\\u1EA1)",
+	}
+	var formProperties []*ibus.Property
+	for _, form := range unicodeForms {
+		var state = ibus.PROP_STATE_UNCHECKED
+		if form == c.UnicodeOutputForm || (c.UnicodeOutputForm == "" && form == UnicodeFormCharacter) {
+			state = ibus.PROP_STATE_CHECKED
+		}
+		formProperties = append(formProperties, &ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "UnicodeOutputForm::" + form,
+			Type:      ibus.PROP_TYPE_RADIO,
+			Label:     dbus.MakeVariant(ibus.NewText(formLabels[form])),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("UnicodeOutputForm: " + form)),
+			Sensitive: true,
+			Visible:   true,
+			State:     state,
+			Symbol:    dbus.MakeVariant(ibus.NewText("U")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		})
+	}
+	return ibus.NewPropList(formProperties...)
+}
+
+func getEmojiSkinTonePropList(c *Config) *ibus.PropList {
+	var toneLabels = []string{"👍 Mặc định", "👍🏻 Sáng", "👍🏼 Sáng vừa", "👍🏽 Trung bình", "👍🏾 Tối vừa", "👍🏿 Tối"}
+	var toneProperties []*ibus.Property
+	for tone, label := range toneLabels {
+		var state = ibus.PROP_STATE_UNCHECKED
+		if tone == c.EmojiSkinTone {
+			state = ibus.PROP_STATE_CHECKED
+		}
+		toneProperties = append(toneProperties, &ibus.Property{
+			Name:      "IBusProperty",
+			Key:       "EmojiSkinTone::" + strconv.Itoa(tone),
+			Type:      ibus.PROP_TYPE_RADIO,
+			Label:     dbus.MakeVariant(ibus.NewText(label)),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("EmojiSkinTone: " + strconv.Itoa(tone))),
+			Sensitive: true,
+			Visible:   true,
+			State:     state,
+			Symbol:    dbus.MakeVariant(ibus.NewText("E")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		})
+	}
+	return ibus.NewPropList(toneProperties...)
+}
+
 func GetDefaultModePropListByConfig(c *Config) *ibus.PropList {
 	var inputModes = []string{
 		"1. Pre-edit (có gạch chân)",
@@ -0,0 +1,161 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+const SuggestionMinPrefixLen = 2
+const SuggestionMaxCandidates = 45
+
+// SuggestionMaxSearch is the number of dictionary entries looked at for a
+// prefix, the shorter words are looked at first
+const SuggestionMaxSearch = 20 * SuggestionMaxCandidates
+
+// suggestionTrie holds the *TrieNode in use, it is replaced when the
+// dictionaries are reloaded
+var suggestionTrie atomic.Value
+
+func getSuggestionTrie() *TrieNode {
+	trie, _ := suggestionTrie.Load().(*TrieNode)
+	return trie
+}
+
+// loadSuggestionTrie indexes dictionary words by their accent-less form,
+// so that a partially typed syllable (e.g. "viê") can find "việt" and "việt nam".
+func loadSuggestionTrie(dataFiles ...string) (*TrieNode, error) {
+	var trie = NewTrie()
+	for _, dataFile := range dataFiles {
+		f, err := os.Open(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		rd := bufio.NewReader(f)
+		for {
+			line, _, err := rd.ReadLine()
+			if err != nil {
+				break
+			}
+			var word = strings.ToLower(strings.TrimSpace(string(line)))
+			if len(word) == 0 {
+				continue
+			}
+			InsertTrie(trie, removeVnAccents(word), word)
+		}
+		f.Close()
+	}
+	return trie, nil
+}
+
+func removeVnAccents(str string) string {
+	var runes = []rune(str)
+	for i, chr := range runes {
+		runes[i] = bamboo.AddMarkToChar(bamboo.AddToneToChar(chr, 0), 0)
+	}
+	return string(runes)
+}
+
+// isCompatibleWithPrefix checks whether word can be reached by typing more
+// keys after prefix: every rune of the prefix must be equal to the rune at the
+// same position of the word, or be a form of it that lacks the tone/mark.
+func isCompatibleWithPrefix(word, prefix string) bool {
+	var wordRunes = []rune(word)
+	var prefixRunes = []rune(prefix)
+	if len(prefixRunes) > len(wordRunes) {
+		return false
+	}
+	for i, chr := range prefixRunes {
+		var wChr = wordRunes[i]
+		if bamboo.FindToneFromChar(chr) == bamboo.ToneNone {
+			wChr = bamboo.AddToneToChar(wChr, 0)
+		}
+		if bamboo.AddMarkToChar(chr, 0) == chr {
+			wChr = bamboo.AddMarkToChar(wChr, 0)
+		}
+		if wChr != chr {
+			return false
+		}
+	}
+	return true
+}
+
+func findSuggestions(trie *TrieNode, prefix string) []string {
+	var lowerPrefix = strings.ToLower(prefix)
+	if len([]rune(lowerPrefix)) < SuggestionMinPrefixLen || trie == nil {
+		return nil
+	}
+	var words []string
+	for _, value := range FindPrefixN(trie, removeVnAccents(lowerPrefix), SuggestionMaxSearch) {
+		for _, word := range strings.Split(value, ":") {
+			if word != lowerPrefix && isCompatibleWithPrefix(word, lowerPrefix) {
+				words = append(words, word)
+			}
+		}
+	}
+	sort.Sort(bySuggestionRank(words))
+	var scores = make(map[string]float64, len(words))
+	for _, word := range words {
+		scores[word] = userFrequency.Score(word)
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		return scores[words[i]] > scores[words[j]]
+	})
+	if len(words) > SuggestionMaxCandidates {
+		words = words[:SuggestionMaxCandidates]
+	}
+	var isUpperCase = unicode.IsUpper([]rune(prefix)[0])
+	for i, word := range words {
+		if isUpperCase {
+			var runes = []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+	}
+	return words
+}
+
+// single syllables come first, then the shorter words
+type bySuggestionRank []string
+
+func (s bySuggestionRank) Less(i, j int) bool {
+	var iWords, jWords = strings.Count(s[i], " "), strings.Count(s[j], " ")
+	if iWords != jWords {
+		return iWords < jWords
+	}
+	var iLen, jLen = len([]rune(s[i])), len([]rune(s[j]))
+	if iLen != jLen {
+		return iLen < jLen
+	}
+	return s[i] < s[j]
+}
+func (s bySuggestionRank) Len() int {
+	return len(s)
+}
+func (s bySuggestionRank) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindSuggestions(t *testing.T) {
+	var trie, err = loadSuggestionTrie("../../"+DictVietnameseCm, "../../"+DictVietnameseCompound)
+	if err != nil {
+		t.Fatalf("Loading suggestion trie, got error %v", err)
+	}
+	var words = findSuggestions(trie, "việ")
+	if !inStringList(words, "việt") {
+		t.Errorf("Suggestions for việ, expected việt, got %v", words)
+	}
+	if !inStringList(words, "việt nam") {
+		t.Errorf("Suggestions for việ, expected việt nam, got %v", words)
+	}
+	if inStringList(words, "viết") {
+		t.Errorf("Suggestions for việ, unexpected viết, got %v", words)
+	}
+	if words[0] != "việc" {
+		t.Errorf("Suggestions for việ, expected việc first, got %v", words)
+	}
+	var capitalWords = findSuggestions(trie, "Hà")
+	if !inStringList(capitalWords, "Hà nội") {
+		t.Errorf("Suggestions for Hà, expected Hà nội, got %v", capitalWords)
+	}
+	if findSuggestions(trie, "v") != nil {
+		t.Errorf("Suggestions for v, expected nil")
+	}
+	// the search stops after the shorter words
+	var values = FindPrefixN(trie, "ng", 10)
+	if len(values) != 10 || strings.Contains(values[0], " ") {
+		t.Errorf("FindPrefixN [ng], got %q expected 10 values, the single syllables first", values)
+	}
+}
+
+func TestIsCompatibleWithPrefix(t *testing.T) {
+	if !isCompatibleWithPrefix("đường", "đươ") {
+		t.Errorf("isCompatibleWithPrefix(đường, đươ), expected true")
+	}
+	if !isCompatibleWithPrefix("đường", "du") {
+		t.Errorf("isCompatibleWithPrefix(đường, du), expected true")
+	}
+	if isCompatibleWithPrefix("dưới", "đư") {
+		t.Errorf("isCompatibleWithPrefix(dưới, đư), expected false")
+	}
+	if isCompatibleWithPrefix("học", "hó") {
+		t.Errorf("isCompatibleWithPrefix(học, hó), expected false")
+	}
+}
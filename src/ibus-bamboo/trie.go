@@ -19,6 +19,8 @@
 
 package main
 
+import "sort"
+
 type TrieNode struct {
 	isWord   bool
 	value    string
@@ -51,17 +53,10 @@ func InsertTrie(r *TrieNode, word, value string) {
 	currentNode.isWord = true
 }
 
-func dfs(trie *TrieNode, lookup map[string]string, s string) {
-	if trie.isWord {
-		lookup[s] = trie.value
-	}
-	for chr, t := range trie.Children {
-		var key = s + string(chr)
-		dfs(t, lookup, key)
-	}
-}
-
-func FindPrefix(r *TrieNode, prefix string) map[string]string {
+// FindPrefixN returns at most max values of the words starting with the prefix,
+// level by level so that the shorter words come first and a short prefix does
+// not walk the whole trie
+func FindPrefixN(r *TrieNode, prefix string, max int) []string {
 	var currentNode = r
 	for _, c := range prefix {
 		if cn := currentNode.Children[c]; cn != nil {
@@ -70,7 +65,27 @@ func FindPrefix(r *TrieNode, prefix string) map[string]string {
 			return nil
 		}
 	}
-	var lookup = make(map[string]string)
-	dfs(currentNode, lookup, prefix)
-	return lookup
+	var values []string
+	var level = []*TrieNode{currentNode}
+	for len(level) > 0 && len(values) < max {
+		var next []*TrieNode
+		for _, node := range level {
+			if node.isWord {
+				values = append(values, node.value)
+				if len(values) >= max {
+					break
+				}
+			}
+			var keys = make([]rune, 0, len(node.Children))
+			for chr := range node.Children {
+				keys = append(keys, chr)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+			for _, chr := range keys {
+				next = append(next, node.Children[chr])
+			}
+		}
+		level = next
+	}
+	return values
 }
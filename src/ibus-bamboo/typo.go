@@ -0,0 +1,226 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+// typoDictionary holds the *TypoDictionary in use, it is replaced when the
+// dictionaries are reloaded
+var typoDictionary atomic.Value
+
+func getTypoDictionary() *TypoDictionary {
+	dictionary, _ := typoDictionary.Load().(*TypoDictionary)
+	return dictionary
+}
+
+type typoCandidate struct {
+	word  string
+	runes []rune
+	order int
+}
+
+// TypoDictionary keeps the syllables by their length, a word is only
+// compared to the syllables which are short or long enough to be within the
+// maximum edit distance
+type TypoDictionary struct {
+	words    map[string]bool
+	byLength map[int][]typoCandidate
+}
+
+func newTypoDictionary(syllables []string) *TypoDictionary {
+	var dictionary = &TypoDictionary{
+		words:    make(map[string]bool, len(syllables)),
+		byLength: map[int][]typoCandidate{},
+	}
+	for i, syllable := range syllables {
+		if dictionary.words[syllable] {
+			continue
+		}
+		dictionary.words[syllable] = true
+		var runes = []rune(syllable)
+		dictionary.byLength[len(runes)] = append(dictionary.byLength[len(runes)], typoCandidate{syllable, runes, i})
+	}
+	return dictionary
+}
+
+// loadTypoDictionary keeps the syllables of the dictionaries, the compound
+// words are left out as a typo is corrected one syllable at a time
+func loadTypoDictionary(dataFiles ...string) (*TypoDictionary, error) {
+	words, err := loadDictionary(dataFiles...)
+	if err != nil {
+		return nil, err
+	}
+	var syllables []string
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if !strings.ContainsRune(word, ' ') {
+			syllables = append(syllables, word)
+		}
+	}
+	return newTypoDictionary(syllables), nil
+}
+
+// typoMinSwapLength keeps the short English words, e.g. "is" or "at", from
+// being swapped into a syllable
+const typoMinSwapLength = 4
+
+// typoMaxDistance allows one typo in a short syllable, two in a longer one
+func typoMaxDistance(word []rune) int {
+	if len(word) <= 5 {
+		return 1
+	}
+	return 2
+}
+
+// correctTypo finds the syllable of the dictionary which is the nearest to the
+// word, e.g. "trnog" -> "trong", "đươc" -> "được". A candidate must be valid
+// according to the spelling profile. Between the candidates at the same
+// distance, the one which only differs by its tone and marks wins, then the
+// one which comes first in the dictionary.
+// A word without any Vietnamese letter may as well be English or an
+// abbreviation, it is only corrected when the letters of a longer word were
+// swapped.
+func correctTypo(dictionary *TypoDictionary, profile *bamboo.SpellingProfile, word string) (string, bool) {
+	if dictionary == nil {
+		return "", false
+	}
+	var lowerWord = strings.ToLower(word)
+	var wordRunes = []rune(lowerWord)
+	if len(wordRunes) < 2 || dictionary.words[lowerWord] {
+		return "", false
+	}
+	for _, chr := range wordRunes {
+		if !unicode.IsLetter(chr) {
+			return "", false
+		}
+	}
+	var swapOnly = !bamboo.HasAnyVietnameseRune(lowerWord)
+	if swapOnly && (len(wordRunes) < typoMinSwapLength || profile.IsValidWord(lowerWord)) {
+		// a valid syllable without the tone, e.g. "hoc", may be any of học,
+		// hóc and hộc, it is left as it is
+		return "", false
+	}
+	var maxDistance = typoMaxDistance(wordRunes)
+	var minLength, maxLength = len(wordRunes) - maxDistance, len(wordRunes) + maxDistance
+	if swapOnly {
+		minLength, maxLength = len(wordRunes), len(wordRunes)
+	}
+	var bestDistance = maxDistance + 1
+	var best *typoCandidate
+	var bestSameLetters bool
+	for length := minLength; length <= maxLength; length++ {
+		if abs(length-len(wordRunes)) > bestDistance {
+			continue
+		}
+		var candidates = dictionary.byLength[length]
+		for i := range candidates {
+			var candidate = &candidates[i]
+			if swapOnly && !isSwapOf(wordRunes, candidate.runes) {
+				continue
+			}
+			var distance = editDistance(wordRunes, candidate.runes)
+			if distance > bestDistance {
+				continue
+			}
+			var sameLetters = removeVnAccents(candidate.word) == removeVnAccents(lowerWord)
+			if distance == bestDistance && !isBetterTypoCandidate(candidate, sameLetters, best, bestSameLetters) {
+				continue
+			}
+			if !profile.IsValidWord(candidate.word) {
+				continue
+			}
+			best, bestDistance, bestSameLetters = candidate, distance, sameLetters
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return applyWordCase(word, best.word), true
+}
+
+// isBetterTypoCandidate breaks a tie between two candidates at the same distance
+func isBetterTypoCandidate(candidate *typoCandidate, sameLetters bool, best *typoCandidate, bestSameLetters bool) bool {
+	if best == nil || sameLetters != bestSameLetters {
+		return sameLetters
+	}
+	return candidate.order < best.order
+}
+
+// isSwapOf reports whether b has the same letters as a in another order
+func isSwapOf(a, b []rune) bool {
+	var counts = map[rune]int{}
+	for _, chr := range a {
+		counts[chr]++
+	}
+	for _, chr := range b {
+		counts[chr]--
+		if counts[chr] < 0 {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// editDistance counts the insertions, deletions, substitutions and
+// transpositions of two adjacent letters between a and b
+func editDistance(a, b []rune) int {
+	var rows = make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			var cost = 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			var d = minInt(rows[i-1][j]+1, minInt(rows[i][j-1]+1, rows[i-1][j-1]+cost))
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d = minInt(d, rows[i-2][j-2]+1)
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+func TestCorrectTypo(t *testing.T) {
+	var dictionary, err = loadTypoDictionary("../../" + DictVietnameseCm)
+	if err != nil {
+		t.Fatalf("Loading typo dictionary, got error %v", err)
+	}
+	for word, expected := range map[string]string{
+		"trnog":  "trong",
+		"ngưười": "người",
+		"nguời":  "người",
+		"đươc":   "được",
+		"Trnog":  "Trong",
+		"TRNOG":  "TRONG",
+	} {
+		var corrected, ok = correctTypo(dictionary, bamboo.StrictSpellingProfile, word)
+		if !ok || corrected != expected {
+			t.Errorf("correctTypo [%s], got [%s %v] expected [%s]", word, corrected, ok, expected)
+		}
+	}
+	for _, word := range []string{"trong", "được", "hoc", "a", "xyzxyz", "vn2", "and", "from", "ok", "lol", "is"} {
+		if corrected, ok := correctTypo(dictionary, bamboo.StrictSpellingProfile, word); ok {
+			t.Errorf("correctTypo [%s], got [%s] expected no correction", word, corrected)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"trnog", "trong", 1},
+		{"đươc", "được", 1},
+		{"ngưười", "người", 1},
+		{"abc", "", 3},
+		{"tôi", "tôi", 0},
+	} {
+		if d := editDistance([]rune(tc.a), []rune(tc.b)); d != tc.expected {
+			t.Errorf("editDistance [%s] [%s], got [%d] expected [%d]", tc.a, tc.b, d, tc.expected)
+		}
+	}
+}
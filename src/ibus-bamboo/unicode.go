@@ -0,0 +1,250 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The forms of the character committed by the Unicode input mode
+const (
+	UnicodeFormCharacter = "Character"
+	UnicodeFormCodePoint = "CodePoint"
+	UnicodeFormHTML      = "HTML"
+	UnicodeFormEscape    = "Escape"
+)
+
+var unicodeForms = []string{UnicodeFormCharacter, UnicodeFormCodePoint, UnicodeFormHTML, UnicodeFormEscape}
+
+const (
+	UnicodeMaxPageSize   = 9
+	UnicodeMaxCandidates = 10 * UnicodeMaxPageSize
+)
+
+// unicodeNames holds the *UnicodeNames in use, it is replaced when the
+// dictionaries are reloaded
+var unicodeNames atomic.Value
+
+func getUnicodeNames() *UnicodeNames {
+	names, _ := unicodeNames.Load().(*UnicodeNames)
+	return names
+}
+
+// UnicodeNames holds the names of the characters in code point order
+type UnicodeNames struct {
+	codePoints []rune
+	names      []string
+	words      [][]string
+	index      map[rune]int
+}
+
+// loadUnicodeNames reads the lines "XXXX;NAME", the lines starting with "#"
+// are comments
+func loadUnicodeNames(dataFile string) (*UnicodeNames, error) {
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var n = &UnicodeNames{index: map[rune]int{}}
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var parts = strings.SplitN(line, ";", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		codePoint, ok := parseCodePoint(parts[0])
+		if !ok {
+			continue
+		}
+		var name = strings.ToLower(strings.TrimSpace(parts[1]))
+		n.index[codePoint] = len(n.codePoints)
+		n.codePoints = append(n.codePoints, codePoint)
+		n.names = append(n.names, name)
+		n.words = append(n.words, strings.Fields(name))
+	}
+	return n, scanner.Err()
+}
+
+// Name returns the name of the character in lower case, or "" if it is unknown
+func (n *UnicodeNames) Name(codePoint rune) string {
+	if n == nil {
+		return ""
+	}
+	if i, found := n.index[codePoint]; found {
+		return n.names[i]
+	}
+	return ""
+}
+
+// Search returns the characters whose names have a word starting with every
+// word of the query, e.g. "a acu" finds "latin small letter a with acute". The
+// names equal to the query come first, then the ones which have every word of
+// the query as a whole word, then the shortest ones.
+func (n *UnicodeNames) Search(query string, max int) []rune {
+	var queryWords = strings.Fields(strings.ToLower(query))
+	if n == nil || len(queryWords) == 0 {
+		return nil
+	}
+	type match struct {
+		index int
+		rank  int
+	}
+	var matches []match
+	for i, words := range n.words {
+		var rank, ok = matchNameWords(words, queryWords)
+		if !ok {
+			continue
+		}
+		if n.names[i] == strings.Join(queryWords, " ") {
+			rank = -1
+		}
+		matches = append(matches, match{i, rank})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return len(n.names[matches[i].index]) < len(n.names[matches[j].index])
+	})
+	var codePoints []rune
+	for _, m := range matches {
+		if len(codePoints) >= max {
+			break
+		}
+		codePoints = append(codePoints, n.codePoints[m.index])
+	}
+	return codePoints
+}
+
+// matchNameWords tells whether every word of the query starts a word of the
+// name, the rank is the number of words of the query which are not whole words
+// of the name
+func matchNameWords(words, queryWords []string) (int, bool) {
+	var rank = 0
+	for _, queryWord := range queryWords {
+		var found, whole = false, false
+		for _, word := range words {
+			if word == queryWord {
+				found, whole = true, true
+				break
+			}
+			if strings.HasPrefix(word, queryWord) {
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+		if !whole {
+			rank++
+		}
+	}
+	return rank, true
+}
+
+// parseCodePoint parses the hexadecimal code point of a character, the
+// surrogates are not characters
+func parseCodePoint(hex string) (rune, bool) {
+	if hex == "" || len(hex) > 6 {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || value > 0x10ffff || (value >= 0xd800 && value <= 0xdfff) {
+		return 0, false
+	}
+	return rune(value), true
+}
+
+func isHexDigit(key rune) bool {
+	return (key >= '0' && key <= '9') || (key >= 'a' && key <= 'f') || (key >= 'A' && key <= 'F')
+}
+
+func isHexString(str string) bool {
+	for _, c := range str {
+		if !isHexDigit(c) {
+			return false
+		}
+	}
+	return str != ""
+}
+
+// splitCodePointQuery strips the "u+", "U+" or "0x" prefix of a code point,
+// the prefix tells that the query is a code point rather than a name
+func splitCodePointQuery(query string) (string, bool) {
+	for _, prefix := range []string{"u+", "U+", "0x", "0X"} {
+		if strings.HasPrefix(query, prefix) {
+			return query[len(prefix):], true
+		}
+	}
+	return query, false
+}
+
+// searchUnicode returns the characters of the query, the character of the code
+// point comes first if the query is a hexadecimal number, the characters whose
+// names match the query follow
+func searchUnicode(names *UnicodeNames, query string, max int) []rune {
+	var codePoints []rune
+	var hex, _ = splitCodePointQuery(strings.TrimSpace(query))
+	var codePoint, isCodePoint = parseCodePoint(hex)
+	if isCodePoint {
+		codePoints = append(codePoints, codePoint)
+	}
+	for _, c := range names.Search(query, max) {
+		if len(codePoints) >= max {
+			break
+		}
+		if !isCodePoint || c != codePoint {
+			codePoints = append(codePoints, c)
+		}
+	}
+	return codePoints
+}
+
+// formatCodePoint renders the character in the given form, e.g. "ạ", "U+1EA1",
+// "&#x1EA1;" or "\u1EA1"
+func formatCodePoint(codePoint rune, form string) string {
+	switch form {
+	case UnicodeFormCodePoint:
+		return fmt.Sprintf("U+%04X", codePoint)
+	case UnicodeFormHTML:
+		return fmt.Sprintf("&#x%X;", codePoint)
+	case UnicodeFormEscape:
+		if codePoint > 0xffff {
+			return fmt.Sprintf("\\U%08X", codePoint)
+		}
+		return fmt.Sprintf("\\u%04X", codePoint)
+	}
+	return string(codePoint)
+}
+
+func isValidUnicodeForm(form string) bool {
+	return inStringList(unicodeForms, form)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCodePoint(t *testing.T) {
+	for hex, expected := range map[string]rune{"1ea1": 'ạ', "1EA1": 'ạ', "41": 'A', "1f600": '😀', "10ffff": 0x10ffff} {
+		if codePoint, ok := parseCodePoint(hex); !ok || codePoint != expected {
+			t.Errorf("Parse [%s], got [%U] expected [%U]", hex, codePoint, expected)
+		}
+	}
+	for _, hex := range []string{"", "110000", "d800", "dfff", "xyz", "0000041"} {
+		if codePoint, ok := parseCodePoint(hex); ok {
+			t.Errorf("Parse [%s], got [%U] expected none", hex, codePoint)
+		}
+	}
+}
+
+func TestFormatCodePoint(t *testing.T) {
+	for form, expected := range map[string]string{
+		UnicodeFormCharacter: "ạ",
+		UnicodeFormCodePoint: "U+1EA1",
+		UnicodeFormHTML:      "&#x1EA1;",
+		UnicodeFormEscape:    `\u1EA1`,
+	} {
+		if text := formatCodePoint('ạ', form); text != expected {
+			t.Errorf("Format [ạ] as [%s], got [%s] expected [%s]", form, text, expected)
+		}
+	}
+	if text := formatCodePoint('😀', UnicodeFormEscape); text != `\U0001F600` {
+		t.Errorf("Format [😀] as [%s], got [%s] expected [%s]", UnicodeFormEscape, text, `\U0001F600`)
+	}
+}
+
+func TestSearchUnicode(t *testing.T) {
+	var names, err = loadUnicodeNames("../../" + DictUnicodeNames)
+	if err != nil {
+		t.Fatalf("Loading Unicode names, got error %v", err)
+	}
+	if name := names.Name('ạ'); name != "latin small letter a with dot below" {
+		t.Errorf("Name [ạ], got [%s] expected [latin small letter a with dot below]", name)
+	}
+	var tests = []struct {
+		query    string
+		expected rune
+	}{
+		{"1ea1", 'ạ'},
+		{"u+1ea1", 'ạ'},
+		{"latin small letter a with dot below", 'ạ'},
+		{"small a dot bel", 'ạ'},
+		{"em dash", '—'},
+		{"grinning face", '😀'},
+	}
+	for _, test := range tests {
+		var codePoints = searchUnicode(names, test.query, UnicodeMaxCandidates)
+		if len(codePoints) == 0 || codePoints[0] != test.expected {
+			t.Errorf("Search [%s], got %q expected [%c] first", test.query, codePoints, test.expected)
+		}
+		if len(codePoints) > UnicodeMaxCandidates {
+			t.Errorf("Search [%s], got %d candidates expected at most %d", test.query, len(codePoints), UnicodeMaxCandidates)
+		}
+	}
+	if codePoints := searchUnicode(names, "zzzz", UnicodeMaxCandidates); len(codePoints) != 0 {
+		t.Errorf("Search [zzzz], got %q expected none", codePoints)
+	}
+}
@@ -41,18 +41,25 @@ const (
 	VnCaseNoChange
 )
 const (
-	HomePage           = "https://github.com/BambooEngine/ibus-bamboo"
-	CharsetConvertPage = "https://tools.jcisio.com/vietuni/"
-
-	DataDir          = "/usr/share/ibus-bamboo"
-	DictVietnameseCm = "data/vietnamese.cm.dict"
-	DictEmojiOne     = "data/emojione.json"
+	HomePage             = "https://github.com/BambooEngine/ibus-bamboo"
+	ClipboardCharsetAuto = "Tự động nhận dạng"
+
+	DataDir                = "/usr/share/ibus-bamboo"
+	DictVietnameseCm       = "data/vietnamese.cm.dict"
+	DictVietnameseCompound = "data/vietnamese.compound.dict"
+	DictVietnameseNgram    = "data/vietnamese.ngram.dict"
+	DictEmojiOne           = "data/emojione.json"
+	DictUnicodeNames       = "data/unicode.names.txt"
+	DictEmojiKeywordsVi    = "data/emoji.vi.txt"
 )
 
 const (
 	configDir        = "%s/.config/ibus-%s"
 	configFile       = "%s/ibus-%s.config.json"
 	mactabFile       = "%s/ibus-%s.macro.text"
+	mactabAppDir     = "%s/ibus-%s.macro.d"
+	mactabAppExt     = ".text"
+	systemMactabFile = "/etc/ibus-bamboo/macro.text"
 	sampleMactabFile = "data/macro.tpl.txt"
 )
 
@@ -73,6 +80,7 @@ const (
 	KSViEnSwitch
 	KSEmojiDialog
 	KSHexadecimal
+	KSDiacriticRestoration
 )
 
 const (
@@ -95,6 +103,12 @@ const (
 	_IBimQuickSwitchEnabled     //deprecated
 	_IBrestoreKeyStrokesEnabled //deprecated
 	IBmouseCapturing
+	IBwordSuggestion
+	IBfrequencyLearning
+	IBdiacriticRestoration
+	IBtypoCorrection
+	IBemojiShortcode
+	IBmacroCandidates
 	IBstdFlags = IBspellCheckEnabled | IBspellCheckWithRules | IBautoNonVnRestore | IBddFreeStyle |
 		IBmouseCapturing | IBautoCapitalizeMacro | IBnoUnderline
 )
@@ -138,9 +152,15 @@ type Config struct {
 	OutputCharset          string
 	Flags                  uint
 	IBflags                uint
-	Shortcuts              [10]uint32
+	Shortcuts              [12]uint32
 	DefaultInputMode       int
 	InputModeMapping       map[string]int
+	SpellingProfile        string
+	ClipboardSourceCharset string
+	ClipboardTargetCharset string
+	UnicodeOutputForm      string
+	EmojiSkinTone          int
+	MacroTeamFile          string
 }
 
 func getConfigDir(ngName string) string {
@@ -168,39 +188,69 @@ func defaultCfg() Config {
 		InputMethodDefinitions: bamboo.GetInputMethodDefinitions(),
 		Flags:                  bamboo.EstdFlags,
 		IBflags:                IBstdFlags,
-		Shortcuts:              [10]uint32{1, 126, 0, 0, 0, 0, 0, 0, 5, 117},
+		Shortcuts:              [12]uint32{1, 126, 0, 0, 0, 0, 0, 0, 5, 117, 0, 0},
 		DefaultInputMode:       preeditIM,
 		InputModeMapping:       map[string]int{},
+		SpellingProfile:        bamboo.StrictSpellingProfile.Name,
+		ClipboardSourceCharset: ClipboardCharsetAuto,
+		ClipboardTargetCharset: bamboo.UNICODE,
+		UnicodeOutputForm:      UnicodeFormCharacter,
 	}
 }
 
 func loadConfig(engineName string) *Config {
+	setupConfigDir(engineName)
+	var c, _ = readConfig(engineName)
+	return c
+}
+
+// readConfig reads the config file over the default config, an error is
+// returned if the file cannot be read or parsed
+func readConfig(engineName string) (*Config, error) {
 	var c = defaultCfg()
 	if engineName == "bamboous" {
 		c.DefaultInputMode = usIM
 		c.Flags = 0
 	}
 
-	setupConfigDir(engineName)
 	data, err := ioutil.ReadFile(getConfigPath(engineName))
 	if err == nil {
-		json.Unmarshal(data, &c)
+		err = json.Unmarshal(data, &c)
+		logInputMethodErrors(c.InputMethodDefinitions)
 	}
 
-	return &c
+	return &c, err
 }
 
-func saveConfig(c *Config, engineName string) {
+// saveConfig replaces the config file by a rename, so the file watchers never
+// read a half written file. It returns the info of the written file, or nil
+func saveConfig(c *Config, engineName string) os.FileInfo {
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
-		return
+		return nil
 	}
 
-	err = ioutil.WriteFile(fmt.Sprintf(configFile, getConfigDir(engineName), engineName), data, 0644)
-	if err != nil {
+	var configPath = getConfigPath(engineName)
+	var tmpFile = configPath + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		log.Println(err)
+		return nil
+	}
+	if err = os.Rename(tmpFile, configPath); err != nil {
 		log.Println(err)
+		return nil
 	}
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return nil
+	}
+	return info
+}
 
+// getDataFile returns the path of a dictionary file in the data directory,
+// the working directory is only the data directory under ibus
+func getDataFile(fileName string) string {
+	return filepath.Join(DataDir, fileName)
 }
 
 func getEngineSubFile(fileName string) string {
@@ -285,6 +335,19 @@ func isValidCharset(str string) bool {
 	return false
 }
 
+// convertCharset converts a text between two charsets, the source charset is
+// guessed from the text if it is ClipboardCharsetAuto. It returns false if the
+// text is guessed to be in the target charset already, there is nothing to do.
+func convertCharset(from, to, text string) (string, bool) {
+	if from == ClipboardCharsetAuto {
+		from = bamboo.DetectCharset(text)
+		if from == to {
+			return text, false
+		}
+	}
+	return bamboo.Encode(to, bamboo.Decode(from, text)), true
+}
+
 type byString []string
 
 func (s byString) Less(i, j int) bool {
@@ -303,8 +366,8 @@ func sortStrings(list []string) []string {
 	return strList
 }
 
-func loadDictionary(dataFiles ...string) (map[string]bool, error) {
-	var data = map[string]bool{}
+func loadDictionary(dataFiles ...string) ([]string, error) {
+	var data []string
 	for _, dataFile := range dataFiles {
 		f, err := os.Open(dataFile)
 		if err != nil {
@@ -319,9 +382,7 @@ func loadDictionary(dataFiles ...string) (map[string]bool, error) {
 			if len(line) == 0 {
 				continue
 			}
-			var tmp = []byte(strings.ToLower(string(line)))
-			data[string(tmp)] = true
-			//bamboo.AddTrie(rootWordTrie, []rune(string(line)), false)
+			data = append(data, strings.ToLower(string(line)))
 		}
 		f.Close()
 	}
@@ -18,3 +18,23 @@ func TestSortStringList(t *testing.T) {
 		t.Errorf("Sorting strings, expected %s, got %s", "ca", data[0])
 	}
 }
+
+func TestConvertCharset(t *testing.T) {
+	var tests = []struct {
+		from, to, input, output string
+		converted               bool
+	}{
+		{"TCVN3 (ABC)", "Unicode", "ViÖt Nam", "Việt Nam", true},
+		{ClipboardCharsetAuto, "Unicode", "Vieät Nam ñeïp", "Việt Nam đẹp", true},
+		{ClipboardCharsetAuto, "Unicode", "Đà Nẵng — 100€", "Đà Nẵng — 100€", false},
+		{ClipboardCharsetAuto, "VIQR", "Đà Lạt — 100€", "DDa` La.t — 100€", true},
+		{"Unicode", "Unicode NFD", "ệ", "e\u0323\u0302", true},
+		{"Unicode NFD", "Unicode tổ hợp", "e\u0323\u0302", "ê\u0323", true},
+		{"Unicode", "VIQR", "Đà Lạt", "DDa` La.t", true},
+	}
+	for _, test := range tests {
+		if output, converted := convertCharset(test.from, test.to, test.input); output != test.output || converted != test.converted {
+			t.Errorf("Convert [%s] from %s to %s, got [%q, %v] expected [%q, %v]", test.input, test.from, test.to, output, converted, test.output, test.converted)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// fileWatchDelay lets an editor finish writing a file before it is read,
+	// the events of a file during this delay are handled once
+	fileWatchDelay = 200 * time.Millisecond
+	fileWatchMask  = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+)
+
+var fileWatcher = NewFileWatcher()
+
+type fileWatch struct {
+	path    string
+	handler func()
+	timer   *time.Timer
+}
+
+// FileWatcher calls the handler of a file or a directory when it is written,
+// created, renamed or removed. The parent directories are watched with inotify
+// so the files which are replaced by a rename or created later are seen too.
+// A single goroutine reads the events while there is a watch.
+type FileWatcher struct {
+	sync.Mutex
+	file    *os.File
+	fd      int
+	dirs    map[int]string
+	watches map[*fileWatch]bool
+}
+
+func NewFileWatcher() *FileWatcher {
+	return &FileWatcher{watches: map[*fileWatch]bool{}}
+}
+
+// Watch calls the handler after the changes of a path, it returns the
+// function which stops the watch
+func (w *FileWatcher) Watch(path string, handler func()) func() {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	var watch = &fileWatch{path: path, handler: handler}
+	w.Lock()
+	defer w.Unlock()
+	if err := w.start(); err != nil {
+		log.Println("Failed to watch", path, err)
+		return func() {}
+	}
+	w.watches[watch] = true
+	w.addWatches(path)
+	return func() {
+		w.Lock()
+		defer w.Unlock()
+		if !w.watches[watch] {
+			return
+		}
+		delete(w.watches, watch)
+		if watch.timer != nil {
+			watch.timer.Stop()
+		}
+		if len(w.watches) == 0 {
+			w.stop()
+		}
+	}
+}
+
+func (w *FileWatcher) start() error {
+	if w.file != nil {
+		return nil
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// a non-blocking file is read through the poller of the runtime, so
+	// closing it ends the pending read
+	w.file = os.NewFile(uintptr(fd), "inotify")
+	w.fd = fd
+	w.dirs = map[int]string{}
+	go w.run(w.file)
+	return nil
+}
+
+func (w *FileWatcher) stop() {
+	w.file.Close()
+	w.file = nil
+	w.dirs = nil
+}
+
+// addWatches watches the directory of a path, or its nearest ancestor if
+// the directory does not exist yet, and the path itself if it is a directory
+func (w *FileWatcher) addWatches(path string) {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if w.addDir(dir) || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if sta, err := os.Stat(path); err == nil && sta.IsDir() {
+		w.addDir(path)
+	}
+}
+
+func (w *FileWatcher) addDir(dir string) bool {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, fileWatchMask)
+	if err != nil {
+		return false
+	}
+	w.dirs[wd] = dir
+	return true
+}
+
+func (w *FileWatcher) run(file *os.File) {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		n, err := file.Read(buf[:])
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			var event = (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			var start = offset + syscall.SizeofInotifyEvent
+			var name = string(bytes.TrimRight(buf[start:start+int(event.Len)], "\x00"))
+			offset = start + int(event.Len)
+			w.dispatch(file, int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+func (w *FileWatcher) dispatch(file *os.File, wd int, mask uint32, name string) {
+	w.Lock()
+	defer w.Unlock()
+	if w.file != file {
+		return
+	}
+	var dir, found = w.dirs[wd]
+	if !found {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		// the directory was removed, its ancestor is watched until it comes back
+		delete(w.dirs, wd)
+		for watch := range w.watches {
+			w.addWatches(watch.path)
+		}
+		return
+	}
+	var path = dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	for watch := range w.watches {
+		if path == watch.path || strings.HasPrefix(path, watch.path+"/") {
+			w.schedule(watch)
+		} else if mask&syscall.IN_ISDIR != 0 && strings.HasPrefix(watch.path, path+"/") {
+			// a missing directory of the path was created
+			w.addWatches(watch.path)
+			w.schedule(watch)
+		}
+	}
+}
+
+func (w *FileWatcher) schedule(watch *fileWatch) {
+	if watch.timer != nil {
+		watch.timer.Reset(fileWatchDelay)
+		return
+	}
+	watch.timer = time.AfterFunc(fileWatchDelay, func() {
+		w.Lock()
+		var active = w.watches[watch]
+		w.Unlock()
+		if active {
+			watch.handler()
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForCalls(t *testing.T, calls chan string, expected string) {
+	select {
+	case name := <-calls:
+		if name != expected {
+			t.Errorf("Process [%s], got [%s] expected [%s]", expected, name, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Process [%s], got no call expected [%s]", expected, expected)
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	var dir = t.TempDir()
+	var w = NewFileWatcher()
+	var calls = make(chan string, 10)
+	var fileName = filepath.Join(dir, "sub", "macro.text")
+	var appDir = filepath.Join(dir, "apps")
+	os.Mkdir(appDir, 0755)
+	var stopFile = w.Watch(fileName, func() { calls <- "file" })
+	var stopDir = w.Watch(appDir, func() { calls <- "dir" })
+
+	// the file is created in a directory which did not exist
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(fileName, []byte("a"), 0644)
+	waitForCalls(t, calls, "file")
+
+	// the file is replaced by a rename like saveConfig does
+	ioutil.WriteFile(fileName+".tmp", []byte("b"), 0644)
+	os.Rename(fileName+".tmp", fileName)
+	waitForCalls(t, calls, "file")
+
+	ioutil.WriteFile(filepath.Join(appDir, "firefox.text"), []byte("c"), 0644)
+	waitForCalls(t, calls, "dir")
+
+	stopFile()
+	stopDir()
+	stopDir()
+	if w.file != nil {
+		t.Errorf("Process [stop], got [%v] expected the inotify file to be closed", w.file)
+	}
+	ioutil.WriteFile(fileName, []byte("d"), 0644)
+	select {
+	case name := <-calls:
+		t.Errorf("Process [stop], got [%s] expected no call", name)
+	case <-time.After(2 * fileWatchDelay):
+	}
+}
+
+func TestIsSameFileVersion(t *testing.T) {
+	var fileName = filepath.Join(t.TempDir(), "ibus-bamboo.config.json")
+	ioutil.WriteFile(fileName+".tmp", []byte("{}"), 0644)
+	os.Rename(fileName+".tmp", fileName)
+	var saved, _ = os.Stat(fileName)
+	if info, _ := os.Stat(fileName); !isSameFileVersion(info, saved) {
+		t.Errorf("Process [saved], got [false] expected [true]")
+	}
+	// a config written by another process replaces the file too
+	ioutil.WriteFile(fileName+".tmp", []byte("{}"), 0644)
+	os.Rename(fileName+".tmp", fileName)
+	if info, _ := os.Stat(fileName); isSameFileVersion(info, saved) {
+		t.Errorf("Process [replaced], got [true] expected [false]")
+	}
+	saved, _ = os.Stat(fileName)
+	ioutil.WriteFile(fileName, []byte("{ }"), 0644)
+	if info, _ := os.Stat(fileName); isSameFileVersion(info, saved) {
+		t.Errorf("Process [written in place], got [true] expected [false]")
+	}
+}
+
+func TestMacroTableWatchLayers(t *testing.T) {
+	var dir = t.TempDir()
+	var fileName = filepath.Join(dir, "user.text")
+	var appDir = filepath.Join(dir, "apps")
+	ioutil.WriteFile(fileName, []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam\n"), 0644)
+	var table = NewMacroTable(false)
+	table.watchLayers([]string{fileName}, appDir)
+	if text := table.GetText("vn"); text != "Việt Nam" {
+		t.Errorf("Process [vn], got [%s] expected [Việt Nam]", text)
+	}
+
+	ioutil.WriteFile(fileName+".tmp", []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam!\n"), 0644)
+	os.Rename(fileName+".tmp", fileName)
+	var deadline = time.Now().Add(3 * time.Second)
+	for table.GetText("vn") != "Việt Nam!" && time.Now().Before(deadline) {
+		time.Sleep(fileWatchDelay / 4)
+	}
+	if text := table.GetText("vn"); text != "Việt Nam!" {
+		t.Errorf("Process [reload], got [%s] expected [Việt Nam!]", text)
+	}
+
+	os.Mkdir(appDir, 0755)
+	ioutil.WriteFile(filepath.Join(appDir, "ehr.text"), []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nha:huyết áp\n"), 0644)
+	table.SetApplication("ehr:Ehr")
+	deadline = time.Now().Add(3 * time.Second)
+	for table.GetText("ha") == "" && time.Now().Before(deadline) {
+		time.Sleep(fileWatchDelay / 4)
+	}
+	if text := table.GetText("ha"); text != "huyết áp" {
+		t.Errorf("Process [app], got [%s] expected [huyết áp]", text)
+	}
+
+	// a file written by the engine is loaded without waiting for the watcher
+	ioutil.WriteFile(fileName, []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt Nam!\nhn:Hà Nội\n"), 0644)
+	table.ReloadLayers()
+	if text := table.GetText("hn"); text != "Hà Nội" {
+		t.Errorf("Process [ReloadLayers], got [%s] expected [Hà Nội]", text)
+	}
+
+	table.Disable()
+	table.ReloadLayers()
+	if len(table.stopWatches) != 0 || table.GetText("vn") != "" {
+		t.Errorf("Process [disable], got [%d %s] expected no watch and no macro", len(table.stopWatches), table.GetText("vn"))
+	}
+	ioutil.WriteFile(fileName, []byte("# DO NOT DELETE THIS LINE*** version=2 ***\nvn:Việt\n"), 0644)
+	time.Sleep(2 * fileWatchDelay)
+	if text := table.GetText("vn"); text != "" {
+		t.Errorf("Process [disable], got [%s] expected []", text)
+	}
+}
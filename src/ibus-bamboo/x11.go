@@ -25,6 +25,7 @@ package main
 #include <stdlib.h>
 
 extern void x11Copy(char*);
+extern char* x11GetClipboardText();
 extern void x11Paste(int);
 extern void clipboard_init();
 extern void clipboard_exit();
@@ -40,6 +41,7 @@ extern void x11SendShiftR();
 extern void x11SendShiftLeft(int n, int r, int timeout);
 extern void setXIgnoreErrorHandler();
 extern char* x11GetFocusWindowClass();
+extern unsigned long x11GetFocusWindow();
 extern void x11StartWindowInspector();
 extern void x11StopWindowInspector();
 */
@@ -127,6 +129,15 @@ func x11Copy(str string) {
 	C.x11Copy(cs)
 }
 
+func x11GetClipboardText() string {
+	var cs = C.x11GetClipboardText()
+	if cs == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(cs))
+	return C.GoString(cs)
+}
+
 func x11ClipboardInit() {
 	C.clipboard_init()
 }
@@ -166,3 +177,7 @@ func x11GetFocusWindowClass() string {
 	}
 	return ""
 }
+
+func x11GetFocusWindow() uint64 {
+	return uint64(C.x11GetFocusWindow())
+}
@@ -22,13 +22,16 @@
 #include <pthread.h>
 #include <stdlib.h>
 #include <stdio.h>
-#define MAX_TEXT_LEN 100
+#include <limits.h>
+#include <unistd.h>
+#define CLIPBOARD_READ_TIMEOUT 100 // x 10ms
 
 static pthread_t th_clipboard;
 static int clipboard_running;
 static char * text = NULL;
 static char * old_text = NULL;
 static int done = 0;
+static pthread_mutex_t text_mutex = PTHREAD_MUTEX_INITIALIZER;
 
 Atom targets_atom, text_atom, UTF8, XA_ATOM = 4, XA_STRING = 31;
 
@@ -72,7 +75,11 @@ static void* thread_clipboard_copy(void* data) {
                     XSendEvent (display, ev.requestor, 0, 0, (XEvent *)&ev);
                     break;
                 }
-                if (text == NULL) break;
+                pthread_mutex_lock(&text_mutex);
+                if (text == NULL) {
+                    pthread_mutex_unlock(&text_mutex);
+                    break;
+                }
                 int size = strlen(text);
                 if (ev.target == targets_atom) {
                     R = XChangeProperty (ev.display, ev.requestor, ev.property, XA_ATOM, 32, PropModeReplace, (unsigned char*)&UTF8, 1);
@@ -86,6 +93,7 @@ static void* thread_clipboard_copy(void* data) {
                     done = 1;
                 }
                 else ev.property = None;
+                pthread_mutex_unlock(&text_mutex);
                 if ((R & 2) == 0) XSendEvent (display, ev.requestor, 0, 0, (XEvent *)&ev);
                 break;
             case SelectionClear:
@@ -112,21 +120,69 @@ void clipboard_init()
     }
 }
 
+static void set_text(const char *str) {
+    char *new_text = strdup(str);
+    pthread_mutex_lock(&text_mutex);
+    char *old = text;
+    text = new_text;
+    pthread_mutex_unlock(&text_mutex);
+    free(old);
+}
+
 void x11ClipboardReset() {
-    if (text == NULL) {
-        text = (char*)calloc(MAX_TEXT_LEN, sizeof(char));
-    }
-    strcpy(text, "");
+    set_text("");
 }
 
 void x11Copy(char *str) {
-    if (text == NULL) {
-        text = (char*)calloc(MAX_TEXT_LEN, sizeof(char));
-    }
-    strcpy(text, str);
+    set_text(str);
     done = 0;
-    fprintf(stderr, "...x11Clipboard text=%s, clipboard_running=%d\n", text, clipboard_running);
     if (clipboard_running == 0) {
         clipboard_init();
     }
 }
+
+// x11GetClipboardText asks the owner of the CLIPBOARD selection for its text,
+// the caller must free the result. NULL is returned if there is no text or the
+// owner does not answer in time.
+char* x11GetClipboardText() {
+    Display* display = XOpenDisplay(0);
+    if (!display) {
+        return NULL;
+    }
+    int N = DefaultScreen(display);
+    Window window = XCreateSimpleWindow(display, RootWindow(display, N), 0, 0, 1, 1, 0,
+        BlackPixel(display, N), WhitePixel(display, N));
+    Atom selection = XInternAtom(display, "CLIPBOARD", 0);
+    Atom utf8 = XInternAtom(display, "UTF8_STRING", 0);
+    Atom incr = XInternAtom(display, "INCR", 0);
+    Atom property = XInternAtom(display, "BAMBOO_CLIPBOARD", 0);
+    char* result = NULL;
+    XEvent event;
+
+    XConvertSelection(display, selection, utf8, property, window, CurrentTime);
+    XFlush(display);
+    for (int i = 0; i < CLIPBOARD_READ_TIMEOUT; i++) {
+        if (!XCheckTypedWindowEvent(display, window, SelectionNotify, &event)) {
+            usleep(10000);
+            continue;
+        }
+        if (event.xselection.property != None) {
+            Atom type;
+            int format;
+            unsigned long nitems, remaining;
+            unsigned char* data = NULL;
+            if (XGetWindowProperty(display, window, property, 0, LONG_MAX/4, True, AnyPropertyType,
+                    &type, &format, &nitems, &remaining, &data) == Success && data != NULL) {
+                // large texts are sent in chunks (INCR), they are not supported
+                if (type != incr && format == 8) {
+                    result = strndup((char*)data, nitems);
+                }
+                XFree(data);
+            }
+        }
+        break;
+    }
+    XDestroyWindow(display, window);
+    XCloseDisplay(display);
+    return result;
+}
@@ -104,6 +104,18 @@ char * x11GetFocusWindowClass() {
     return wm;
 }
 
+unsigned long x11GetFocusWindow() {
+    Display * dpy = XOpenDisplay(NULL);
+    if (dpy == NULL) {
+        return 0;
+    }
+    Window w;
+    int revertTo;
+    XGetInputFocus(dpy, &w, &revertTo);
+    XCloseDisplay(dpy);
+    return w;
+}
+
 static int input_watching = 0;
 static int th_count = 0;
 static void* thread_input_watching(void* data)